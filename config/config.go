@@ -1,10 +1,27 @@
 package config
 
 import (
+	"fmt"
 	"github.com/erfansahebi/lamia_shared/go/common"
 	"github.com/ilyakaznacheev/cleanenv"
 )
 
+const (
+	AuthorizationTokenTypeOpaque = "opaque"
+	AuthorizationTokenTypeJWT    = "jwt"
+
+	JWTKeySourceStatic   = "static"
+	JWTKeySourceDatabase = "database"
+
+	EmailVerificationPolicyAllow      = "allow"
+	EmailVerificationPolicyRestricted = "restricted"
+	EmailVerificationPolicyBlock      = "block"
+
+	EmailOTPModeOff      = "off"
+	EmailOTPModeFallback = "fallback"
+	EmailOTPModeAlways   = "always"
+)
+
 type Config struct {
 	common.Config
 
@@ -17,7 +34,182 @@ type Config struct {
 	}
 
 	AuthorizationToken struct {
-		Duration uint `env:"AUTHORIZATION_TOKEN_EXPIRE_DURATION_MINUTE"`
+		Duration    uint   `env:"AUTHORIZATION_TOKEN_EXPIRE_DURATION_MINUTE"`
+		Type        string `env:"AUTHORIZATION_TOKEN_TYPE" env-default:"opaque"`
+		IdleTimeout uint   `env:"AUTHORIZATION_TOKEN_IDLE_TIMEOUT_MINUTE"`
+		MaxLifetime uint   `env:"AUTHORIZATION_TOKEN_MAX_LIFETIME_MINUTE"`
+	}
+
+	// TokenHash.Secret keys the HMAC tokens are stored under. Deployments
+	// that predate it keep working with their JWT_SECRET.
+	TokenHash struct {
+		Secret         string `env:"TOKEN_HASH_SECRET"`
+		LegacyFallback bool   `env:"TOKEN_HASH_LEGACY_FALLBACK" env-default:"true"`
+	}
+
+	RefreshToken struct {
+		Duration uint `env:"REFRESH_TOKEN_EXPIRE_DURATION_MINUTE"`
+	}
+
+	PasswordHash struct {
+		Algorithm         string `env:"PASSWORD_HASH_ALGORITHM" env-default:"argon2id"`
+		Argon2Memory      uint32 `env:"PASSWORD_HASH_ARGON2_MEMORY_KIB" env-default:"65536"`
+		Argon2Iterations  uint32 `env:"PASSWORD_HASH_ARGON2_ITERATIONS" env-default:"3"`
+		Argon2Parallelism uint8  `env:"PASSWORD_HASH_ARGON2_PARALLELISM" env-default:"2"`
+		BcryptCost        int    `env:"PASSWORD_HASH_BCRYPT_COST" env-default:"12"`
+	}
+
+	PasswordPolicy struct {
+		MinLength            int  `env:"PASSWORD_POLICY_MIN_LENGTH" env-default:"8"`
+		MaxLength            int  `env:"PASSWORD_POLICY_MAX_LENGTH" env-default:"128"`
+		RequireLowercase     bool `env:"PASSWORD_POLICY_REQUIRE_LOWERCASE" env-default:"false"`
+		RequireUppercase     bool `env:"PASSWORD_POLICY_REQUIRE_UPPERCASE" env-default:"false"`
+		RequireDigit         bool `env:"PASSWORD_POLICY_REQUIRE_DIGIT" env-default:"false"`
+		RequireSymbol        bool `env:"PASSWORD_POLICY_REQUIRE_SYMBOL" env-default:"false"`
+		DisallowPersonalInfo bool `env:"PASSWORD_POLICY_DISALLOW_PERSONAL_INFO" env-default:"true"`
+		DisallowCommon       bool `env:"PASSWORD_POLICY_DISALLOW_COMMON" env-default:"true"`
+		CommonPasswordLimit  int  `env:"PASSWORD_POLICY_COMMON_PASSWORD_LIMIT" env-default:"0"`
+	}
+
+	PwnedPasswords struct {
+		Source   string `env:"PWNED_PASSWORDS_SOURCE"`
+		Mode     string `env:"PWNED_PASSWORDS_MODE" env-default:"reject"`
+		MinCount uint32 `env:"PWNED_PASSWORDS_MIN_COUNT" env-default:"1"`
+	}
+
+	LoginThrottle struct {
+		Window                  uint  `env:"LOGIN_FAILURE_WINDOW_MINUTE" env-default:"15"`
+		BackoffBase             uint  `env:"LOGIN_BACKOFF_BASE_SECOND" env-default:"1"`
+		AccountBackoffThreshold int64 `env:"LOGIN_ACCOUNT_BACKOFF_THRESHOLD" env-default:"3"`
+		AccountLockoutThreshold int64 `env:"LOGIN_ACCOUNT_LOCKOUT_THRESHOLD" env-default:"10"`
+		IPBackoffThreshold      int64 `env:"LOGIN_IP_BACKOFF_THRESHOLD" env-default:"20"`
+		IPLockoutThreshold      int64 `env:"LOGIN_IP_LOCKOUT_THRESHOLD" env-default:"100"`
+		LockoutDuration         uint  `env:"LOGIN_LOCKOUT_DURATION_MINUTE" env-default:"15"`
+	}
+
+	// MFA.EncryptionKey is the base64 encoded 32 byte key TOTP secrets are
+	// encrypted with before they are stored.
+	MFA struct {
+		Issuer               string `env:"MFA_ISSUER" env-default:"Lamia"`
+		EncryptionKey        string `env:"MFA_ENCRYPTION_KEY"`
+		ChallengeDuration    uint   `env:"MFA_CHALLENGE_EXPIRE_DURATION_MINUTE" env-default:"5"`
+		ChallengeMaxAttempts int64  `env:"MFA_CHALLENGE_MAX_ATTEMPTS" env-default:"5"`
+		RecoveryCodeCount    int    `env:"MFA_RECOVERY_CODE_COUNT" env-default:"10"`
+	}
+
+	// EmailOTP.Mode decides when users with a verified email and neither an
+	// authenticator app nor a passkey can answer a second factor with a code
+	// sent to it: off never, fallback wherever a second factor is asked for
+	// anyway, and always also makes it required on every login.
+	EmailOTP struct {
+		Mode           string `env:"EMAIL_OTP_MODE" env-default:"off"`
+		Duration       uint   `env:"EMAIL_OTP_EXPIRE_DURATION_MINUTE" env-default:"10"`
+		MaxAttempts    int64  `env:"EMAIL_OTP_MAX_ATTEMPTS" env-default:"5"`
+		ResendCooldown uint   `env:"EMAIL_OTP_RESEND_COOLDOWN_SECOND" env-default:"60"`
+	}
+
+	// WebAuthn.RPID is the domain passkeys are scoped to and Origins the web
+	// origins, such as https://example.com, ceremonies may come from.
+	WebAuthn struct {
+		RPID    string   `env:"WEBAUTHN_RP_ID"`
+		RPName  string   `env:"WEBAUTHN_RP_NAME" env-default:"Lamia"`
+		Origins []string `env:"WEBAUTHN_ORIGINS" env-separator:","`
+		Timeout uint     `env:"WEBAUTHN_TIMEOUT_SECOND" env-default:"300"`
+	}
+
+	StepUp struct {
+		Duration uint `env:"STEP_UP_DURATION_MINUTE" env-default:"10"`
+	}
+
+	// RateLimit.Rules maps RPC names to token bucket rules, e.g.
+	// "*:100/1s,Login:10/1m,Authenticate:none". The * rule applies to RPCs
+	// without a rule of their own.
+	RateLimit struct {
+		Enabled bool              `env:"RATE_LIMIT_ENABLED" env-default:"true"`
+		Rules   map[string]string `env:"RATE_LIMIT_RULES" env-default:"*:100/1s,Register:5/1m,Login:10/1m,RequestPasswordReset:5/15m,ResetPassword:10/15m,ResendVerification:5/15m,VerifyEmail:10/15m,VerifyMFA:10/1m,SendMFAEmailCode:5/15m,StartStepUp:5/15m,StepUp:10/1m,RequestMagicLink:5/15m,BeginPasskeyAssertion:20/1m,FinishPasskeyAssertion:10/1m,OAuthToken:30/1m"`
+	}
+
+	PasswordReset struct {
+		Duration uint   `env:"PASSWORD_RESET_EXPIRE_DURATION_MINUTE" env-default:"30"`
+		URL      string `env:"PASSWORD_RESET_URL"`
+	}
+
+	// MagicLink.AllowSignUp lets links requested for unknown emails create a
+	// passwordless account when they are used.
+	MagicLink struct {
+		Duration    uint   `env:"MAGIC_LINK_EXPIRE_DURATION_MINUTE" env-default:"15"`
+		URL         string `env:"MAGIC_LINK_URL"`
+		AllowSignUp bool   `env:"MAGIC_LINK_ALLOW_SIGN_UP" env-default:"true"`
+	}
+
+	// Web is the HTTP listener next to the gRPC one. It serves the JWKS
+	// document in JWT mode and the OAuth endpoints when they are enabled.
+	Web struct {
+		Host string `env:"WEB_HOST"`
+		Port string `env:"WEB_PORT" env-default:"8080"`
+	}
+
+	// TrustedProxies.CIDRs are the networks of the gateways and load
+	// balancers in front of the service. Anyone can send X-Real-IP or
+	// X-Forwarded-For, so the client address in them is only believed on
+	// connections from these networks.
+	TrustedProxies struct {
+		CIDRs []string `env:"TRUSTED_PROXY_CIDRS" env-separator:","`
+	}
+
+	// OAuth serves an OAuth 2.0 authorization server on the Web listener.
+	// Authorization requests without a signed in user are sent to LoginURL
+	// with the request to return to; the user is recognized by a bearer
+	// access token or the SessionCookie holding one.
+	OAuth struct {
+		Enabled                   bool   `env:"OAUTH_ENABLED" env-default:"false"`
+		Issuer                    string `env:"OAUTH_ISSUER"`
+		LoginURL                  string `env:"OAUTH_LOGIN_URL"`
+		ConsentURL                string `env:"OAUTH_CONSENT_URL"`
+		SessionCookie             string `env:"OAUTH_SESSION_COOKIE" env-default:"lamia_at"`
+		AuthorizationCodeDuration uint   `env:"OAUTH_AUTHORIZATION_CODE_EXPIRE_DURATION_SECOND" env-default:"60"`
+		ConsentDuration           uint   `env:"OAUTH_CONSENT_EXPIRE_DURATION_SECOND" env-default:"600"`
+	}
+
+	EmailVerification struct {
+		Policy         string `env:"EMAIL_VERIFICATION_POLICY" env-default:"allow"`
+		Duration       uint   `env:"EMAIL_VERIFICATION_EXPIRE_DURATION_MINUTE" env-default:"1440"`
+		ResendCooldown uint   `env:"EMAIL_VERIFICATION_RESEND_COOLDOWN_SECOND" env-default:"60"`
+		URL            string `env:"EMAIL_VERIFICATION_URL"`
+	}
+
+	Notify struct {
+		Backend       string `env:"NOTIFY_BACKEND" env-default:"log"`
+		DefaultLocale string `env:"NOTIFY_DEFAULT_LOCALE" env-default:"en"`
+		OutboxDir     string `env:"NOTIFY_OUTBOX_DIR" env-default:"outbox"`
+		Workers       int    `env:"NOTIFY_WORKERS" env-default:"2"`
+		QueueSize     int    `env:"NOTIFY_QUEUE_SIZE" env-default:"100"`
+		MaxAttempts   int    `env:"NOTIFY_MAX_ATTEMPTS" env-default:"5"`
+		RetryBackoff  uint   `env:"NOTIFY_RETRY_BACKOFF_SECOND" env-default:"2"`
+	}
+
+	SMTP struct {
+		Host     string `env:"SMTP_HOST"`
+		Port     string `env:"SMTP_PORT" env-default:"587"`
+		Username string `env:"SMTP_USERNAME"`
+		Password string `env:"SMTP_PASSWORD"`
+		From     string `env:"SMTP_FROM"`
+	}
+
+	Session struct {
+		LastUsedUpdateInterval uint `env:"SESSION_LAST_USED_UPDATE_INTERVAL_SECOND" env-default:"60"`
+	}
+
+	JWT struct {
+		Secret         string `env:"JWT_SECRET"`
+		Duration       uint   `env:"JWT_EXPIRE_DURATION_MINUTE"`
+		Algorithm      string `env:"JWT_ALGORITHM" env-default:"EdDSA"`
+		PrivateKeyPath string `env:"JWT_PRIVATE_KEY_PATH"`
+		Issuer         string `env:"JWT_ISSUER"`
+
+		KeySource              string `env:"JWT_KEY_SOURCE" env-default:"static"`
+		KeyCacheDuration       uint   `env:"JWT_KEY_CACHE_DURATION_SECOND" env-default:"60"`
+		KeyRotationGracePeriod uint   `env:"JWT_KEY_ROTATION_GRACE_PERIOD_MINUTE" env-default:"1440"`
 	}
 }
 
@@ -28,5 +220,60 @@ func LoadConfig() (*Config, error) {
 		return nil, err
 	}
 
+	if err := configuration.validate(); err != nil {
+		return nil, err
+	}
+
 	return &configuration, nil
 }
+
+// defaultTokenHashSecret is the value the example environment used to ship
+// with, and so the first one anyone would try.
+const defaultTokenHashSecret = "secret"
+
+func (c *Config) validate() error {
+	secretName := "TOKEN_HASH_SECRET"
+	if c.TokenHash.Secret == "" && c.JWT.Secret != "" {
+		secretName = "JWT_SECRET"
+	}
+
+	switch c.TokenHashSecret() {
+	case "":
+		return fmt.Errorf("%s is not set", secretName)
+	case defaultTokenHashSecret:
+		return fmt.Errorf("%s must not be the default %q", secretName, defaultTokenHashSecret)
+	}
+
+	return nil
+}
+
+// TokenHashSecret is the key tokens are hashed with, TOKEN_HASH_SECRET or
+// else JWT_SECRET.
+func (c *Config) TokenHashSecret() string {
+	if c.TokenHash.Secret != "" {
+		return c.TokenHash.Secret
+	}
+
+	return c.JWT.Secret
+}
+
+// AccessTokenDuration is the lifetime of access tokens in minutes. In JWT mode
+// JWT_EXPIRE_DURATION_MINUTE takes precedence when it is set.
+func (c *Config) AccessTokenDuration() uint {
+	if c.AuthorizationToken.Type == AuthorizationTokenTypeJWT && c.JWT.Duration > 0 {
+		return c.JWT.Duration
+	}
+
+	return c.AuthorizationToken.Duration
+}
+
+// AccessTokenMaxLifetime is the absolute lifetime of access tokens in minutes.
+// Sliding expiration never extends a token past it; when it is not set the
+// regular access token lifetime is used as the cap.
+func (c *Config) AccessTokenMaxLifetime() uint {
+	if c.AuthorizationToken.MaxLifetime > 0 {
+		return c.AuthorizationToken.MaxLifetime
+	}
+
+	return c.AccessTokenDuration()
+}
@@ -0,0 +1,35 @@
+package config
+
+import (
+	"testing"
+)
+
+func TestTokenHashSecret(t *testing.T) {
+	for _, test := range []struct {
+		name            string
+		tokenHashSecret string
+		jwtSecret       string
+		want            string
+		wantErr         bool
+	}{
+		{name: "token hash secret", tokenHashSecret: "token-hash-secret", jwtSecret: "jwt-secret", want: "token-hash-secret"},
+		{name: "jwt secret", jwtSecret: "jwt-secret", want: "jwt-secret"},
+		{name: "unset", wantErr: true},
+		{name: "default", tokenHashSecret: defaultTokenHashSecret, wantErr: true},
+		{name: "default jwt secret", jwtSecret: defaultTokenHashSecret, wantErr: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			configuration := &Config{}
+			configuration.TokenHash.Secret = test.tokenHashSecret
+			configuration.JWT.Secret = test.jwtSecret
+
+			if err := configuration.validate(); (err != nil) != test.wantErr {
+				t.Fatalf("got error %v, want error %v", err, test.wantErr)
+			}
+
+			if secret := configuration.TokenHashSecret(); !test.wantErr && secret != test.want {
+				t.Fatalf("got %q, want %q", secret, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1 @@
+DROP TABLE signing_keys;
\ No newline at end of file
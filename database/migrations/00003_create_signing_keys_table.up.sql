@@ -0,0 +1,13 @@
+CREATE TABLE signing_keys
+(
+    kid          TEXT PRIMARY KEY,
+    algorithm    TEXT        NOT NULL,
+    private_key  TEXT        NOT NULL,
+    status       TEXT        NOT NULL,
+    created_at   timestamptz NOT NULL DEFAULT NOW(),
+    activated_at timestamptz,
+    retire_at    timestamptz
+);
+
+CREATE UNIQUE INDEX signing_keys_active_idx ON signing_keys (status) WHERE status = 'active';
+CREATE UNIQUE INDEX signing_keys_next_idx ON signing_keys (status) WHERE status = 'next';
\ No newline at end of file
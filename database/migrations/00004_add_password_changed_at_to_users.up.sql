@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ADD COLUMN password_changed_at timestamptz;
\ No newline at end of file
@@ -0,0 +1,19 @@
+CREATE TABLE user_totp
+(
+    user_id          UUID PRIMARY KEY REFERENCES users (id) ON DELETE CASCADE,
+    encrypted_secret BYTEA       NOT NULL,
+    last_used_step   BIGINT      NOT NULL DEFAULT 0,
+    confirmed_at     timestamptz,
+    created_at       timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE mfa_recovery_codes
+(
+    id         UUID                 DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    code_hash  TEXT        NOT NULL,
+    used_at    timestamptz,
+    created_at timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX mfa_recovery_codes_user_code_idx ON mfa_recovery_codes (user_id, code_hash);
\ No newline at end of file
@@ -0,0 +1,6 @@
+UPDATE users
+    SET password = ''
+    WHERE password IS NULL;
+
+ALTER TABLE users
+    ALTER COLUMN password SET NOT NULL;
\ No newline at end of file
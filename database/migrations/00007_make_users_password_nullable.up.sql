@@ -0,0 +1,2 @@
+ALTER TABLE users
+    ALTER COLUMN password DROP NOT NULL;
\ No newline at end of file
@@ -0,0 +1,18 @@
+CREATE TABLE webauthn_credentials
+(
+    id                UUID                 DEFAULT uuid_generate_v4() PRIMARY KEY,
+    user_id           UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    credential_id     BYTEA       NOT NULL,
+    public_key        BYTEA       NOT NULL,
+    sign_count        BIGINT      NOT NULL DEFAULT 0,
+    aaguid            BYTEA,
+    transports        TEXT[]      NOT NULL DEFAULT '{}',
+    name              TEXT        NOT NULL DEFAULT '',
+    clone_detected_at timestamptz,
+    last_used_at      timestamptz,
+    created_at        timestamptz NOT NULL DEFAULT NOW()
+);
+
+CREATE UNIQUE INDEX webauthn_credentials_credential_id_idx ON webauthn_credentials (credential_id);
+
+CREATE INDEX webauthn_credentials_user_id_idx ON webauthn_credentials (user_id);
\ No newline at end of file
@@ -0,0 +1,10 @@
+CREATE TABLE oauth_clients
+(
+    id            UUID                 DEFAULT uuid_generate_v4() PRIMARY KEY,
+    name          TEXT        NOT NULL,
+    secret_hash   TEXT,
+    redirect_uris TEXT[]      NOT NULL DEFAULT '{}',
+    grant_types   TEXT[]      NOT NULL DEFAULT '{}',
+    scopes        TEXT[]      NOT NULL DEFAULT '{}',
+    created_at    timestamptz NOT NULL DEFAULT NOW()
+);
\ No newline at end of file
@@ -0,0 +1,4 @@
+DROP TABLE oauth_consents;
+
+ALTER TABLE oauth_clients
+    DROP COLUMN first_party;
\ No newline at end of file
@@ -0,0 +1,12 @@
+ALTER TABLE oauth_clients
+    ADD COLUMN first_party BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE TABLE oauth_consents
+(
+    user_id    UUID        NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    client_id  UUID        NOT NULL REFERENCES oauth_clients (id) ON DELETE CASCADE,
+    scopes     TEXT[]      NOT NULL DEFAULT '{}',
+    created_at timestamptz NOT NULL DEFAULT NOW(),
+    updated_at timestamptz NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (user_id, client_id)
+);
\ No newline at end of file
@@ -3,16 +3,35 @@ package di
 import (
 	"context"
 	"github.com/erfansahebi/lamia_auth/config"
+	"github.com/erfansahebi/lamia_auth/mfa"
+	"github.com/erfansahebi/lamia_auth/notify"
+	"github.com/erfansahebi/lamia_auth/password"
+	"github.com/erfansahebi/lamia_auth/pwned"
+	"github.com/erfansahebi/lamia_auth/ratelimit"
 	"github.com/erfansahebi/lamia_auth/svc"
+	"github.com/erfansahebi/lamia_auth/webauthn"
 	"github.com/erfansahebi/lamia_shared/go/log"
 	"github.com/jackc/pgx/v4/pgxpool"
 	"github.com/redis/go-redis/v9"
+	"net/netip"
+	"strings"
+	"time"
 )
 
 type DIContainerInterface interface {
 	Config() *config.Config
 
 	AuthDAL() svc.AuthDALInterface
+	JWTIssuer() svc.JWTIssuerInterface
+	SigningKeyStore() svc.SigningKeyStoreInterface
+	PasswordHasher() password.HasherInterface
+	PasswordPolicy() password.PolicyInterface
+	PwnedChecker() pwned.CheckerInterface
+	Notifier() notify.NotifierInterface
+	RateLimiter() ratelimit.LimiterInterface
+	MFACipher() mfa.CipherInterface
+	RelyingParty() webauthn.RelyingPartyInterface
+	TrustedProxies() []netip.Prefix
 
 	Service() AuthServiceInterface
 }
@@ -21,7 +40,18 @@ type diContainer struct {
 	ctx           context.Context
 	configuration *config.Config
 
-	authDAL svc.AuthDALInterface
+	authDAL   svc.AuthDALInterface
+	jwtIssuer svc.JWTIssuerInterface
+
+	signingKeyStore svc.SigningKeyStoreInterface
+	passwordHasher  password.HasherInterface
+	passwordPolicy  password.PolicyInterface
+	pwnedChecker    pwned.CheckerInterface
+	notifier        notify.NotifierInterface
+	rateLimiter     ratelimit.LimiterInterface
+	mfaCipher       mfa.CipherInterface
+	relyingParty    webauthn.RelyingPartyInterface
+	trustedProxies  []netip.Prefix
 
 	service AuthServiceInterface
 
@@ -95,7 +125,311 @@ func (d *diContainer) initAuthDAL() error {
 		return err
 	}
 
-	d.authDAL = svc.NewAuthDAL(pgxConn, d.getRedisClient())
+	d.authDAL = svc.NewAuthDAL(
+		pgxConn,
+		d.getRedisClient(),
+		d.JWTIssuer(),
+		d.configuration.TokenHashSecret(),
+		d.configuration.TokenHash.LegacyFallback,
+	)
+
+	return nil
+}
+
+func (d *diContainer) JWTIssuer() svc.JWTIssuerInterface {
+	if err := d.initJWTIssuer(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init jwt issuer")
+		panic(err)
+	}
+
+	return d.jwtIssuer
+}
+
+func (d *diContainer) initJWTIssuer() error {
+	if d.jwtIssuer != nil || d.configuration.AuthorizationToken.Type != config.AuthorizationTokenTypeJWT {
+		return nil
+	}
+
+	var keys svc.SigningKeyProviderInterface
+
+	switch d.configuration.JWT.KeySource {
+	case config.JWTKeySourceDatabase:
+		keys = d.SigningKeyStore()
+	default:
+		staticKeys, err := svc.NewStaticSigningKeyProvider(
+			d.configuration.JWT.Algorithm,
+			d.configuration.JWT.PrivateKeyPath,
+		)
+		if err != nil {
+			log.WithError(err).Fatalf(d.ctx, "error in load jwt signing key")
+			return err
+		}
+
+		keys = staticKeys
+	}
+
+	d.jwtIssuer = svc.NewJWTIssuer(keys, d.configuration.JWT.Issuer)
+
+	return nil
+}
+
+func (d *diContainer) SigningKeyStore() svc.SigningKeyStoreInterface {
+	if err := d.initSigningKeyStore(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init signing key store")
+		panic(err)
+	}
+
+	return d.signingKeyStore
+}
+
+func (d *diContainer) initSigningKeyStore() error {
+	if d.signingKeyStore != nil {
+		return nil
+	}
+
+	pgxConn, err := d.getPgxConnection("app")
+	if err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in pgx connection")
+		return err
+	}
+
+	d.signingKeyStore = svc.NewSigningKeyStore(pgxConn, time.Duration(d.configuration.JWT.KeyCacheDuration)*time.Second)
+
+	return nil
+}
+
+func (d *diContainer) PasswordHasher() password.HasherInterface {
+	if err := d.initPasswordHasher(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init password hasher")
+		panic(err)
+	}
+
+	return d.passwordHasher
+}
+
+func (d *diContainer) initPasswordHasher() error {
+	if d.passwordHasher != nil {
+		return nil
+	}
+
+	passwordHasher, err := password.NewHasher(password.Config{
+		Algorithm: d.configuration.PasswordHash.Algorithm,
+		Argon2id: password.Argon2idParams{
+			Memory:      d.configuration.PasswordHash.Argon2Memory,
+			Iterations:  d.configuration.PasswordHash.Argon2Iterations,
+			Parallelism: d.configuration.PasswordHash.Argon2Parallelism,
+		},
+		Bcrypt: password.BcryptParams{
+			Cost: d.configuration.PasswordHash.BcryptCost,
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	d.passwordHasher = passwordHasher
+
+	return nil
+}
+
+func (d *diContainer) PasswordPolicy() password.PolicyInterface {
+	if err := d.initPasswordPolicy(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init password policy")
+		panic(err)
+	}
+
+	return d.passwordPolicy
+}
+
+func (d *diContainer) initPasswordPolicy() error {
+	if d.passwordPolicy != nil {
+		return nil
+	}
+
+	d.passwordPolicy = password.NewPolicy(password.PolicyConfig{
+		MinLength:            d.configuration.PasswordPolicy.MinLength,
+		MaxLength:            d.configuration.PasswordPolicy.MaxLength,
+		RequireLowercase:     d.configuration.PasswordPolicy.RequireLowercase,
+		RequireUppercase:     d.configuration.PasswordPolicy.RequireUppercase,
+		RequireDigit:         d.configuration.PasswordPolicy.RequireDigit,
+		RequireSymbol:        d.configuration.PasswordPolicy.RequireSymbol,
+		DisallowPersonalInfo: d.configuration.PasswordPolicy.DisallowPersonalInfo,
+		DisallowCommon:       d.configuration.PasswordPolicy.DisallowCommon,
+		CommonPasswordLimit:  d.configuration.PasswordPolicy.CommonPasswordLimit,
+	})
+
+	return nil
+}
+
+func (d *diContainer) PwnedChecker() pwned.CheckerInterface {
+	if err := d.initPwnedChecker(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init pwned passwords checker")
+		panic(err)
+	}
+
+	return d.pwnedChecker
+}
+
+func (d *diContainer) initPwnedChecker() error {
+	if d.pwnedChecker != nil || d.configuration.PwnedPasswords.Source == "" {
+		return nil
+	}
+
+	pwnedChecker, err := pwned.Open(d.configuration.PwnedPasswords.Source)
+	if err != nil {
+		return err
+	}
+
+	d.pwnedChecker = pwnedChecker
+
+	return nil
+}
+
+func (d *diContainer) Notifier() notify.NotifierInterface {
+	if err := d.initNotifier(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init notifier")
+		panic(err)
+	}
+
+	return d.notifier
+}
+
+func (d *diContainer) initNotifier() error {
+	if d.notifier != nil {
+		return nil
+	}
+
+	notifier, err := notify.NewNotifier(d.ctx, notify.Config{
+		Backend:       d.configuration.Notify.Backend,
+		DefaultLocale: d.configuration.Notify.DefaultLocale,
+		SMTP: notify.SMTPConfig{
+			Host:     d.configuration.SMTP.Host,
+			Port:     d.configuration.SMTP.Port,
+			Username: d.configuration.SMTP.Username,
+			Password: d.configuration.SMTP.Password,
+			From:     d.configuration.SMTP.From,
+		},
+		OutboxDir:    d.configuration.Notify.OutboxDir,
+		Workers:      d.configuration.Notify.Workers,
+		QueueSize:    d.configuration.Notify.QueueSize,
+		MaxAttempts:  d.configuration.Notify.MaxAttempts,
+		RetryBackoff: time.Duration(d.configuration.Notify.RetryBackoff) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.notifier = notifier
+
+	return nil
+}
+
+func (d *diContainer) RateLimiter() ratelimit.LimiterInterface {
+	if err := d.initRateLimiter(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init rate limiter")
+		panic(err)
+	}
+
+	return d.rateLimiter
+}
+
+func (d *diContainer) initRateLimiter() error {
+	if d.rateLimiter != nil || !d.configuration.RateLimit.Enabled {
+		return nil
+	}
+
+	rules, err := ratelimit.ParseRules(d.configuration.RateLimit.Rules)
+	if err != nil {
+		return err
+	}
+
+	d.rateLimiter = ratelimit.NewLimiter(
+		rules,
+		ratelimit.NewRedisStore(d.getRedisClient()),
+		ratelimit.NewMemoryStore(),
+	)
+
+	return nil
+}
+
+func (d *diContainer) MFACipher() mfa.CipherInterface {
+	if err := d.initMFACipher(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init mfa cipher")
+		panic(err)
+	}
+
+	return d.mfaCipher
+}
+
+func (d *diContainer) initMFACipher() error {
+	if d.mfaCipher != nil {
+		return nil
+	}
+
+	mfaCipher, err := mfa.NewCipher(d.configuration.MFA.EncryptionKey)
+	if err != nil {
+		return err
+	}
+
+	d.mfaCipher = mfaCipher
+
+	return nil
+}
+
+func (d *diContainer) RelyingParty() webauthn.RelyingPartyInterface {
+	if err := d.initRelyingParty(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init webauthn relying party")
+		panic(err)
+	}
+
+	return d.relyingParty
+}
+
+func (d *diContainer) initRelyingParty() error {
+	if d.relyingParty != nil {
+		return nil
+	}
+
+	relyingParty, err := webauthn.NewRelyingParty(webauthn.Config{
+		RPID:    d.configuration.WebAuthn.RPID,
+		RPName:  d.configuration.WebAuthn.RPName,
+		Origins: d.configuration.WebAuthn.Origins,
+		Timeout: time.Duration(d.configuration.WebAuthn.Timeout) * time.Second,
+	})
+	if err != nil {
+		return err
+	}
+
+	d.relyingParty = relyingParty
+
+	return nil
+}
+
+func (d *diContainer) TrustedProxies() []netip.Prefix {
+	if err := d.initTrustedProxies(); err != nil {
+		log.WithError(err).Fatalf(d.ctx, "error in init trusted proxies")
+		panic(err)
+	}
+
+	return d.trustedProxies
+}
+
+func (d *diContainer) initTrustedProxies() error {
+	if d.trustedProxies != nil {
+		return nil
+	}
+
+	trustedProxies := make([]netip.Prefix, 0, len(d.configuration.TrustedProxies.CIDRs))
+	for _, cidr := range d.configuration.TrustedProxies.CIDRs {
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(cidr))
+		if err != nil {
+			return err
+		}
+
+		trustedProxies = append(trustedProxies, prefix.Masked())
+	}
+
+	d.trustedProxies = trustedProxies
 
 	return nil
 }
@@ -2,11 +2,13 @@ package handler
 
 import (
 	"context"
+	"github.com/erfansahebi/lamia_auth/config"
 	"github.com/erfansahebi/lamia_auth/handler/validator"
 	"github.com/erfansahebi/lamia_auth/model"
-	authProto "github.com/erfansahebi/lamia_shared/go/proto/auth"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_shared/go/log"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"google.golang.org/protobuf/types/known/timestamppb"
 	"time"
 )
 
@@ -17,14 +19,17 @@ func (h *Handler) Register(ctx context.Context, request *authProto.RegisterReque
 		return nil, err
 	}
 
-	hashedPassword, _ := bcrypt.GenerateFromPassword([]byte(pendData.User.Password), bcrypt.MinCost)
+	hashedPassword, err := h.Di.PasswordHasher().Hash(pendData.User.Password)
+	if err != nil {
+		return nil, err
+	}
 
 	registeredUser, err := h.Di.AuthDAL().StoreUser(ctx, model.User{
 		ID:        uuid.Nil,
 		FirstName: pendData.User.FirstName,
 		LastName:  pendData.User.LastName,
 		Email:     pendData.User.Email,
-		Password:  string(hashedPassword),
+		Password:  hashedPassword,
 		CreatedAt: time.Time{},
 		UpdatedAt: time.Time{},
 	})
@@ -32,51 +37,47 @@ func (h *Handler) Register(ctx context.Context, request *authProto.RegisterReque
 		return nil, err
 	}
 
-	tokenString, err := h.Di.AuthDAL().StoreToken(ctx, model.Token{
-		UserID:    registeredUser.ID,
-		IssuedAt:  time.Time{},
-		ExpiredAt: time.Time{},
-	}, h.Di.Config().AuthorizationToken.Duration)
+	go h.sendEmailVerification(h.AppCtx, registeredUser, localeFromContext(ctx))
+
+	response := &authProto.AuthenticationResponse{
+		User: userResponse(registeredUser),
+	}
+
+	// Blocked users only get their tokens by logging in once verified.
+	if h.Di.Config().EmailVerification.Policy == config.EmailVerificationPolicyBlock {
+		return response, nil
+	}
+
+	response.AuthorizationToken, response.RefreshToken, err = h.issueTokenPair(ctx, registeredUser)
 	if err != nil {
 		return nil, err
 	}
 
-	return &authProto.AuthenticationResponse{
-		User: &authProto.UserStruct{
-			Id:        registeredUser.ID.String(),
-			FirstName: registeredUser.FirstName,
-			LastName:  registeredUser.LastName,
-			Email:     registeredUser.Email,
-			Password:  registeredUser.Password,
-		},
-		AuthorizationToken: tokenString,
-	}, nil
+	return response, nil
 }
 
 func (h *Handler) Login(ctx context.Context, request *authProto.LoginRequest) (*authProto.AuthenticationResponse, error) {
-	pendData := validator.LoginStruct{LoginRequest: request}
+	pendData := validator.LoginStruct{
+		LoginRequest: request,
+		ClientInfo:   clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	}
 	if err := pendData.Validate(ctx, h.Di); err != nil {
 		return nil, err
 	}
 
-	tokenString, err := h.Di.AuthDAL().StoreToken(ctx, model.Token{
-		UserID:    pendData.FetchedUser.ID,
-		IssuedAt:  time.Time{},
-		ExpiredAt: time.Time{},
-	}, h.Di.Config().AuthorizationToken.Duration)
+	if len(pendData.MFAMethods) > 0 {
+		return h.startMFAChallenge(ctx, pendData.FetchedUser, pendData.ClientInfo, pendData.MFAMethods)
+	}
+
+	tokenString, refreshToken, err := h.issueTokenPair(ctx, pendData.FetchedUser)
 	if err != nil {
 		return nil, err
 	}
 
 	return &authProto.AuthenticationResponse{
-		User: &authProto.UserStruct{
-			Id:        pendData.FetchedUser.ID.String(),
-			FirstName: pendData.FetchedUser.FirstName,
-			LastName:  pendData.FetchedUser.LastName,
-			Email:     pendData.FetchedUser.Email,
-			Password:  pendData.FetchedUser.Password,
-		},
+		User:               userResponse(pendData.FetchedUser),
 		AuthorizationToken: tokenString,
+		RefreshToken:       refreshToken,
 	}, nil
 }
 
@@ -92,9 +93,50 @@ func (h *Handler) Authenticate(ctx context.Context, request *authProto.Authentic
 		return nil, err
 	}
 
-	return &authProto.AuthenticateResponse{
-		Id: pendData.TokenDetail.UserID.String(),
-	}, nil
+	lastUsedUpdateInterval := time.Duration(h.Di.Config().Session.LastUsedUpdateInterval) * time.Second
+	idleTimeout := time.Duration(h.Di.Config().AuthorizationToken.IdleTimeout) * time.Minute
+	if _, err := h.Di.AuthDAL().TouchToken(ctx, request.AuthorizationToken, pendData.TokenDetail, lastUsedUpdateInterval, idleTimeout); err != nil {
+		log.WithError(err).Warnf(ctx, "failed to update session last used time")
+	}
+
+	emailVerified := pendData.TokenDetail.EmailVerified
+	if !emailVerified {
+		// The user may have verified since the token was issued.
+		if user, err := h.Di.AuthDAL().FetchUser(ctx, pendData.TokenDetail.UserID); err == nil {
+			emailVerified = user.EmailVerifiedAt != nil
+		}
+	}
+
+	response := &authProto.AuthenticateResponse{
+		Id:            pendData.TokenDetail.UserID.String(),
+		EmailVerified: emailVerified,
+		Restricted:    pendData.TokenDetail.Restricted && !emailVerified,
+		ClientId:      pendData.TokenDetail.ClientID,
+		Scope:         pendData.TokenDetail.Scope,
+	}
+
+	if pendData.TokenDetail.UserID == uuid.Nil {
+		response.Id = ""
+	}
+
+	if pendData.TokenFamily.Elevated() {
+		response.ElevatedUntil = timestamppb.New(*pendData.TokenFamily.ElevatedUntil)
+	}
+
+	return response, nil
+}
+
+func (h *Handler) UnlockAccount(ctx context.Context, request *authProto.UnlockAccountRequest) (*authProto.UnlockAccountResponse, error) {
+	pendData := validator.UnlockAccountStruct{UnlockAccountRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if err := h.Di.AuthDAL().ClearLoginFailures(ctx, pendData.User.Email); err != nil {
+		return nil, err
+	}
+
+	return &authProto.UnlockAccountResponse{}, nil
 }
 
 func (h *Handler) GetUser(ctx context.Context, request *authProto.GetUserRequest) (*authProto.GetUserResponse, error) {
@@ -104,12 +146,111 @@ func (h *Handler) GetUser(ctx context.Context, request *authProto.GetUserRequest
 	}
 
 	return &authProto.GetUserResponse{
-		User: &authProto.UserStruct{
-			Id:        pendData.User.ID.String(),
-			FirstName: pendData.User.FirstName,
-			LastName:  pendData.User.LastName,
-			Email:     pendData.User.Email,
-			Password:  pendData.User.Password,
-		},
+		User: userResponse(pendData.User),
 	}, nil
 }
+
+func (h *Handler) RefreshToken(ctx context.Context, request *authProto.RefreshTokenRequest) (*authProto.AuthenticationResponse, error) {
+	pendData := validator.RefreshTokenStruct{
+		RefreshTokenRequest: request,
+		ClientInfo:          clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	emailVerified, restricted := h.emailVerificationState(pendData.User)
+
+	tokenString, err := h.storeAccessToken(ctx, model.Token{
+		UserID:        pendData.TokenFamily.UserID,
+		FamilyID:      pendData.TokenFamily.ID,
+		ClientInfo:    pendData.ClientInfo,
+		IssuedAt:      time.Time{},
+		ExpiredAt:     time.Time{},
+		EmailVerified: emailVerified,
+		Restricted:    restricted,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.AuthenticationResponse{
+		User:               userResponse(pendData.User),
+		AuthorizationToken: tokenString,
+		RefreshToken:       pendData.NewRefreshToken,
+	}, nil
+}
+
+// issueTokenPair starts a new token family for user and returns its first
+// access and refresh tokens.
+func (h *Handler) issueTokenPair(ctx context.Context, user model.User) (tokenString string, refreshToken string, err error) {
+	return h.issueFamilyTokenPair(ctx, user, model.TokenFamily{
+		UserID:     user.ID,
+		ClientInfo: clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	})
+}
+
+// issueFamilyTokenPair starts tokenFamily for user and returns its first
+// access and refresh tokens, which carry the OAuth client and scope of the
+// family.
+func (h *Handler) issueFamilyTokenPair(ctx context.Context, user model.User, tokenFamily model.TokenFamily) (tokenString string, refreshToken string, err error) {
+	tokenFamily, refreshToken, err = h.Di.AuthDAL().StoreTokenFamily(ctx, tokenFamily, h.Di.Config().RefreshToken.Duration)
+	if err != nil {
+		return "", "", err
+	}
+
+	emailVerified, restricted := h.emailVerificationState(user)
+
+	tokenString, err = h.storeAccessToken(ctx, model.Token{
+		UserID:        user.ID,
+		FamilyID:      tokenFamily.ID,
+		ClientInfo:    tokenFamily.ClientInfo,
+		IssuedAt:      time.Time{},
+		ExpiredAt:     time.Time{},
+		EmailVerified: emailVerified,
+		Restricted:    restricted,
+		ClientID:      tokenFamily.ClientID,
+		Scope:         tokenFamily.Scope,
+	})
+	if err != nil {
+		return "", "", err
+	}
+
+	return tokenString, refreshToken, nil
+}
+
+// emailVerificationState returns whether user has verified their email and
+// whether the tokens handed out to them are restricted because they have not.
+func (h *Handler) emailVerificationState(user model.User) (emailVerified bool, restricted bool) {
+	emailVerified = user.EmailVerifiedAt != nil
+	restricted = !emailVerified && h.Di.Config().EmailVerification.Policy == config.EmailVerificationPolicyRestricted
+
+	return emailVerified, restricted
+}
+
+// storeAccessToken stores tokenDetail with the configured lifetime. With an
+// idle timeout the token starts out living for the idle timeout only and is
+// extended on use up to the absolute lifetime.
+func (h *Handler) storeAccessToken(ctx context.Context, tokenDetail model.Token) (tokenString string, err error) {
+	if h.Di.Config().AuthorizationToken.IdleTimeout > 0 {
+		maxLifetime := h.Di.Config().AccessTokenMaxLifetime()
+		tokenDetail.MaxExpiredAt = time.Now().Add(time.Duration(maxLifetime) * time.Minute)
+	}
+
+	return h.Di.AuthDAL().StoreToken(ctx, tokenDetail, h.accessTokenExpireDuration())
+}
+
+// accessTokenExpireDuration is the initial lifetime of access tokens in
+// minutes.
+func (h *Handler) accessTokenExpireDuration() uint {
+	expireDuration := h.Di.Config().AccessTokenDuration()
+
+	if idleTimeout := h.Di.Config().AuthorizationToken.IdleTimeout; idleTimeout > 0 {
+		expireDuration = idleTimeout
+		if maxLifetime := h.Di.Config().AccessTokenMaxLifetime(); maxLifetime < expireDuration {
+			expireDuration = maxLifetime
+		}
+	}
+
+	return expireDuration
+}
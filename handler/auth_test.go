@@ -0,0 +1,217 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/password"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/netip"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAuthenticate(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal})
+
+	user, err := dal.StoreUser(ctx, model.User{Email: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := h.Authenticate(ctx, &authProto.AuthenticateRequest{AuthorizationToken: tokenString})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if response.Id != user.ID.String() {
+		t.Fatalf("got user %q, want %q", response.Id, user.ID)
+	}
+}
+
+// Access tokens must stop working as soon as their session is gone, even
+// when the token itself has not expired yet.
+func TestAuthenticateRejectsTokenOfEndedSession(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal})
+
+	user, err := dal.StoreUser(ctx, model.User{Email: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenDetail, err := dal.FetchToken(ctx, tokenString)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dal.server.Del(fmt.Sprintf("token_family.%s", tokenDetail.FamilyID))
+
+	if _, err = h.Authenticate(ctx, &authProto.AuthenticateRequest{AuthorizationToken: tokenString}); !errors.Is(err, svc.ErrInvalidToken) {
+		t.Fatalf("got %v, want %v", err, svc.ErrInvalidToken)
+	}
+}
+
+// Expired and logged out tokens have to be reported as Unauthenticated so
+// that clients know to sign in again.
+func TestAuthenticateRejectsLoggedOutToken(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal})
+
+	user, err := dal.StoreUser(ctx, model.User{Email: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.Logout(ctx, &authProto.LogoutRequest{AuthorizationToken: tokenString}); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = h.Authenticate(ctx, &authProto.AuthenticateRequest{AuthorizationToken: tokenString})
+	if st, _ := svc.ErrorStatus(err); st.Code() != codes.Unauthenticated {
+		t.Fatalf("got %v, want code %v", err, codes.Unauthenticated)
+	}
+}
+
+// The IP lock holds the client behind the proxy, not whatever address the
+// client put in X-Forwarded-For, or a new one would unlock every attempt.
+func TestLoginIPLockIgnoresForgedForwardedFor(t *testing.T) {
+	ctx := context.Background()
+
+	configuration := newTestConfig()
+	configuration.LoginThrottle.IPLockoutThreshold = 3
+	configuration.LoginThrottle.LockoutDuration = 15
+
+	h := newTestHandler(t, &testDI{config: configuration, trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+
+	login := func(attempt int) error {
+		md := metadata.Pairs("x-forwarded-for", fmt.Sprintf("198.51.100.%d, 203.0.113.7", attempt))
+		loginCtx := peer.NewContext(metadata.NewIncomingContext(ctx, md), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+
+		_, err := h.Login(loginCtx, &authProto.LoginRequest{Email: fmt.Sprintf("user%d@example.com", attempt), Password: "wrong password"})
+
+		return err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if err := login(attempt); !errors.Is(err, svc.ErrInvalidCredentials) {
+			t.Fatalf("attempt %d: got %v, want %v", attempt, err, svc.ErrInvalidCredentials)
+		}
+	}
+
+	if err := login(3); !errors.Is(err, svc.ErrAccountLocked) {
+		t.Fatalf("got %v, want %v", err, svc.ErrAccountLocked)
+	}
+}
+
+// Failed logins of unknown emails, of existing accounts and of accounts
+// whose hash predates the current parameters have to take about as long as
+// each other, or their timing tells which emails are registered. Samples are
+// interleaved and compared by their medians so that load on the machine
+// running the test affects all of them alike.
+func TestLoginTimingDoesNotRevealAccounts(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping timing test in short mode")
+	}
+
+	ctx := context.Background()
+
+	passwordHasher, err := password.NewHasher(password.Config{
+		Algorithm: password.AlgorithmArgon2id,
+		Argon2id:  password.Argon2idParams{Memory: 16 * 1024, Iterations: 2, Parallelism: 1},
+		Bcrypt:    password.BcryptParams{Cost: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	legacyHasher, err := password.NewHasher(password.Config{
+		Algorithm: password.AlgorithmBcrypt,
+		Argon2id:  password.Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1},
+		Bcrypt:    password.BcryptParams{Cost: 4},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal, passwordHasher: passwordHasher})
+
+	const (
+		unknownEmail = "unknown@example.com"
+		currentEmail = "current@example.com"
+		legacyEmail  = "legacy@example.com"
+	)
+
+	for email, hasher := range map[string]password.HasherInterface{currentEmail: passwordHasher, legacyEmail: legacyHasher} {
+		passwordHash, err := hasher.Hash(testPassword)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = dal.StoreUser(ctx, model.User{Email: email, Password: passwordHash}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	const samples = 15
+
+	emails := []string{unknownEmail, currentEmail, legacyEmail}
+	durations := make(map[string][]time.Duration, len(emails))
+
+	for i := 0; i < samples; i++ {
+		for _, email := range emails {
+			start := time.Now()
+			_, err := h.Login(ctx, &authProto.LoginRequest{Email: email, Password: "wrong password"})
+			elapsed := time.Since(start)
+
+			if !errors.Is(err, svc.ErrInvalidCredentials) {
+				t.Fatalf("login of %s: got %v, want %v", email, err, svc.ErrInvalidCredentials)
+			}
+
+			durations[email] = append(durations[email], elapsed)
+		}
+	}
+
+	baseline := medianDuration(durations[unknownEmail])
+	for _, email := range emails[1:] {
+		median := medianDuration(durations[email])
+
+		if ratio := float64(median) / float64(baseline); ratio < 0.67 || ratio > 1.5 {
+			t.Errorf("failed logins of %s take %v, those of unknown emails %v", email, median, baseline)
+		}
+	}
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	return sorted[len(sorted)/2]
+}
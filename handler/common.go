@@ -3,9 +3,29 @@ package handler
 import (
 	"context"
 	"github.com/erfansahebi/lamia_auth/di"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"net/url"
+	"strings"
 )
 
 type Handler struct {
+	authProto.UnimplementedAuthServiceServer
+
 	AppCtx context.Context
 	Di     di.DIContainerInterface
 }
+
+// tokenURL appends token to the configured page it is handled on, or returns
+// an empty string when no page is configured.
+func tokenURL(baseURL string, token string) string {
+	if baseURL == "" {
+		return ""
+	}
+
+	separator := "?"
+	if strings.Contains(baseURL, "?") {
+		separator = "&"
+	}
+
+	return baseURL + separator + "token=" + url.QueryEscape(token)
+}
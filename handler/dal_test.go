@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testAuthDAL runs the Redis side of the real auth DAL against miniredis and
+// keeps what the real one stores in Postgres in memory.
+type testAuthDAL struct {
+	svc.AuthDALInterface
+
+	server *miniredis.Miniredis
+
+	mu            sync.Mutex
+	users         map[uuid.UUID]model.User
+	credentials   map[uuid.UUID]model.WebAuthnCredential
+	totps         map[uuid.UUID]model.TOTP
+	recoveryCodes map[uuid.UUID][]string
+	oauthClients  map[uuid.UUID]model.OAuthClient
+	consents      map[[2]uuid.UUID]model.OAuthConsent
+}
+
+func newTestAuthDAL(t *testing.T, jwtIssuer svc.JWTIssuerInterface) *testAuthDAL {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+	redisClient := redis.NewClient(&redis.Options{Addr: server.Addr()})
+
+	return &testAuthDAL{
+		AuthDALInterface: svc.NewAuthDAL(nil, redisClient, jwtIssuer, "token-hash-secret", false),
+		server:           server,
+		users:            make(map[uuid.UUID]model.User),
+		credentials:      make(map[uuid.UUID]model.WebAuthnCredential),
+		totps:            make(map[uuid.UUID]model.TOTP),
+		recoveryCodes:    make(map[uuid.UUID][]string),
+		oauthClients:     make(map[uuid.UUID]model.OAuthClient),
+		consents:         make(map[[2]uuid.UUID]model.OAuthConsent),
+	}
+}
+
+func (d *testAuthDAL) StoreUser(ctx context.Context, user model.User) (model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, storedUser := range d.users {
+		if storedUser.Email == user.Email {
+			return model.User{}, svc.ErrUserExists
+		}
+	}
+
+	user.ID = uuid.New()
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	d.users[user.ID] = user
+
+	return user, nil
+}
+
+func (d *testAuthDAL) FetchUser(ctx context.Context, userID uuid.UUID) (model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, ok := d.users[userID]
+	if !ok {
+		return model.User{}, svc.ErrUserDoesNotExists
+	}
+
+	return user, nil
+}
+
+func (d *testAuthDAL) FetchUserByEmail(ctx context.Context, email string) (model.User, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, user := range d.users {
+		if user.Email == email {
+			return user, nil
+		}
+	}
+
+	return model.User{}, svc.ErrUserDoesNotExists
+}
+
+func (d *testAuthDAL) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return d.updateUser(userID, func(user *model.User) {
+		user.Password = passwordHash
+	})
+}
+
+func (d *testAuthDAL) UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	return d.updateUser(userID, func(user *model.User) {
+		passwordChangedAt := time.Now()
+		user.Password = passwordHash
+		user.PasswordChangedAt = &passwordChangedAt
+	})
+}
+
+func (d *testAuthDAL) MarkEmailVerified(ctx context.Context, userID uuid.UUID) error {
+	return d.updateUser(userID, func(user *model.User) {
+		if user.EmailVerifiedAt == nil {
+			verifiedAt := time.Now()
+			user.EmailVerifiedAt = &verifiedAt
+		}
+	})
+}
+
+func (d *testAuthDAL) FetchTOTP(ctx context.Context, userID uuid.UUID) (model.TOTP, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	fetchedTOTP, ok := d.totps[userID]
+	if !ok {
+		return model.TOTP{}, svc.ErrMFANotEnabled
+	}
+
+	return fetchedTOTP, nil
+}
+
+func (d *testAuthDAL) UseRecoveryCode(ctx context.Context, userID uuid.UUID, recoveryCode string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	recoveryCodes := d.recoveryCodes[userID]
+	for i, code := range recoveryCodes {
+		if code == recoveryCode {
+			d.recoveryCodes[userID] = append(recoveryCodes[:i:i], recoveryCodes[i+1:]...)
+			return nil
+		}
+	}
+
+	return svc.ErrInvalidMFACode
+}
+
+func (d *testAuthDAL) StoreWebAuthnCredential(ctx context.Context, credential model.WebAuthnCredential) (model.WebAuthnCredential, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, storedCredential := range d.credentials {
+		if bytes.Equal(storedCredential.CredentialID, credential.CredentialID) {
+			return model.WebAuthnCredential{}, svc.ErrPasskeyExists
+		}
+	}
+
+	credential.ID = uuid.New()
+	credential.CreatedAt = time.Now()
+	d.credentials[credential.ID] = credential
+
+	return credential, nil
+}
+
+func (d *testAuthDAL) FetchWebAuthnCredential(ctx context.Context, credentialID []byte) (model.WebAuthnCredential, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for _, credential := range d.credentials {
+		if bytes.Equal(credential.CredentialID, credentialID) {
+			return credential, nil
+		}
+	}
+
+	return model.WebAuthnCredential{}, svc.ErrEntryNotFound
+}
+
+func (d *testAuthDAL) FetchUserWebAuthnCredentials(ctx context.Context, userID uuid.UUID) ([]model.WebAuthnCredential, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var credentials []model.WebAuthnCredential
+	for _, credential := range d.credentials {
+		if credential.UserID == userID {
+			credentials = append(credentials, credential)
+		}
+	}
+
+	return credentials, nil
+}
+
+func (d *testAuthDAL) UseWebAuthnCredential(ctx context.Context, id uuid.UUID, signCount int64) error {
+	return d.updateCredential(id, func(credential *model.WebAuthnCredential) {
+		lastUsedAt := time.Now()
+		credential.SignCount = signCount
+		credential.LastUsedAt = &lastUsedAt
+	})
+}
+
+func (d *testAuthDAL) MarkWebAuthnCredentialCloned(ctx context.Context, id uuid.UUID) error {
+	return d.updateCredential(id, func(credential *model.WebAuthnCredential) {
+		cloneDetectedAt := time.Now()
+		credential.CloneDetectedAt = &cloneDetectedAt
+	})
+}
+
+// StoreOAuthClient keeps public clients only; confidential ones would need
+// the secret hashing of the real DAL.
+func (d *testAuthDAL) StoreOAuthClient(ctx context.Context, client model.OAuthClient, confidential bool) (model.OAuthClient, string, error) {
+	if confidential {
+		return model.OAuthClient{}, "", errors.New("confidential clients are not supported in tests")
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	client.ID = uuid.New()
+	client.SecretHash = ""
+	client.CreatedAt = time.Now()
+	d.oauthClients[client.ID] = client
+
+	return client, "", nil
+}
+
+func (d *testAuthDAL) FetchOAuthClient(ctx context.Context, clientID uuid.UUID) (model.OAuthClient, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	client, ok := d.oauthClients[clientID]
+	if !ok {
+		return model.OAuthClient{}, svc.ErrEntryNotFound
+	}
+
+	return client, nil
+}
+
+func (d *testAuthDAL) AuthenticateOAuthClient(ctx context.Context, clientID uuid.UUID, clientSecret string) (model.OAuthClient, error) {
+	return model.OAuthClient{}, svc.ErrInvalidClient
+}
+
+func (d *testAuthDAL) StoreOAuthConsent(ctx context.Context, consent model.OAuthConsent) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := [2]uuid.UUID{consent.UserID, consent.ClientID}
+
+	storedConsent, ok := d.consents[key]
+	if !ok {
+		storedConsent = model.OAuthConsent{UserID: consent.UserID, ClientID: consent.ClientID, CreatedAt: time.Now()}
+	}
+
+	for _, scope := range consent.Scopes {
+		if !containsString(storedConsent.Scopes, scope) {
+			storedConsent.Scopes = append(storedConsent.Scopes, scope)
+		}
+	}
+
+	storedConsent.UpdatedAt = time.Now()
+	d.consents[key] = storedConsent
+
+	return nil
+}
+
+func (d *testAuthDAL) FetchOAuthConsent(ctx context.Context, userID uuid.UUID, clientID uuid.UUID) (model.OAuthConsent, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	consent, ok := d.consents[[2]uuid.UUID{userID, clientID}]
+	if !ok {
+		return model.OAuthConsent{}, svc.ErrEntryNotFound
+	}
+
+	return consent, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (d *testAuthDAL) updateUser(userID uuid.UUID, update func(user *model.User)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	user, ok := d.users[userID]
+	if !ok {
+		return svc.ErrUserDoesNotExists
+	}
+
+	update(&user)
+	d.users[userID] = user
+
+	return nil
+}
+
+func (d *testAuthDAL) updateCredential(id uuid.UUID, update func(credential *model.WebAuthnCredential)) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	credential, ok := d.credentials[id]
+	if !ok {
+		return svc.ErrEntryNotFound
+	}
+
+	update(&credential)
+	d.credentials[id] = credential
+
+	return nil
+}
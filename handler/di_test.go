@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"github.com/erfansahebi/lamia_auth/config"
+	"github.com/erfansahebi/lamia_auth/di"
+	"github.com/erfansahebi/lamia_auth/mfa"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/notify"
+	"github.com/erfansahebi/lamia_auth/password"
+	"github.com/erfansahebi/lamia_auth/pwned"
+	"github.com/erfansahebi/lamia_auth/ratelimit"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"github.com/erfansahebi/lamia_auth/webauthn"
+	"net/netip"
+	"sync"
+	"testing"
+)
+
+// testDI is a DI container wired with in-process dependencies. Leaving a
+// dependency nil makes the handlers run without it, like the real container
+// does when it is not configured.
+type testDI struct {
+	config         *config.Config
+	authDAL        svc.AuthDALInterface
+	jwtIssuer      svc.JWTIssuerInterface
+	passwordHasher password.HasherInterface
+	notifier       notify.NotifierInterface
+	rateLimiter    ratelimit.LimiterInterface
+	relyingParty   webauthn.RelyingPartyInterface
+	trustedProxies []netip.Prefix
+}
+
+func (d *testDI) Config() *config.Config                        { return d.config }
+func (d *testDI) AuthDAL() svc.AuthDALInterface                 { return d.authDAL }
+func (d *testDI) JWTIssuer() svc.JWTIssuerInterface             { return d.jwtIssuer }
+func (d *testDI) SigningKeyStore() svc.SigningKeyStoreInterface { return nil }
+func (d *testDI) PasswordHasher() password.HasherInterface      { return d.passwordHasher }
+func (d *testDI) PwnedChecker() pwned.CheckerInterface          { return nil }
+func (d *testDI) Notifier() notify.NotifierInterface            { return d.notifier }
+func (d *testDI) RateLimiter() ratelimit.LimiterInterface       { return d.rateLimiter }
+func (d *testDI) MFACipher() mfa.CipherInterface                { return nil }
+func (d *testDI) RelyingParty() webauthn.RelyingPartyInterface  { return d.relyingParty }
+func (d *testDI) Service() di.AuthServiceInterface              { return nil }
+func (d *testDI) TrustedProxies() []netip.Prefix                { return d.trustedProxies }
+func (d *testDI) PasswordPolicy() password.PolicyInterface {
+	return password.NewPolicy(password.PolicyConfig{MinLength: 8, MaxLength: 128})
+}
+
+func newTestHandler(t *testing.T, d *testDI) *Handler {
+	t.Helper()
+
+	if d.config == nil {
+		d.config = newTestConfig()
+	}
+
+	if d.authDAL == nil {
+		d.authDAL = newTestAuthDAL(t, d.jwtIssuer)
+	}
+
+	if d.passwordHasher == nil {
+		passwordHasher, err := password.NewHasher(password.Config{
+			Algorithm: password.AlgorithmArgon2id,
+			Argon2id:  password.Argon2idParams{Memory: 64, Iterations: 1, Parallelism: 1},
+			Bcrypt:    password.BcryptParams{Cost: 4},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		d.passwordHasher = passwordHasher
+	}
+
+	return &Handler{
+		AppCtx: context.Background(),
+		Di:     d,
+	}
+}
+
+func newTestConfig() *config.Config {
+	configuration := &config.Config{}
+	configuration.AuthorizationToken.Duration = 15
+	configuration.RefreshToken.Duration = 60
+	configuration.EmailVerification.Policy = config.EmailVerificationPolicyAllow
+	configuration.EmailOTP.Mode = config.EmailOTPModeOff
+	configuration.EmailOTP.Duration = 10
+	configuration.EmailOTP.MaxAttempts = 5
+	configuration.MFA.ChallengeDuration = 5
+	configuration.MFA.ChallengeMaxAttempts = 5
+	configuration.LoginThrottle.Window = 15
+	configuration.StepUp.Duration = 10
+
+	return configuration
+}
+
+type testSigningKeys struct {
+	key model.SigningKey
+}
+
+func (k *testSigningKeys) SigningKey(ctx context.Context) (model.SigningKey, error) {
+	return k.key, nil
+}
+
+func (k *testSigningKeys) VerificationKeys(ctx context.Context) ([]model.SigningKey, error) {
+	return []model.SigningKey{k.key}, nil
+}
+
+func newTestJWTIssuer(t *testing.T) svc.JWTIssuerInterface {
+	t.Helper()
+
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := svc.NewSigningKey(svc.JWTAlgorithmEdDSA, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return svc.NewJWTIssuer(&testSigningKeys{key: key}, "lamia_auth")
+}
+
+// testNotifier keeps the messages it is asked to send.
+type testNotifier struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (n *testNotifier) Notify(ctx context.Context, message notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.messages = append(n.messages, message)
+
+	return nil
+}
+
+// lastMessage returns the latest message of kind sent to email.
+func (n *testNotifier) lastMessage(kind string, email string) (notify.Message, bool) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	for i := len(n.messages) - 1; i >= 0; i-- {
+		if n.messages[i].Kind == kind && n.messages[i].To == email {
+			return n.messages[i], true
+		}
+	}
+
+	return notify.Message{}, false
+}
+
+// testRelyingParty accepts any response and treats it as the ID of the
+// credential it comes from. It stands in for an authenticator where a test
+// is not about the ceremony itself.
+type testRelyingParty struct{}
+
+func (rp *testRelyingParty) BeginRegistration(user webauthn.User, excludeCredentialIDs [][]byte) (webauthn.CreationOptions, webauthn.Session, error) {
+	return webauthn.CreationOptions{}, webauthn.Session{Challenge: []byte("challenge"), UserID: user.ID}, nil
+}
+
+func (rp *testRelyingParty) FinishRegistration(session webauthn.Session, response []byte) (webauthn.Credential, error) {
+	return webauthn.Credential{ID: response, UserHandle: session.UserID, PublicKey: []byte("public-key")}, nil
+}
+
+func (rp *testRelyingParty) BeginAssertion(userID []byte, allowedCredentialIDs [][]byte, userVerification string) (webauthn.RequestOptions, webauthn.Session, error) {
+	return webauthn.RequestOptions{}, webauthn.Session{Challenge: []byte("challenge"), UserID: userID, UserVerification: userVerification}, nil
+}
+
+func (rp *testRelyingParty) ParseAssertion(response []byte) (webauthn.Assertion, error) {
+	return webauthn.Assertion{CredentialID: response}, nil
+}
+
+func (rp *testRelyingParty) FinishAssertion(session webauthn.Session, assertion webauthn.Assertion, credential webauthn.Credential) (uint32, error) {
+	return credential.SignCount + 1, nil
+}
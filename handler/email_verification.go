@@ -0,0 +1,77 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/handler/validator"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/notify"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) VerifyEmail(ctx context.Context, request *authProto.VerifyEmailRequest) (*authProto.VerifyEmailResponse, error) {
+	pendData := validator.VerifyEmailStruct{VerifyEmailRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if err := h.Di.AuthDAL().MarkEmailVerified(ctx, pendData.User.ID); err != nil {
+		return nil, err
+	}
+
+	return &authProto.VerifyEmailResponse{}, nil
+}
+
+func (h *Handler) ResendVerification(ctx context.Context, request *authProto.ResendVerificationRequest) (*authProto.ResendVerificationResponse, error) {
+	pendData := validator.ResendVerificationStruct{ResendVerificationRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	// Like password resets, the response never tells whether the email
+	// belongs to an account or is already verified.
+	if pendData.NeedsSending {
+		go h.sendEmailVerification(h.AppCtx, pendData.User, localeFromContext(ctx))
+	}
+
+	return &authProto.ResendVerificationResponse{}, nil
+}
+
+// sendEmailVerification sends a verification code to user unless one was
+// sent within the resend cooldown.
+func (h *Handler) sendEmailVerification(ctx context.Context, user model.User, locale string) {
+	cooldown := time.Duration(h.Di.Config().EmailVerification.ResendCooldown) * time.Second
+
+	acquired, err := h.Di.AuthDAL().AcquireEmailVerificationCooldown(ctx, user.ID, cooldown)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in acquire email verification cooldown")
+		return
+	}
+
+	if !acquired {
+		log.Infof(ctx, "skipped email verification for %s within the resend cooldown", user.ID)
+		return
+	}
+
+	code, err := h.Di.AuthDAL().StoreEmailVerificationCode(ctx, user, h.Di.Config().EmailVerification.Duration)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in store email verification code")
+		return
+	}
+
+	if err = h.Di.Notifier().Notify(ctx, notify.Message{
+		Kind:   notify.KindEmailVerification,
+		To:     user.Email,
+		Locale: locale,
+		Data: map[string]string{
+			"first_name":        user.FirstName,
+			"verification_code": code,
+			"verification_url":  tokenURL(h.Di.Config().EmailVerification.URL, code),
+			"expire_duration":   strconv.FormatUint(uint64(h.Di.Config().EmailVerification.Duration), 10),
+		},
+	}); err != nil {
+		log.WithError(err).Errorf(ctx, "error in send email verification")
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/di"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"github.com/google/uuid"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"path"
+	"strconv"
+)
+
+// ErrorInterceptor turns the errors returned by handlers into gRPC statuses
+// through the error catalogue in svc, logging the ones it has to hide.
+func ErrorInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		resp, err := handler(ctx, req)
+		if err == nil {
+			return resp, nil
+		}
+
+		st, known := svc.ErrorStatus(err)
+		if !known {
+			log.WithError(err).Errorf(ctx, "internal error in %s", info.FullMethod)
+		}
+
+		return resp, st.Err()
+	}
+}
+
+type callerTokenKey struct{}
+
+// TokenInterceptor looks up the authorization token of the request once and
+// keeps what it finds in the context for the interceptors after it. Tokens
+// it cannot find are left for the handler to reject.
+func TokenInterceptor(di di.DIContainerInterface) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		r, ok := req.(interface{ GetAuthorizationToken() string })
+		if !ok || r.GetAuthorizationToken() == "" {
+			return handler(ctx, req)
+		}
+
+		tokenDetail, err := di.AuthDAL().FetchToken(ctx, r.GetAuthorizationToken())
+		if err != nil {
+			return handler(ctx, req)
+		}
+
+		return handler(context.WithValue(ctx, callerTokenKey{}, tokenDetail), req)
+	}
+}
+
+// callerToken returns the token TokenInterceptor found for the request.
+func callerToken(ctx context.Context) (tokenDetail model.Token, ok bool) {
+	tokenDetail, ok = ctx.Value(callerTokenKey{}).(model.Token)
+	return tokenDetail, ok
+}
+
+// RateLimitInterceptor applies the rate limit of each RPC per caller. Calls
+// over the limit are rejected with RateLimitedError and a retry-after header
+// in seconds.
+func RateLimitInterceptor(di di.DIContainerInterface) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		limiter := di.RateLimiter()
+		if limiter == nil {
+			return handler(ctx, req)
+		}
+
+		allowed, retryAfter := limiter.Allow(ctx, path.Base(info.FullMethod), callerIdentity(ctx, di))
+		if !allowed {
+			retryAfterHeader := metadata.Pairs("retry-after", strconv.FormatInt(svc.RetryAfterSeconds(retryAfter), 10))
+			if err := grpc.SetHeader(ctx, retryAfterHeader); err != nil {
+				log.WithError(err).Warnf(ctx, "error in set retry-after header")
+			}
+
+			return nil, &svc.RateLimitedError{RetryAfter: retryAfter}
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// oauthClientMethods are the RPCs that accept access tokens issued to OAuth
+// clients. Clients can check the tokens they hold and end their session;
+// the other RPCs manage the account itself, which no scope grants.
+var oauthClientMethods = map[string]bool{
+	"Authenticate": true,
+	"Logout":       true,
+}
+
+// ScopeInterceptor keeps access tokens of OAuth clients to the RPCs in
+// oauthClientMethods, rejecting them elsewhere with ErrInsufficientScope. It
+// runs after TokenInterceptor.
+func ScopeInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		tokenDetail, ok := callerToken(ctx)
+		if ok && tokenDetail.ClientID != "" && !oauthClientMethods[path.Base(info.FullMethod)] {
+			return nil, svc.ErrInsufficientScope
+		}
+
+		return handler(ctx, req)
+	}
+}
+
+// callerIdentity names who is calling, by the user or OAuth client the
+// token TokenInterceptor found belongs to, or else by the client IP. The IP
+// is the peer address unless the peer is a trusted proxy, so that a caller
+// cannot get a fresh limit on every call by forging X-Forwarded-For.
+//
+// Callers are not told apart by API key. The service issues none, so a key
+// could not be checked, and an unchecked one would hand out a fresh limit
+// per made up key just like a forged address. Services calling on their own
+// behalf get a client_credentials token and are limited as its client.
+func callerIdentity(ctx context.Context, di di.DIContainerInterface) string {
+	if tokenDetail, ok := callerToken(ctx); ok {
+		switch {
+		case tokenDetail.UserID != uuid.Nil:
+			return "user." + tokenDetail.UserID.String()
+		case tokenDetail.ClientID != "":
+			return "client." + tokenDetail.ClientID
+		}
+	}
+
+	return "ip." + clientInfoFromContext(ctx, di.TrustedProxies()).IP
+}
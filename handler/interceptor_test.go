@@ -0,0 +1,187 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/erfansahebi/lamia_auth/model"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestCallerIdentity(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal, trustedProxies: []netip.Prefix{netip.MustParsePrefix("10.0.0.0/8")}})
+
+	user, err := dal.StoreUser(ctx, model.User{Email: "user@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientTokenString, err := h.storeAccessToken(ctx, model.Token{ClientID: "client"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	callerCtx := metadata.NewIncomingContext(ctx, metadata.Pairs("x-real-ip", "203.0.113.7", "x-api-key", "made-up"))
+	callerCtx = peer.NewContext(callerCtx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.1"), Port: 1234}})
+
+	for _, test := range []struct {
+		name               string
+		authorizationToken string
+		want               string
+	}{
+		{name: "user token", authorizationToken: tokenString, want: "user." + user.ID.String()},
+		{name: "client token", authorizationToken: clientTokenString, want: "client.client"},
+		{name: "unknown token", authorizationToken: "made-up", want: "ip.203.0.113.7"},
+		{name: "no token", want: "ip.203.0.113.7"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			request := &authProto.AuthenticateRequest{AuthorizationToken: test.authorizationToken}
+			info := &grpc.UnaryServerInfo{FullMethod: "/auth.AuthService/Authenticate"}
+
+			identity := ""
+			if _, err := TokenInterceptor(h.Di)(callerCtx, request, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+				identity = callerIdentity(ctx, h.Di)
+				return nil, nil
+			}); err != nil {
+				t.Fatal(err)
+			}
+
+			if identity != test.want {
+				t.Fatalf("got %q, want %q", identity, test.want)
+			}
+		})
+	}
+}
+
+// OAuth clients act for the user within what they were granted, which does
+// not cover managing the account.
+func TestScopeInterceptor(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal})
+	user := newTestPasswordUser(t, h, dal)
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientTokenString, _, err := h.issueFamilyTokenPair(ctx, user, model.TokenFamily{UserID: user.ID, ClientID: "client", Scope: "profile"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := chainInterceptors(TokenInterceptor(h.Di), ScopeInterceptor())
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return "handled", nil
+	}
+
+	for _, test := range []struct {
+		method  string
+		request interface{}
+		wantErr error
+	}{
+		{method: "Authenticate", request: &authProto.AuthenticateRequest{AuthorizationToken: clientTokenString}},
+		{method: "Logout", request: &authProto.LogoutRequest{AuthorizationToken: clientTokenString}},
+		{method: "ChangePassword", request: &authProto.ChangePasswordRequest{AuthorizationToken: clientTokenString}, wantErr: svc.ErrInsufficientScope},
+		{method: "RevokeAllSessions", request: &authProto.RevokeAllSessionsRequest{AuthorizationToken: clientTokenString}, wantErr: svc.ErrInsufficientScope},
+		{method: "StartTOTPEnrollment", request: &authProto.StartTOTPEnrollmentRequest{AuthorizationToken: clientTokenString}, wantErr: svc.ErrInsufficientScope},
+		{method: "BeginPasskeyRegistration", request: &authProto.BeginPasskeyRegistrationRequest{AuthorizationToken: clientTokenString}, wantErr: svc.ErrInsufficientScope},
+		{method: "ChangePassword", request: &authProto.ChangePasswordRequest{AuthorizationToken: tokenString}},
+		{method: "ChangePassword", request: &authProto.ChangePasswordRequest{AuthorizationToken: "made-up"}},
+		{method: "Login", request: &authProto.LoginRequest{}},
+	} {
+		info := &grpc.UnaryServerInfo{FullMethod: "/auth.AuthService/" + test.method}
+
+		resp, err := interceptor(ctx, test.request, info, handler)
+		if !errors.Is(err, test.wantErr) {
+			t.Fatalf("%s with %T: got %v, want %v", test.method, test.request, err, test.wantErr)
+		}
+
+		if test.wantErr == nil && resp != "handled" {
+			t.Fatalf("%s with %T: the handler was not called", test.method, test.request)
+		}
+	}
+}
+
+// countingAuthDAL counts the tokens looked up through it.
+type countingAuthDAL struct {
+	svc.AuthDALInterface
+	tokenFetches int
+}
+
+func (d *countingAuthDAL) FetchToken(ctx context.Context, token string) (model.Token, error) {
+	d.tokenFetches++
+	return d.AuthDALInterface.FetchToken(ctx, token)
+}
+
+// identityLimiter lets every call through, keeping the identities it saw.
+type identityLimiter struct {
+	identities []string
+}
+
+func (l *identityLimiter) Allow(ctx context.Context, method string, identity string) (bool, time.Duration) {
+	l.identities = append(l.identities, identity)
+	return true, 0
+}
+
+// The rate limit and the scope check share one lookup of the token.
+func TestInterceptorsFetchTokenOnce(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	countingDAL := &countingAuthDAL{AuthDALInterface: dal}
+	limiter := &identityLimiter{}
+	h := newTestHandler(t, &testDI{authDAL: countingDAL, rateLimiter: limiter})
+	user := newTestPasswordUser(t, h, dal)
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	interceptor := chainInterceptors(TokenInterceptor(h.Di), RateLimitInterceptor(h.Di), ScopeInterceptor())
+	info := &grpc.UnaryServerInfo{FullMethod: "/auth.AuthService/ChangePassword"}
+	request := &authProto.ChangePasswordRequest{AuthorizationToken: tokenString}
+
+	if _, err = interceptor(ctx, request, info, func(ctx context.Context, req interface{}) (interface{}, error) {
+		return nil, nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if countingDAL.tokenFetches != 1 {
+		t.Fatalf("the token was fetched %d times, want once", countingDAL.tokenFetches)
+	}
+
+	if want := "user." + user.ID.String(); len(limiter.identities) != 1 || limiter.identities[0] != want {
+		t.Fatalf("got identities %v, want %q", limiter.identities, want)
+	}
+}
+
+// chainInterceptors runs interceptors in order, like grpc.ChainUnaryInterceptor.
+func chainInterceptors(interceptors ...grpc.UnaryServerInterceptor) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		for i := len(interceptors) - 1; i >= 0; i-- {
+			interceptor, next := interceptors[i], handler
+			handler = func(ctx context.Context, req interface{}) (interface{}, error) {
+				return interceptor(ctx, req, info, next)
+			}
+		}
+
+		return handler(ctx, req)
+	}
+}
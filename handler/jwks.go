@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/erfansahebi/lamia_auth/model"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"net/http"
+	"strconv"
+)
+
+const jwksPath = "/.well-known/jwks.json"
+
+func (h *Handler) GetJWKS(ctx context.Context, request *authProto.GetJWKSRequest) (*authProto.GetJWKSResponse, error) {
+	response := &authProto.GetJWKSResponse{}
+
+	jwtIssuer := h.Di.JWTIssuer()
+	if jwtIssuer == nil {
+		return response, nil
+	}
+
+	keys, err := jwtIssuer.JWKS(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, key := range keys {
+		response.Keys = append(response.Keys, &authProto.JSONWebKey{
+			Kty: key.KeyType,
+			Kid: key.KeyID,
+			Use: key.Use,
+			Alg: key.Algorithm,
+			Crv: key.Curve,
+			X:   key.X,
+			N:   key.N,
+			E:   key.E,
+		})
+	}
+
+	return response, nil
+}
+
+type jwksResponse struct {
+	Keys []model.JSONWebKey `json:"keys"`
+}
+
+// jwksDocument serves the verification keys as the JWK Set of RFC 7517, for
+// consumers that verify tokens locally without a gRPC client.
+func (h *Handler) jwksDocument(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	keys, err := h.Di.JWTIssuer().JWKS(r.Context())
+	if err != nil {
+		log.WithError(err).Errorf(r.Context(), "error in fetch jwks")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	if keys == nil {
+		keys = make([]model.JSONWebKey, 0)
+	}
+
+	// Verifiers may cache the keys as long as the key store does.
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age="+strconv.FormatUint(uint64(h.Di.Config().JWT.KeyCacheDuration), 10))
+
+	_ = json.NewEncoder(w).Encode(jwksResponse{Keys: keys})
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/erfansahebi/lamia_auth/config"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestJWKSDocument(t *testing.T) {
+	h := newTestHandler(t, &testDI{jwtIssuer: newTestJWTIssuer(t)})
+
+	recorder := httptest.NewRecorder()
+	h.WebHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, jwksPath, nil))
+
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusOK)
+	}
+
+	var document jwksResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &document); err != nil {
+		t.Fatal(err)
+	}
+
+	grpcKeys, err := h.GetJWKS(context.Background(), &authProto.GetJWKSRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(document.Keys) != 1 || len(grpcKeys.Keys) != 1 {
+		t.Fatalf("got %d keys over HTTP and %d over gRPC, want 1", len(document.Keys), len(grpcKeys.Keys))
+	}
+
+	key := document.Keys[0]
+	if key.KeyID != grpcKeys.Keys[0].Kid || key.X != grpcKeys.Keys[0].X || key.KeyType != "OKP" || key.Algorithm != "EdDSA" {
+		t.Fatalf("HTTP key %+v does not match gRPC key %+v", key, grpcKeys.Keys[0])
+	}
+}
+
+func TestJWKSDocumentWithoutJWT(t *testing.T) {
+	h := newTestHandler(t, &testDI{config: &config.Config{}})
+
+	recorder := httptest.NewRecorder()
+	h.WebHandler().ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, jwksPath, nil))
+
+	if recorder.Code != http.StatusNotFound {
+		t.Fatalf("status = %d, want %d", recorder.Code, http.StatusNotFound)
+	}
+}
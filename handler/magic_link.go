@@ -0,0 +1,110 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/handler/validator"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/notify"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) RequestMagicLink(ctx context.Context, request *authProto.RequestMagicLinkRequest) (*authProto.RequestMagicLinkResponse, error) {
+	pendData := validator.RequestMagicLinkStruct{RequestMagicLinkRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	magicLink := model.MagicLink{
+		UserID:    pendData.User.ID,
+		Email:     pendData.User.Email,
+		FirstName: pendData.User.FirstName,
+		LastName:  pendData.User.LastName,
+	}
+
+	if !pendData.UserFound {
+		magicLink = model.MagicLink{
+			Email:     request.Email,
+			FirstName: request.FirstName,
+			LastName:  request.LastName,
+		}
+	}
+
+	// Like password resets, the link is sent in the background and the
+	// response never tells whether the email belongs to an account.
+	if pendData.UserFound || h.Di.Config().MagicLink.AllowSignUp {
+		go h.sendMagicLink(h.AppCtx, magicLink, request.Nonce, localeFromContext(ctx))
+	}
+
+	return &authProto.RequestMagicLinkResponse{}, nil
+}
+
+func (h *Handler) ConsumeMagicLink(ctx context.Context, request *authProto.ConsumeMagicLinkRequest) (*authProto.AuthenticationResponse, error) {
+	pendData := validator.ConsumeMagicLinkStruct{ConsumeMagicLinkRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if err := h.Di.AuthDAL().DeleteMagicLink(ctx, request.Token); err != nil {
+		return nil, err
+	}
+
+	user := pendData.User
+	if pendData.NewUser {
+		storedUser, err := h.Di.AuthDAL().StoreUser(ctx, model.User{
+			FirstName: pendData.MagicLink.FirstName,
+			LastName:  pendData.MagicLink.LastName,
+			Email:     pendData.MagicLink.Email,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		verifiedAt := time.Now()
+		storedUser.EmailVerifiedAt = &verifiedAt
+		user = storedUser
+	}
+
+	if err := h.Di.AuthDAL().MarkEmailVerified(ctx, user.ID); err != nil {
+		return nil, err
+	}
+
+	if len(pendData.MFAMethods) > 0 {
+		return h.startMFAChallenge(ctx, user, clientInfoFromContext(ctx, h.Di.TrustedProxies()), pendData.MFAMethods)
+	}
+
+	tokenString, refreshToken, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.AuthenticationResponse{
+		User:               userResponse(user),
+		AuthorizationToken: tokenString,
+		RefreshToken:       refreshToken,
+	}, nil
+}
+
+func (h *Handler) sendMagicLink(ctx context.Context, magicLink model.MagicLink, nonce string, locale string) {
+	magicLinkToken, err := h.Di.AuthDAL().StoreMagicLink(ctx, magicLink, nonce, h.Di.Config().MagicLink.Duration)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in store magic link")
+		return
+	}
+
+	if err = h.Di.Notifier().Notify(ctx, notify.Message{
+		Kind:   notify.KindMagicLink,
+		To:     magicLink.Email,
+		Locale: locale,
+		Data: map[string]string{
+			"first_name":       magicLink.FirstName,
+			"magic_link_token": magicLinkToken,
+			"magic_link_url":   tokenURL(h.Di.Config().MagicLink.URL, magicLinkToken),
+			"expire_duration":  strconv.FormatUint(uint64(h.Di.Config().MagicLink.Duration), 10),
+		},
+	}); err != nil {
+		log.WithError(err).Errorf(ctx, "error in send magic link")
+	}
+}
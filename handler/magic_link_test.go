@@ -0,0 +1,56 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/config"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/notify"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"testing"
+)
+
+// An email passcode goes to the inbox the magic link came from, so it is
+// never the second factor of a magic link login.
+func TestMagicLinkLoginLeavesOutEmailOTP(t *testing.T) {
+	for _, test := range []struct {
+		name        string
+		newUser     func(t *testing.T, h *Handler, dal *testAuthDAL) model.User
+		wantMethods []string
+	}{
+		{name: "email passcode only", newUser: newTestPasswordUser},
+		{name: "authenticator app", newUser: newTestTOTPUser, wantMethods: []string{model.MFAMethodTOTP, model.MFAMethodRecoveryCode}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			ctx := context.Background()
+			dal := newTestAuthDAL(t, nil)
+			notifier := &testNotifier{}
+			h := newTestHandler(t, &testDI{authDAL: dal, notifier: notifier})
+			h.Di.Config().EmailOTP.Mode = config.EmailOTPModeAlways
+			user := test.newUser(t, h, dal)
+
+			magicLinkToken, err := dal.StoreMagicLink(ctx, model.MagicLink{UserID: user.ID, Email: user.Email}, "nonce", 15)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := h.ConsumeMagicLink(ctx, &authProto.ConsumeMagicLinkRequest{Token: magicLinkToken, Nonce: "nonce"})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if response.MfaRequired != (len(test.wantMethods) > 0) || len(response.MfaMethods) != len(test.wantMethods) {
+				t.Fatalf("got methods %v, want %v", response.MfaMethods, test.wantMethods)
+			}
+
+			for i, method := range test.wantMethods {
+				if response.MfaMethods[i] != method {
+					t.Fatalf("got methods %v, want %v", response.MfaMethods, test.wantMethods)
+				}
+			}
+
+			if _, sent := notifier.lastMessage(notify.KindEmailOTP, user.Email); sent {
+				t.Fatal("an email passcode was sent")
+			}
+		})
+	}
+}
@@ -0,0 +1,132 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/model"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+const maxClientInfoLength = 256
+
+// clientInfoFromContext reads the caller details the gateway forwards as
+// gRPC metadata. The forwarded address is only used when the peer is one of
+// trustedProxies.
+func clientInfoFromContext(ctx context.Context, trustedProxies []netip.Prefix) model.ClientInfo {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	remoteAddr := ""
+	if p, ok := peer.FromContext(ctx); ok {
+		remoteAddr = p.Addr.String()
+	}
+
+	return clientInfoFromMetadata(md, remoteAddr, trustedProxies)
+}
+
+// clientInfoFromRequest reads the caller details of an HTTP request, trusting
+// the same forwarded headers as clientInfoFromContext.
+func clientInfoFromRequest(r *http.Request, trustedProxies []netip.Prefix) model.ClientInfo {
+	md := metadata.MD{}
+	for key, values := range r.Header {
+		md.Append(key, values...)
+	}
+
+	return clientInfoFromMetadata(md, r.RemoteAddr, trustedProxies)
+}
+
+// clientInfoFromMetadata reads the caller details forwarded in md by the
+// peer at remoteAddr.
+func clientInfoFromMetadata(md metadata.MD, remoteAddr string, trustedProxies []netip.Prefix) model.ClientInfo {
+	return model.ClientInfo{
+		IP:        clientIP(md, remoteAddr, trustedProxies),
+		UserAgent: firstMetadataValue(md, "x-forwarded-user-agent", "user-agent"),
+		Device:    firstMetadataValue(md, "x-device-label"),
+	}
+}
+
+// clientIP returns the address of the client the peer at remoteAddr calls
+// for. Peers other than trustedProxies are the client themselves. Trusted
+// proxies append the address they were called from to X-Forwarded-For, so
+// it is followed from the right past the proxies to the first address none
+// of them is at; anything further left could have been made up by the
+// client. X-Real-IP is only used when there is no X-Forwarded-For.
+func clientIP(md metadata.MD, remoteAddr string, trustedProxies []netip.Prefix) string {
+	host, _, err := net.SplitHostPort(remoteAddr)
+	if err != nil {
+		return ""
+	}
+
+	peerAddr, err := netip.ParseAddr(host)
+	if err != nil || !isTrustedProxy(peerAddr, trustedProxies) {
+		return host
+	}
+
+	var hops []string
+	for _, value := range md.Get("x-forwarded-for") {
+		hops = append(hops, strings.Split(value, ",")...)
+	}
+
+	clientAddr := peerAddr
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+
+		clientAddr = hop.Unmap()
+		if !isTrustedProxy(clientAddr, trustedProxies) {
+			break
+		}
+	}
+
+	if len(hops) == 0 {
+		if realIP, err := netip.ParseAddr(firstMetadataValue(md, "x-real-ip")); err == nil {
+			clientAddr = realIP.Unmap()
+		}
+	}
+
+	return clientAddr.String()
+}
+
+func isTrustedProxy(addr netip.Addr, trustedProxies []netip.Prefix) bool {
+	addr = addr.Unmap()
+	for _, prefix := range trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// localeFromContext returns the preferred language of the caller, e.g. fa-IR
+// for an Accept-Language of "fa-IR,fa;q=0.9,en;q=0.8".
+func localeFromContext(ctx context.Context) string {
+	md, _ := metadata.FromIncomingContext(ctx)
+
+	locale := firstMetadataValue(md, "x-locale", "accept-language")
+	if i := strings.IndexAny(locale, ",;"); i >= 0 {
+		locale = locale[:i]
+	}
+
+	return strings.TrimSpace(locale)
+}
+
+func firstMetadataValue(md metadata.MD, keys ...string) string {
+	for _, key := range keys {
+		if values := md.Get(key); len(values) > 0 && values[0] != "" {
+			value := values[0]
+			if len(value) > maxClientInfoLength {
+				value = value[:maxClientInfoLength]
+			}
+
+			return value
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,74 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/model"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"net"
+	"net/http/httptest"
+	"net/netip"
+	"testing"
+)
+
+// Callers have to be described alike whether they come through the gateway
+// or to the web server.
+func TestClientInfoFromContextAndRequestAgree(t *testing.T) {
+	for _, test := range []struct {
+		name           string
+		trustedProxies []netip.Prefix
+		headers        map[string]string
+		want           model.ClientInfo
+	}{
+		{
+			name:           "forwarded",
+			trustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24"), netip.MustParsePrefix("10.0.0.0/8")},
+			headers:        map[string]string{"X-Forwarded-For": "203.0.113.7, 10.0.0.1", "User-Agent": "Browser/1.0", "X-Device-Label": "Laptop"},
+			want:           model.ClientInfo{IP: "203.0.113.7", UserAgent: "Browser/1.0", Device: "Laptop"},
+		},
+		{
+			name:           "forwarded with a made up client",
+			trustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+			headers:        map[string]string{"X-Forwarded-For": "198.51.100.9, 203.0.113.7"},
+			want:           model.ClientInfo{IP: "203.0.113.7"},
+		},
+		{
+			name:           "real ip",
+			trustedProxies: []netip.Prefix{netip.MustParsePrefix("192.0.2.0/24")},
+			headers:        map[string]string{"X-Real-IP": "203.0.113.7"},
+			want:           model.ClientInfo{IP: "203.0.113.7"},
+		},
+		{
+			name:    "forwarded by an untrusted peer",
+			headers: map[string]string{"X-Forwarded-For": "203.0.113.7", "X-Real-IP": "203.0.113.7"},
+			want:    model.ClientInfo{IP: "192.0.2.1"},
+		},
+		{
+			name:    "remote address",
+			headers: map[string]string{"User-Agent": "Browser/1.0"},
+			want:    model.ClientInfo{IP: "192.0.2.1", UserAgent: "Browser/1.0"},
+		},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+
+			md := metadata.MD{}
+			for key, value := range test.headers {
+				r.Header.Set(key, value)
+				md.Set(key, value)
+			}
+
+			ctx := metadata.NewIncomingContext(context.Background(), md)
+			ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 1234}})
+
+			if clientInfo := clientInfoFromRequest(r, test.trustedProxies); clientInfo != test.want {
+				t.Errorf("from request: got %+v, want %+v", clientInfo, test.want)
+			}
+
+			if clientInfo := clientInfoFromContext(ctx, test.trustedProxies); clientInfo != test.want {
+				t.Errorf("from context: got %+v, want %+v", clientInfo, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,240 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/handler/validator"
+	"github.com/erfansahebi/lamia_auth/mfa"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/notify"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"google.golang.org/protobuf/types/known/timestamppb"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) VerifyMFA(ctx context.Context, request *authProto.VerifyMFARequest) (*authProto.AuthenticationResponse, error) {
+	pendData := validator.VerifyMFAStruct{
+		VerifyMFARequest: request,
+		ClientInfo:       clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if err := h.Di.AuthDAL().DeleteMFAChallenge(ctx, request.MfaChallengeToken); err != nil {
+		return nil, err
+	}
+
+	tokenString, refreshToken, err := h.issueTokenPair(ctx, pendData.User)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.AuthenticationResponse{
+		User:               userResponse(pendData.User),
+		AuthorizationToken: tokenString,
+		RefreshToken:       refreshToken,
+	}, nil
+}
+
+func (h *Handler) StartTOTPEnrollment(ctx context.Context, request *authProto.StartTOTPEnrollmentRequest) (*authProto.StartTOTPEnrollmentResponse, error) {
+	pendData := validator.StartTOTPEnrollmentStruct{StartTOTPEnrollmentRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	secret, err := mfa.GenerateTOTPSecret()
+	if err != nil {
+		return nil, err
+	}
+
+	encryptedSecret, err := h.Di.MFACipher().Encrypt([]byte(secret), pendData.User.ID[:])
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.Di.AuthDAL().StoreTOTPEnrollment(ctx, pendData.User.ID, encryptedSecret); err != nil {
+		return nil, err
+	}
+
+	return &authProto.StartTOTPEnrollmentResponse{
+		Secret:     secret,
+		OtpauthUri: mfa.TOTPURI(h.Di.Config().MFA.Issuer, pendData.User.Email, secret),
+	}, nil
+}
+
+func (h *Handler) ConfirmTOTPEnrollment(ctx context.Context, request *authProto.ConfirmTOTPEnrollmentRequest) (*authProto.ConfirmTOTPEnrollmentResponse, error) {
+	pendData := validator.ConfirmTOTPEnrollmentStruct{ConfirmTOTPEnrollmentRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := mfa.GenerateRecoveryCodes(h.Di.Config().MFA.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.Di.AuthDAL().ConfirmTOTP(ctx, pendData.User.ID, pendData.Step, recoveryCodes); err != nil {
+		return nil, err
+	}
+
+	return &authProto.ConfirmTOTPEnrollmentResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (h *Handler) DisableTOTP(ctx context.Context, request *authProto.DisableTOTPRequest) (*authProto.DisableTOTPResponse, error) {
+	pendData := validator.DisableTOTPStruct{
+		DisableTOTPRequest: request,
+		ClientInfo:         clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if err := h.Di.AuthDAL().DeleteTOTP(ctx, pendData.User.ID); err != nil {
+		return nil, err
+	}
+
+	return &authProto.DisableTOTPResponse{}, nil
+}
+
+func (h *Handler) RegenerateRecoveryCodes(ctx context.Context, request *authProto.RegenerateRecoveryCodesRequest) (*authProto.RegenerateRecoveryCodesResponse, error) {
+	pendData := validator.RegenerateRecoveryCodesStruct{
+		RegenerateRecoveryCodesRequest: request,
+		ClientInfo:                     clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	recoveryCodes, err := mfa.GenerateRecoveryCodes(h.Di.Config().MFA.RecoveryCodeCount)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.Di.AuthDAL().ReplaceRecoveryCodes(ctx, pendData.User.ID, recoveryCodes); err != nil {
+		return nil, err
+	}
+
+	return &authProto.RegenerateRecoveryCodesResponse{
+		RecoveryCodes: recoveryCodes,
+	}, nil
+}
+
+func (h *Handler) SendMFAEmailCode(ctx context.Context, request *authProto.SendMFAEmailCodeRequest) (*authProto.SendMFAEmailCodeResponse, error) {
+	pendData := validator.SendMFAEmailCodeStruct{SendMFAEmailCodeRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if err := h.sendEmailOTP(ctx, pendData.User, svc.MFAChallengeSubject(request.MfaChallengeToken)); err != nil {
+		return nil, err
+	}
+
+	return &authProto.SendMFAEmailCodeResponse{}, nil
+}
+
+func (h *Handler) StartStepUp(ctx context.Context, request *authProto.StartStepUpRequest) (*authProto.StartStepUpResponse, error) {
+	pendData := validator.StartStepUpStruct{StartStepUpRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	response := &authProto.StartStepUpResponse{
+		Methods: pendData.Methods,
+	}
+
+	switch request.Method {
+	case model.MFAMethodEmailOTP:
+		if err := h.sendEmailOTP(ctx, pendData.User, svc.StepUpSubject(pendData.TokenDetail.FamilyID)); err != nil {
+			return nil, err
+		}
+	case model.MFAMethodWebAuthn:
+		passkeyOptions, err := h.beginPasskeyVerification(ctx, pendData.User, svc.StepUpSubject(pendData.TokenDetail.FamilyID))
+		if err != nil {
+			return nil, err
+		}
+
+		response.PasskeyOptions = passkeyOptions
+	}
+
+	return response, nil
+}
+
+func (h *Handler) StepUp(ctx context.Context, request *authProto.StepUpRequest) (*authProto.StepUpResponse, error) {
+	pendData := validator.StepUpStruct{
+		StepUpRequest: request,
+		ClientInfo:    clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	tokenFamily, err := h.Di.AuthDAL().ElevateTokenFamily(ctx, pendData.TokenDetail.FamilyID, time.Duration(h.Di.Config().StepUp.Duration)*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.StepUpResponse{
+		ElevatedUntil: timestamppb.New(*tokenFamily.ElevatedUntil),
+	}, nil
+}
+
+// startMFAChallenge holds back the tokens of a user who passed the password
+// check until they answer a second factor through VerifyMFA.
+func (h *Handler) startMFAChallenge(ctx context.Context, user model.User, clientInfo model.ClientInfo, methods []string) (*authProto.AuthenticationResponse, error) {
+	challengeToken, err := h.Di.AuthDAL().StoreMFAChallenge(ctx, model.MFAChallenge{
+		UserID:     user.ID,
+		ClientInfo: clientInfo,
+		Methods:    methods,
+	}, h.Di.Config().MFA.ChallengeDuration)
+	if err != nil {
+		return nil, err
+	}
+
+	// With nothing else to choose from the code is sent right away.
+	if len(methods) == 1 && methods[0] == model.MFAMethodEmailOTP {
+		if err = h.sendEmailOTP(ctx, user, svc.MFAChallengeSubject(challengeToken)); err != nil {
+			return nil, err
+		}
+	}
+
+	return &authProto.AuthenticationResponse{
+		MfaRequired:       true,
+		MfaChallengeToken: challengeToken,
+		MfaMethods:        methods,
+	}, nil
+}
+
+// sendEmailOTP emails user a new passcode for subject unless one was sent
+// within the resend cooldown.
+func (h *Handler) sendEmailOTP(ctx context.Context, user model.User, subject string) error {
+	cooldown := time.Duration(h.Di.Config().EmailOTP.ResendCooldown) * time.Second
+
+	retryAfter, err := h.Di.AuthDAL().AcquireEmailOTPCooldown(ctx, subject, cooldown)
+	if err != nil {
+		return err
+	}
+
+	if retryAfter > 0 {
+		return &svc.RateLimitedError{RetryAfter: retryAfter}
+	}
+
+	code, err := h.Di.AuthDAL().StoreEmailOTP(ctx, subject, h.Di.Config().EmailOTP.Duration)
+	if err != nil {
+		return err
+	}
+
+	return h.Di.Notifier().Notify(ctx, notify.Message{
+		Kind:   notify.KindEmailOTP,
+		To:     user.Email,
+		Locale: localeFromContext(ctx),
+		Data: map[string]string{
+			"first_name":      user.FirstName,
+			"code":            code,
+			"expire_duration": strconv.FormatUint(uint64(h.Di.Config().EmailOTP.Duration), 10),
+		},
+	})
+}
@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/erfansahebi/lamia_auth/config"
+	"github.com/erfansahebi/lamia_auth/model"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"testing"
+	"time"
+)
+
+// newTestTOTPUser stores a user with a verified email, whose password is
+// testPassword and who has set up an authenticator app.
+func newTestTOTPUser(t *testing.T, h *Handler, dal *testAuthDAL) model.User {
+	t.Helper()
+
+	user := newTestPasswordUser(t, h, dal)
+	if err := dal.MarkEmailVerified(context.Background(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	confirmedAt := time.Now()
+
+	dal.mu.Lock()
+	dal.totps[user.ID] = model.TOTP{UserID: user.ID, EncryptedSecret: []byte("secret"), ConfirmedAt: &confirmedAt}
+	dal.mu.Unlock()
+
+	return user
+}
+
+// Whoever reads the inbox of a user with an authenticator app must not be
+// able to answer for it with an email passcode.
+func TestMFAChallengeOfTOTPUserRejectsEmailOTP(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal, notifier: &testNotifier{}})
+	h.Di.Config().EmailOTP.Mode = config.EmailOTPModeAlways
+	user := newTestTOTPUser(t, h, dal)
+
+	loginResponse, err := h.Login(ctx, &authProto.LoginRequest{Email: user.Email, Password: testPassword})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !loginResponse.MfaRequired || containsString(loginResponse.MfaMethods, model.MFAMethodEmailOTP) {
+		t.Fatalf("got methods %v, want a challenge without %s", loginResponse.MfaMethods, model.MFAMethodEmailOTP)
+	}
+
+	if _, err = h.SendMFAEmailCode(ctx, &authProto.SendMFAEmailCodeRequest{MfaChallengeToken: loginResponse.MfaChallengeToken}); !errors.Is(err, svc.ErrMFAMethodInvalid) {
+		t.Fatalf("send email code: got %v, want %v", err, svc.ErrMFAMethodInvalid)
+	}
+
+	code, err := dal.StoreEmailOTP(ctx, svc.MFAChallengeSubject(loginResponse.MfaChallengeToken), h.Di.Config().EmailOTP.Duration)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.VerifyMFA(ctx, &authProto.VerifyMFARequest{
+		MfaChallengeToken: loginResponse.MfaChallengeToken,
+		Method:            model.MFAMethodEmailOTP,
+		Code:              code,
+	}); !errors.Is(err, svc.ErrMFAMethodInvalid) {
+		t.Fatalf("verify email code: got %v, want %v", err, svc.ErrMFAMethodInvalid)
+	}
+}
+
+// Wrong codes to the two-factor settings count against the login lock, or a
+// stolen access token would allow guessing the recovery codes.
+func TestMFASettingsCountWrongCodesAsFailedLogins(t *testing.T) {
+	ctx := context.Background()
+
+	configuration := newTestConfig()
+	configuration.LoginThrottle.AccountLockoutThreshold = 3
+	configuration.LoginThrottle.LockoutDuration = 15
+
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{config: configuration, authDAL: dal})
+	user := newTestTOTPUser(t, h, dal)
+
+	dal.mu.Lock()
+	dal.recoveryCodes[user.ID] = []string{"recovery-code"}
+	dal.mu.Unlock()
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if _, err = h.DisableTOTP(ctx, &authProto.DisableTOTPRequest{AuthorizationToken: tokenString, Code: "wrong-code"}); !errors.Is(err, svc.ErrInvalidMFACode) {
+			t.Fatalf("attempt %d: got %v, want %v", attempt, err, svc.ErrInvalidMFACode)
+		}
+	}
+
+	if _, err = h.Login(ctx, &authProto.LoginRequest{Email: user.Email, Password: testPassword}); !errors.Is(err, svc.ErrAccountLocked) {
+		t.Fatalf("login: got %v, want %v", err, svc.ErrAccountLocked)
+	}
+
+	if _, err = h.RegenerateRecoveryCodes(ctx, &authProto.RegenerateRecoveryCodesRequest{AuthorizationToken: tokenString, Code: "recovery-code"}); !errors.Is(err, svc.ErrAccountLocked) {
+		t.Fatalf("regenerate recovery codes: got %v, want %v", err, svc.ErrAccountLocked)
+	}
+}
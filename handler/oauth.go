@@ -0,0 +1,490 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/erfansahebi/lamia_auth/handler/validator"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/oauth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	oauthAuthorizePath = "/oauth/authorize"
+	oauthTokenPath     = "/oauth/token"
+	oauthConsentPath   = "/oauth/consent"
+	oauthMetadataPath  = "/.well-known/oauth-authorization-server"
+
+	maxOAuthRequestSize = 64 << 10
+)
+
+// handleOAuth serves the OAuth 2.0 authorization server on mux: the
+// authorization code grant with PKCE, client credentials and refresh tokens.
+// Clients that are not first party only get a code once the user allowed
+// them on the consent page, which reads and answers its challenge through
+// oauthConsentPath.
+func (h *Handler) handleOAuth(mux *http.ServeMux) {
+	mux.HandleFunc(oauthAuthorizePath, h.oauthAuthorize)
+	mux.HandleFunc(oauthTokenPath, h.oauthToken)
+	mux.HandleFunc(oauthConsentPath, h.oauthConsent)
+	mux.HandleFunc(oauthMetadataPath, h.oauthMetadata)
+}
+
+func (h *Handler) oauthAuthorize(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthRequestSize)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, oauth.NewError(oauth.ErrorInvalidRequest, ""), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	pendData := validator.OAuthAuthorizeStruct{
+		ResponseType:        r.Form.Get("response_type"),
+		ClientID:            r.Form.Get("client_id"),
+		RedirectURI:         r.Form.Get("redirect_uri"),
+		Scope:               r.Form.Get("scope"),
+		State:               r.Form.Get("state"),
+		CodeChallenge:       r.Form.Get("code_challenge"),
+		CodeChallengeMethod: r.Form.Get("code_challenge_method"),
+		AuthorizationToken:  h.oauthSessionToken(r),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		oauthErr, ok := err.(*oauth.Error)
+		switch {
+		case !ok:
+			log.WithError(err).Errorf(ctx, "error in validate oauth authorization request")
+			writeOAuthError(w, oauth.NewError(oauth.ErrorServerError, ""), http.StatusInternalServerError)
+		case oauthErr.Code == oauth.ErrorLoginRequired && h.Di.Config().OAuth.LoginURL != "":
+			http.Redirect(w, r, oauthLoginURL(h.Di.Config().OAuth.LoginURL, r), http.StatusFound)
+		case pendData.RedirectResolved:
+			redirectOAuth(w, r, pendData.RedirectURI, url.Values{
+				"error":             {oauthErr.Code},
+				"error_description": {oauthErr.Description},
+				"state":             {pendData.State},
+			})
+		default:
+			writeOAuthError(w, oauthErr, http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	authorizationCode := model.OAuthAuthorizationCode{
+		ClientID:      pendData.Client.ID,
+		UserID:        pendData.User.ID,
+		ClientInfo:    clientInfoFromRequest(r, h.Di.TrustedProxies()),
+		RedirectURI:   r.Form.Get("redirect_uri"),
+		Scope:         oauth.JoinScope(pendData.Scopes),
+		CodeChallenge: pendData.CodeChallenge,
+	}
+
+	if !pendData.ConsentRequired {
+		redirectOAuth(w, r, pendData.RedirectURI, h.grantOAuthAuthorizationCode(ctx, authorizationCode, pendData.State))
+		return
+	}
+
+	consentURL := h.Di.Config().OAuth.ConsentURL
+	if consentURL == "" {
+		redirectOAuth(w, r, pendData.RedirectURI, url.Values{
+			"error": {oauth.ErrorConsentRequired},
+			"state": {pendData.State},
+		})
+		return
+	}
+
+	challengeToken, err := h.Di.AuthDAL().StoreOAuthConsentChallenge(ctx, model.OAuthConsentChallenge{
+		AuthorizationCode: authorizationCode,
+		RedirectURI:       pendData.RedirectURI,
+		State:             pendData.State,
+	}, time.Duration(h.Di.Config().OAuth.ConsentDuration)*time.Second)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in store oauth consent challenge")
+		redirectOAuth(w, r, pendData.RedirectURI, url.Values{
+			"error": {oauth.ErrorServerError},
+			"state": {pendData.State},
+		})
+		return
+	}
+
+	http.Redirect(w, r, addQueryParameter(consentURL, "consent_challenge", challengeToken), http.StatusFound)
+}
+
+// grantOAuthAuthorizationCode issues authorizationCode and returns the
+// values to send the user back to the client with.
+func (h *Handler) grantOAuthAuthorizationCode(ctx context.Context, authorizationCode model.OAuthAuthorizationCode, state string) url.Values {
+	code, err := h.Di.AuthDAL().StoreOAuthAuthorizationCode(ctx, authorizationCode, time.Duration(h.Di.Config().OAuth.AuthorizationCodeDuration)*time.Second)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in store oauth authorization code")
+		return url.Values{
+			"error": {oauth.ErrorServerError},
+			"state": {state},
+		}
+	}
+
+	return url.Values{
+		"code":  {code},
+		"state": {state},
+	}
+}
+
+type oauthConsentResponse struct {
+	ClientID   string `json:"client_id,omitempty"`
+	ClientName string `json:"client_name,omitempty"`
+	Scope      string `json:"scope,omitempty"`
+	RedirectTo string `json:"redirect_to,omitempty"`
+}
+
+// oauthConsent lets the consent page show a consent challenge to the user it
+// was made for and answer it on their behalf. The answer tells the page
+// where to send the user back to the client.
+func (h *Handler) oauthConsent(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthRequestSize)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, oauth.NewError(oauth.ErrorInvalidRequest, ""), http.StatusBadRequest)
+		return
+	}
+
+	ctx := r.Context()
+
+	pendData := validator.OAuthConsentStruct{
+		ConsentChallenge:   r.Form.Get("consent_challenge"),
+		Consent:            r.PostForm.Get("consent"),
+		Answered:           r.Method == http.MethodPost,
+		AuthorizationToken: h.oauthSessionToken(r),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		oauthErr, ok := err.(*oauth.Error)
+		switch {
+		case !ok:
+			log.WithError(err).Errorf(ctx, "error in validate oauth consent")
+			writeOAuthError(w, oauth.NewError(oauth.ErrorServerError, ""), http.StatusInternalServerError)
+		case oauthErr.Code == oauth.ErrorLoginRequired:
+			writeOAuthError(w, oauthErr, http.StatusUnauthorized)
+		case oauthErr.Code == oauth.ErrorAccessDenied:
+			writeOAuthError(w, oauthErr, http.StatusForbidden)
+		default:
+			writeOAuthError(w, oauthErr, http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	authorizationCode := pendData.Challenge.AuthorizationCode
+
+	if !pendData.Answered {
+		writeOAuthJSON(w, oauthConsentResponse{
+			ClientID:   pendData.Client.ID.String(),
+			ClientName: pendData.Client.Name,
+			Scope:      authorizationCode.Scope,
+		}, http.StatusOK)
+		return
+	}
+
+	values := url.Values{
+		"error": {oauth.ErrorAccessDenied},
+		"state": {pendData.Challenge.State},
+	}
+
+	if pendData.Consent == oauth.ConsentAllow {
+		if err := h.Di.AuthDAL().StoreOAuthConsent(ctx, model.OAuthConsent{
+			UserID:   authorizationCode.UserID,
+			ClientID: authorizationCode.ClientID,
+			Scopes:   oauth.ParseScope(authorizationCode.Scope),
+		}); err != nil {
+			log.WithError(err).Errorf(ctx, "error in store oauth consent")
+			writeOAuthError(w, oauth.NewError(oauth.ErrorServerError, ""), http.StatusInternalServerError)
+			return
+		}
+
+		values = h.grantOAuthAuthorizationCode(ctx, authorizationCode, pendData.Challenge.State)
+	}
+
+	redirectTo, err := oauthRedirectURL(pendData.Challenge.RedirectURI, values)
+	if err != nil {
+		writeOAuthError(w, oauth.NewError(oauth.ErrorServerError, ""), http.StatusInternalServerError)
+		return
+	}
+
+	writeOAuthJSON(w, oauthConsentResponse{RedirectTo: redirectTo}, http.StatusOK)
+}
+
+type oauthTokenResponse struct {
+	AccessToken  string `json:"access_token"`
+	TokenType    string `json:"token_type"`
+	ExpiresIn    int64  `json:"expires_in"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	Scope        string `json:"scope,omitempty"`
+}
+
+func (h *Handler) oauthToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", "POST")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	ctx := r.Context()
+	clientInfo := clientInfoFromRequest(r, h.Di.TrustedProxies())
+
+	if rateLimiter := h.Di.RateLimiter(); rateLimiter != nil {
+		if allowed, retryAfter := rateLimiter.Allow(ctx, "OAuthToken", "ip."+clientInfo.IP); !allowed {
+			w.Header().Set("Retry-After", strconv.FormatInt(svc.RetryAfterSeconds(retryAfter), 10))
+			writeOAuthError(w, oauth.NewError(oauth.ErrorTemporarilyUnavailable, svc.ErrRateLimited.Error()), http.StatusTooManyRequests)
+			return
+		}
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxOAuthRequestSize)
+	if err := r.ParseForm(); err != nil {
+		writeOAuthError(w, oauth.NewError(oauth.ErrorInvalidRequest, ""), http.StatusBadRequest)
+		return
+	}
+
+	clientID, clientSecret, basicAuth, oauthErr := oauthClientCredentials(r)
+	if oauthErr != nil {
+		writeOAuthError(w, oauthErr, http.StatusBadRequest)
+		return
+	}
+
+	pendData := validator.OAuthTokenStruct{
+		GrantType:    r.PostForm.Get("grant_type"),
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		Code:         r.PostForm.Get("code"),
+		RedirectURI:  r.PostForm.Get("redirect_uri"),
+		CodeVerifier: r.PostForm.Get("code_verifier"),
+		RefreshToken: r.PostForm.Get("refresh_token"),
+		Scope:        r.PostForm.Get("scope"),
+		ClientInfo:   clientInfo,
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		oauthErr, ok := err.(*oauth.Error)
+		switch {
+		case !ok:
+			log.WithError(err).Errorf(ctx, "error in validate oauth token request")
+			writeOAuthError(w, oauth.NewError(oauth.ErrorServerError, ""), http.StatusInternalServerError)
+		case oauthErr.Code == oauth.ErrorInvalidClient:
+			if basicAuth {
+				w.Header().Set("WWW-Authenticate", `Basic realm="oauth"`)
+			}
+			writeOAuthError(w, oauthErr, http.StatusUnauthorized)
+		default:
+			writeOAuthError(w, oauthErr, http.StatusBadRequest)
+		}
+
+		return
+	}
+
+	response, err := h.issueOAuthTokens(ctx, pendData)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in issue oauth tokens")
+		writeOAuthError(w, oauth.NewError(oauth.ErrorServerError, ""), http.StatusInternalServerError)
+		return
+	}
+
+	writeOAuthJSON(w, response, http.StatusOK)
+}
+
+// issueOAuthTokens hands out the tokens of a validated token request.
+// Authorization codes start a session of the user with the client, refresh
+// tokens continue one and client credentials get an access token alone.
+func (h *Handler) issueOAuthTokens(ctx context.Context, pendData validator.OAuthTokenStruct) (response oauthTokenResponse, err error) {
+	response = oauthTokenResponse{
+		TokenType: oauth.TokenTypeBearer,
+		ExpiresIn: int64(h.accessTokenExpireDuration()) * 60,
+		Scope:     oauth.JoinScope(pendData.Scopes),
+	}
+
+	switch pendData.GrantType {
+	case model.OAuthGrantAuthorizationCode:
+		response.AccessToken, response.RefreshToken, err = h.issueFamilyTokenPair(ctx, pendData.User, model.TokenFamily{
+			UserID:     pendData.User.ID,
+			ClientInfo: pendData.ClientInfo,
+			ClientID:   pendData.Client.ID.String(),
+			Scope:      response.Scope,
+		})
+	case model.OAuthGrantRefreshToken:
+		emailVerified, restricted := h.emailVerificationState(pendData.User)
+
+		response.RefreshToken = pendData.NewRefreshToken
+		response.AccessToken, err = h.storeAccessToken(ctx, model.Token{
+			UserID:        pendData.User.ID,
+			FamilyID:      pendData.TokenFamily.ID,
+			ClientInfo:    pendData.ClientInfo,
+			EmailVerified: emailVerified,
+			Restricted:    restricted,
+			ClientID:      pendData.Client.ID.String(),
+			Scope:         response.Scope,
+		})
+	case model.OAuthGrantClientCredentials:
+		response.AccessToken, err = h.storeAccessToken(ctx, model.Token{
+			ClientInfo: pendData.ClientInfo,
+			ClientID:   pendData.Client.ID.String(),
+			Scope:      response.Scope,
+		})
+	}
+	if err != nil {
+		return oauthTokenResponse{}, err
+	}
+
+	return response, nil
+}
+
+type oauthMetadataResponse struct {
+	Issuer                            string   `json:"issuer"`
+	AuthorizationEndpoint             string   `json:"authorization_endpoint"`
+	TokenEndpoint                     string   `json:"token_endpoint"`
+	ResponseTypesSupported            []string `json:"response_types_supported"`
+	GrantTypesSupported               []string `json:"grant_types_supported"`
+	TokenEndpointAuthMethodsSupported []string `json:"token_endpoint_auth_methods_supported"`
+	CodeChallengeMethodsSupported     []string `json:"code_challenge_methods_supported"`
+	JWKSURI                           string   `json:"jwks_uri,omitempty"`
+}
+
+// oauthMetadata serves the authorization server metadata of RFC 8414.
+func (h *Handler) oauthMetadata(w http.ResponseWriter, r *http.Request) {
+	issuer := strings.TrimSuffix(h.Di.Config().OAuth.Issuer, "/")
+	if issuer == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+
+		issuer = scheme + "://" + r.Host
+	}
+
+	metadata := oauthMetadataResponse{
+		Issuer:                            issuer,
+		AuthorizationEndpoint:             issuer + oauthAuthorizePath,
+		TokenEndpoint:                     issuer + oauthTokenPath,
+		ResponseTypesSupported:            []string{oauth.ResponseTypeCode},
+		GrantTypesSupported:               []string{model.OAuthGrantAuthorizationCode, model.OAuthGrantClientCredentials, model.OAuthGrantRefreshToken},
+		TokenEndpointAuthMethodsSupported: []string{"client_secret_basic", "client_secret_post", "none"},
+		CodeChallengeMethodsSupported:     []string{oauth.CodeChallengeMethodS256},
+	}
+
+	if h.Di.JWTIssuer() != nil {
+		metadata.JWKSURI = issuer + jwksPath
+	}
+
+	writeOAuthJSON(w, metadata, http.StatusOK)
+}
+
+// oauthSessionToken returns the access token of the signed in user, sent as
+// a bearer token or in the session cookie.
+func (h *Handler) oauthSessionToken(r *http.Request) string {
+	if authorization := r.Header.Get("Authorization"); len(authorization) > 7 && strings.EqualFold(authorization[:7], "Bearer ") {
+		return strings.TrimSpace(authorization[7:])
+	}
+
+	if cookie, err := r.Cookie(h.Di.Config().OAuth.SessionCookie); err == nil {
+		return cookie.Value
+	}
+
+	return ""
+}
+
+// oauthClientCredentials reads the client of a token request from HTTP Basic
+// authentication or the request body; using both is an error.
+func oauthClientCredentials(r *http.Request) (clientID string, clientSecret string, basicAuth bool, oauthErr *oauth.Error) {
+	clientID, clientSecret, basicAuth = r.BasicAuth()
+	if !basicAuth {
+		return r.PostForm.Get("client_id"), r.PostForm.Get("client_secret"), false, nil
+	}
+
+	if r.PostForm.Get("client_secret") != "" {
+		return "", "", true, oauth.NewError(oauth.ErrorInvalidRequest, "the client authenticated more than once")
+	}
+
+	// Basic credentials are form encoded before they are joined.
+	var unescapeErr error
+	if clientID, unescapeErr = url.QueryUnescape(clientID); unescapeErr != nil {
+		return "", "", true, oauth.NewError(oauth.ErrorInvalidRequest, "malformed client credentials")
+	}
+
+	if clientSecret, unescapeErr = url.QueryUnescape(clientSecret); unescapeErr != nil {
+		return "", "", true, oauth.NewError(oauth.ErrorInvalidRequest, "malformed client credentials")
+	}
+
+	return clientID, clientSecret, true, nil
+}
+
+// oauthLoginURL sends the user to loginURL, which brings them back to the
+// authorization request r once they signed in.
+func oauthLoginURL(loginURL string, r *http.Request) string {
+	return addQueryParameter(loginURL, "return_to", oauthAuthorizePath+"?"+r.Form.Encode())
+}
+
+// addQueryParameter appends key and value to the query of rawURL.
+func addQueryParameter(rawURL string, key string, value string) string {
+	separator := "?"
+	if strings.Contains(rawURL, "?") {
+		separator = "&"
+	}
+
+	return rawURL + separator + url.QueryEscape(key) + "=" + url.QueryEscape(value)
+}
+
+// redirectOAuth answers an authorization request by redirecting back to the
+// client with values added to redirectURI.
+func redirectOAuth(w http.ResponseWriter, r *http.Request, redirectURI string, values url.Values) {
+	target, err := oauthRedirectURL(redirectURI, values)
+	if err != nil {
+		writeOAuthError(w, oauth.NewError(oauth.ErrorServerError, ""), http.StatusInternalServerError)
+		return
+	}
+
+	http.Redirect(w, r, target, http.StatusFound)
+}
+
+// oauthRedirectURL adds values to redirectURI. Empty values are left out.
+func oauthRedirectURL(redirectURI string, values url.Values) (string, error) {
+	target, err := url.Parse(redirectURI)
+	if err != nil {
+		return "", err
+	}
+
+	query := target.Query()
+	for key, value := range values {
+		if len(value) > 0 && value[0] != "" {
+			query.Set(key, value[0])
+		}
+	}
+
+	target.RawQuery = query.Encode()
+
+	return target.String(), nil
+}
+
+func writeOAuthError(w http.ResponseWriter, oauthErr *oauth.Error, statusCode int) {
+	writeOAuthJSON(w, oauthErr, statusCode)
+}
+
+func writeOAuthJSON(w http.ResponseWriter, body interface{}, statusCode int) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Pragma", "no-cache")
+	w.WriteHeader(statusCode)
+
+	// Failing to write means the client is gone, there is no one to tell.
+	_ = json.NewEncoder(w).Encode(body)
+}
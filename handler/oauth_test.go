@@ -0,0 +1,351 @@
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/oauth"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+const (
+	testRedirectURI  = "https://client.example.com/callback"
+	testConsentURL   = "https://auth.example.com/consent"
+	testCodeVerifier = "test-code-verifier-of-the-client-0123456789"
+)
+
+// testOAuthServer is an authorization server with one public client and a
+// signed in user.
+type testOAuthServer struct {
+	h            *Handler
+	dal          *testAuthDAL
+	handler      http.Handler
+	client       model.OAuthClient
+	sessionToken string
+}
+
+func newTestOAuthServer(t *testing.T, firstParty bool) *testOAuthServer {
+	t.Helper()
+
+	ctx := context.Background()
+	configuration := newTestConfig()
+	configuration.OAuth.Enabled = true
+	configuration.OAuth.ConsentURL = testConsentURL
+	configuration.OAuth.SessionCookie = "lamia_at"
+	configuration.OAuth.AuthorizationCodeDuration = 60
+	configuration.OAuth.ConsentDuration = 600
+
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{config: configuration, authDAL: dal})
+	user := newTestPasswordUser(t, h, dal)
+
+	sessionToken, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client, _, err := dal.StoreOAuthClient(ctx, model.OAuthClient{
+		Name:         "client",
+		RedirectURIs: []string{testRedirectURI},
+		GrantTypes:   []string{model.OAuthGrantAuthorizationCode, model.OAuthGrantRefreshToken},
+		Scopes:       []string{"profile", "email"},
+		FirstParty:   firstParty,
+	}, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &testOAuthServer{h: h, dal: dal, handler: h.WebHandler(), client: client, sessionToken: sessionToken}
+}
+
+// authorize runs an authorization request of the client with the
+// challenge of testCodeVerifier and returns the code it was given.
+func (s *testOAuthServer) authorize(t *testing.T, redirectURI string) string {
+	t.Helper()
+
+	location := s.authorizeRedirect(t, redirectURI)
+
+	code := location.Query().Get("code")
+	if code == "" {
+		t.Fatalf("authorize: redirected to %s without a code", location)
+	}
+
+	return code
+}
+
+// authorizeRedirect runs an authorization request of the client and returns
+// where the user was sent.
+func (s *testOAuthServer) authorizeRedirect(t *testing.T, redirectURI string) *url.URL {
+	t.Helper()
+
+	challenge := sha256.Sum256([]byte(testCodeVerifier))
+	query := url.Values{
+		"response_type":         {oauth.ResponseTypeCode},
+		"client_id":             {s.client.ID.String()},
+		"scope":                 {"profile"},
+		"state":                 {"state"},
+		"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+		"code_challenge_method": {oauth.CodeChallengeMethodS256},
+	}
+	if redirectURI != "" {
+		query.Set("redirect_uri", redirectURI)
+	}
+
+	request := httptest.NewRequest(http.MethodGet, oauthAuthorizePath+"?"+query.Encode(), nil)
+	request.Header.Set("Authorization", "Bearer "+s.sessionToken)
+
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+
+	if recorder.Code != http.StatusFound {
+		t.Fatalf("authorize: status = %d, want %d: %s", recorder.Code, http.StatusFound, recorder.Body)
+	}
+
+	location, err := url.Parse(recorder.Header().Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return location
+}
+
+// consent makes a request of the consent page with sessionToken. Answers
+// are sent with POST, looks at the challenge with GET.
+func (s *testOAuthServer) consent(t *testing.T, method string, sessionToken string, form url.Values) (int, oauthConsentResponse) {
+	t.Helper()
+
+	var request *http.Request
+	if method == http.MethodPost {
+		request = httptest.NewRequest(method, oauthConsentPath, strings.NewReader(form.Encode()))
+		request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	} else {
+		request = httptest.NewRequest(method, oauthConsentPath+"?"+form.Encode(), nil)
+	}
+	request.AddCookie(&http.Cookie{Name: "lamia_at", Value: sessionToken})
+
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+
+	var response oauthConsentResponse
+	if err := json.Unmarshal(recorder.Body.Bytes(), &response); err != nil {
+		t.Fatal(err)
+	}
+
+	return recorder.Code, response
+}
+
+// token makes a token request of the client with form and returns the
+// status code along with the decoded body.
+func (s *testOAuthServer) token(t *testing.T, form url.Values) (int, map[string]interface{}) {
+	t.Helper()
+
+	form.Set("client_id", s.client.ID.String())
+
+	request := httptest.NewRequest(http.MethodPost, oauthTokenPath, strings.NewReader(form.Encode()))
+	request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	recorder := httptest.NewRecorder()
+	s.handler.ServeHTTP(recorder, request)
+
+	var body map[string]interface{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), &body); err != nil {
+		t.Fatal(err)
+	}
+
+	return recorder.Code, body
+}
+
+func (s *testOAuthServer) exchange(t *testing.T, code string, redirectURI string, codeVerifier string) (int, map[string]interface{}) {
+	t.Helper()
+
+	form := url.Values{
+		"grant_type":    {model.OAuthGrantAuthorizationCode},
+		"code":          {code},
+		"code_verifier": {codeVerifier},
+	}
+	if redirectURI != "" {
+		form.Set("redirect_uri", redirectURI)
+	}
+
+	return s.token(t, form)
+}
+
+func TestOAuthTokenRejectsWrongCodeVerifier(t *testing.T) {
+	server := newTestOAuthServer(t, true)
+	code := server.authorize(t, testRedirectURI)
+
+	status, body := server.exchange(t, code, testRedirectURI, "other-code-verifier-of-an-attacker-0123456789")
+	if status != http.StatusBadRequest || body["error"] != oauth.ErrorInvalidGrant {
+		t.Fatalf("got %d %v, want %d %s", status, body, http.StatusBadRequest, oauth.ErrorInvalidGrant)
+	}
+
+	// The failed attempt used the code up.
+	status, body = server.exchange(t, code, testRedirectURI, testCodeVerifier)
+	if status != http.StatusBadRequest || body["error"] != oauth.ErrorInvalidGrant {
+		t.Fatalf("retry: got %d %v, want %d %s", status, body, http.StatusBadRequest, oauth.ErrorInvalidGrant)
+	}
+}
+
+func TestOAuthAuthorizationCodeIsSingleUse(t *testing.T) {
+	server := newTestOAuthServer(t, true)
+	code := server.authorize(t, testRedirectURI)
+
+	status, body := server.exchange(t, code, testRedirectURI, testCodeVerifier)
+	if status != http.StatusOK || body["access_token"] == nil || body["refresh_token"] == nil {
+		t.Fatalf("first exchange: got %d %v, want tokens", status, body)
+	}
+
+	status, body = server.exchange(t, code, testRedirectURI, testCodeVerifier)
+	if status != http.StatusBadRequest || body["error"] != oauth.ErrorInvalidGrant {
+		t.Fatalf("second exchange: got %d %v, want %d %s", status, body, http.StatusBadRequest, oauth.ErrorInvalidGrant)
+	}
+}
+
+// A redirect_uri sent with the authorization request has to be repeated in
+// the token request, one left out there may be left out again.
+func TestOAuthTokenMatchesRedirectURI(t *testing.T) {
+	for _, test := range []struct {
+		name              string
+		authorizeRedirect string
+		tokenRedirect     string
+		wantStatus        int
+	}{
+		{name: "repeated", authorizeRedirect: testRedirectURI, tokenRedirect: testRedirectURI, wantStatus: http.StatusOK},
+		{name: "left out", authorizeRedirect: testRedirectURI, tokenRedirect: "", wantStatus: http.StatusBadRequest},
+		{name: "other", authorizeRedirect: testRedirectURI, tokenRedirect: "https://attacker.example.com/callback", wantStatus: http.StatusBadRequest},
+		{name: "left out of both", authorizeRedirect: "", tokenRedirect: "", wantStatus: http.StatusOK},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			server := newTestOAuthServer(t, true)
+			code := server.authorize(t, test.authorizeRedirect)
+
+			status, body := server.exchange(t, code, test.tokenRedirect, testCodeVerifier)
+			if status != test.wantStatus {
+				t.Fatalf("got %d %v, want %d", status, body, test.wantStatus)
+			}
+		})
+	}
+}
+
+// A refresh request turned down for its scope leaves the refresh token
+// usable.
+func TestOAuthRefreshKeepsTokenOnRejection(t *testing.T) {
+	server := newTestOAuthServer(t, true)
+	code := server.authorize(t, testRedirectURI)
+
+	status, body := server.exchange(t, code, testRedirectURI, testCodeVerifier)
+	if status != http.StatusOK {
+		t.Fatalf("exchange: got %d %v", status, body)
+	}
+
+	refreshToken, _ := body["refresh_token"].(string)
+
+	status, body = server.token(t, url.Values{
+		"grant_type":    {model.OAuthGrantRefreshToken},
+		"refresh_token": {refreshToken},
+		"scope":         {"email"},
+	})
+	if status != http.StatusBadRequest || body["error"] != oauth.ErrorInvalidScope {
+		t.Fatalf("wider scope: got %d %v, want %d %s", status, body, http.StatusBadRequest, oauth.ErrorInvalidScope)
+	}
+
+	status, body = server.token(t, url.Values{
+		"grant_type":    {model.OAuthGrantRefreshToken},
+		"refresh_token": {refreshToken},
+	})
+	if status != http.StatusOK || body["refresh_token"] == nil || body["refresh_token"] == refreshToken {
+		t.Fatalf("refresh: got %d %v, want a new refresh token", status, body)
+	}
+}
+
+// Clients that are not first party get a code once the user allowed them,
+// and are not asked about again for what was allowed.
+func TestOAuthAuthorizeAsksForConsent(t *testing.T) {
+	server := newTestOAuthServer(t, false)
+
+	location := server.authorizeRedirect(t, testRedirectURI)
+	challenge := location.Query().Get("consent_challenge")
+	if !strings.HasPrefix(location.String(), testConsentURL+"?") || challenge == "" {
+		t.Fatalf("redirected to %s, want the consent page", location)
+	}
+
+	status, response := server.consent(t, http.MethodGet, server.sessionToken, url.Values{"consent_challenge": {challenge}})
+	if status != http.StatusOK || response.ClientName != "client" || response.Scope != "profile" {
+		t.Fatalf("look at challenge: got %d %+v", status, response)
+	}
+
+	status, response = server.consent(t, http.MethodPost, server.sessionToken, url.Values{"consent_challenge": {challenge}, "consent": {oauth.ConsentDeny}})
+	if status != http.StatusOK || !strings.Contains(response.RedirectTo, "error="+oauth.ErrorAccessDenied) {
+		t.Fatalf("deny: got %d %+v, want a redirect with %s", status, response, oauth.ErrorAccessDenied)
+	}
+
+	challenge = server.authorizeRedirect(t, testRedirectURI).Query().Get("consent_challenge")
+	if challenge == "" {
+		t.Fatal("a denied client was not asked for consent again")
+	}
+
+	status, response = server.consent(t, http.MethodPost, server.sessionToken, url.Values{"consent_challenge": {challenge}, "consent": {oauth.ConsentAllow}})
+	if status != http.StatusOK {
+		t.Fatalf("allow: got %d %+v", status, response)
+	}
+
+	redirectTo, err := url.Parse(response.RedirectTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status, body := server.exchange(t, redirectTo.Query().Get("code"), testRedirectURI, testCodeVerifier); status != http.StatusOK {
+		t.Fatalf("exchange: got %d %v", status, body)
+	}
+
+	status, _ = server.consent(t, http.MethodPost, server.sessionToken, url.Values{"consent_challenge": {challenge}, "consent": {oauth.ConsentAllow}})
+	if status != http.StatusBadRequest {
+		t.Fatalf("second answer: got %d, want %d", status, http.StatusBadRequest)
+	}
+
+	server.authorize(t, testRedirectURI)
+}
+
+func TestOAuthConsentOfAnotherUser(t *testing.T) {
+	ctx := context.Background()
+	server := newTestOAuthServer(t, false)
+
+	otherUser, err := server.dal.StoreUser(ctx, model.User{Email: "other@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherSessionToken, _, err := server.h.issueTokenPair(ctx, otherUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	challenge := server.authorizeRedirect(t, testRedirectURI).Query().Get("consent_challenge")
+
+	status, _ := server.consent(t, http.MethodPost, otherSessionToken, url.Values{"consent_challenge": {challenge}, "consent": {oauth.ConsentAllow}})
+	if status != http.StatusForbidden {
+		t.Fatalf("got %d, want %d", status, http.StatusForbidden)
+	}
+
+	// The challenge is still there for the user it was made for.
+	status, _ = server.consent(t, http.MethodPost, server.sessionToken, url.Values{"consent_challenge": {challenge}, "consent": {oauth.ConsentAllow}})
+	if status != http.StatusOK {
+		t.Fatalf("answer of the user: got %d, want %d", status, http.StatusOK)
+	}
+}
+
+func TestOAuthAuthorizeWithoutConsentPage(t *testing.T) {
+	server := newTestOAuthServer(t, false)
+	server.h.Di.Config().OAuth.ConsentURL = ""
+
+	location := server.authorizeRedirect(t, testRedirectURI)
+	if !strings.HasPrefix(location.String(), testRedirectURI) || location.Query().Get("error") != oauth.ErrorConsentRequired {
+		t.Fatalf("redirected to %s, want %s at the client", location, oauth.ErrorConsentRequired)
+	}
+}
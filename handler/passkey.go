@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/erfansahebi/lamia_auth/handler/validator"
+	"github.com/erfansahebi/lamia_auth/model"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"github.com/erfansahebi/lamia_auth/webauthn"
+	"strings"
+	"time"
+)
+
+func (h *Handler) BeginPasskeyRegistration(ctx context.Context, request *authProto.BeginPasskeyRegistrationRequest) (*authProto.BeginPasskeyRegistrationResponse, error) {
+	pendData := validator.BeginPasskeyRegistrationStruct{BeginPasskeyRegistrationRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	excludeCredentialIDs := make([][]byte, 0, len(pendData.Credentials))
+	for _, credential := range pendData.Credentials {
+		excludeCredentialIDs = append(excludeCredentialIDs, credential.CredentialID)
+	}
+
+	options, session, err := h.Di.RelyingParty().BeginRegistration(webauthn.User{
+		ID:          pendData.User.ID[:],
+		Name:        pendData.User.Email,
+		DisplayName: strings.TrimSpace(pendData.User.FirstName + " " + pendData.User.LastName),
+	}, excludeCredentialIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.Di.AuthDAL().StoreWebAuthnSession(ctx, svc.PasskeyRegistrationSubject(pendData.TokenDetail.FamilyID), session, h.passkeyTimeout()); err != nil {
+		return nil, err
+	}
+
+	encodedOptions, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.BeginPasskeyRegistrationResponse{
+		Options: string(encodedOptions),
+	}, nil
+}
+
+func (h *Handler) FinishPasskeyRegistration(ctx context.Context, request *authProto.FinishPasskeyRegistrationRequest) (*authProto.FinishPasskeyRegistrationResponse, error) {
+	pendData := validator.FinishPasskeyRegistrationStruct{FinishPasskeyRegistrationRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	credential, err := h.Di.AuthDAL().StoreWebAuthnCredential(ctx, model.WebAuthnCredential{
+		UserID:       pendData.User.ID,
+		CredentialID: pendData.Credential.ID,
+		PublicKey:    pendData.Credential.PublicKey,
+		SignCount:    int64(pendData.Credential.SignCount),
+		AAGUID:       pendData.Credential.AAGUID,
+		Transports:   pendData.Credential.Transports,
+		Name:         request.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.FinishPasskeyRegistrationResponse{
+		PasskeyId: credential.ID.String(),
+	}, nil
+}
+
+func (h *Handler) BeginPasskeyAssertion(ctx context.Context, request *authProto.BeginPasskeyAssertionRequest) (*authProto.BeginPasskeyAssertionResponse, error) {
+	pendData := validator.BeginPasskeyAssertionStruct{BeginPasskeyAssertionRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if request.MfaChallengeToken != "" {
+		options, err := h.beginPasskeyVerification(ctx, pendData.User, svc.MFAChallengeSubject(request.MfaChallengeToken))
+		if err != nil {
+			return nil, err
+		}
+
+		return &authProto.BeginPasskeyAssertionResponse{
+			Options: options,
+		}, nil
+	}
+
+	// The authenticator picks the account, so nothing about it is revealed
+	// before the user is verified.
+	options, session, err := h.Di.RelyingParty().BeginAssertion(nil, nil, webauthn.UserVerificationRequired)
+	if err != nil {
+		return nil, err
+	}
+
+	sessionToken, err := h.Di.AuthDAL().StorePasskeyLoginSession(ctx, session, h.passkeyTimeout())
+	if err != nil {
+		return nil, err
+	}
+
+	encodedOptions, err := json.Marshal(options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.BeginPasskeyAssertionResponse{
+		Options:      string(encodedOptions),
+		SessionToken: sessionToken,
+	}, nil
+}
+
+// FinishPasskeyAssertion logs in with a passkey. A user verified passkey
+// counts as two factors, so no further challenge follows.
+func (h *Handler) FinishPasskeyAssertion(ctx context.Context, request *authProto.FinishPasskeyAssertionRequest) (*authProto.AuthenticationResponse, error) {
+	if request.MfaChallengeToken != "" {
+		return h.VerifyMFA(ctx, &authProto.VerifyMFARequest{
+			MfaChallengeToken: request.MfaChallengeToken,
+			Method:            model.MFAMethodWebAuthn,
+			Code:              request.Credential,
+		})
+	}
+
+	pendData := validator.FinishPasskeyAssertionStruct{FinishPasskeyAssertionRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	tokenString, refreshToken, err := h.issueTokenPair(ctx, pendData.User)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.AuthenticationResponse{
+		User:               userResponse(pendData.User),
+		AuthorizationToken: tokenString,
+		RefreshToken:       refreshToken,
+	}, nil
+}
+
+// beginPasskeyVerification starts an assertion of one of the passkeys of
+// user as a second factor, answered through subject.
+func (h *Handler) beginPasskeyVerification(ctx context.Context, user model.User, subject string) (string, error) {
+	credentials, err := h.Di.AuthDAL().FetchUserWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	allowedCredentialIDs := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		if credential.Active() {
+			allowedCredentialIDs = append(allowedCredentialIDs, credential.CredentialID)
+		}
+	}
+
+	if len(allowedCredentialIDs) == 0 {
+		return "", svc.ErrMFAMethodInvalid
+	}
+
+	options, session, err := h.Di.RelyingParty().BeginAssertion(user.ID[:], allowedCredentialIDs, webauthn.UserVerificationPreferred)
+	if err != nil {
+		return "", err
+	}
+
+	if err = h.Di.AuthDAL().StoreWebAuthnSession(ctx, subject, session, h.passkeyTimeout()); err != nil {
+		return "", err
+	}
+
+	encodedOptions, err := json.Marshal(options)
+	if err != nil {
+		return "", err
+	}
+
+	return string(encodedOptions), nil
+}
+
+func (h *Handler) passkeyTimeout() time.Duration {
+	return time.Duration(h.Di.Config().WebAuthn.Timeout) * time.Second
+}
@@ -0,0 +1,98 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"github.com/erfansahebi/lamia_auth/model"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"testing"
+)
+
+// newTestPasswordUser stores a user whose password is testPassword.
+func newTestPasswordUser(t *testing.T, h *Handler, dal *testAuthDAL) model.User {
+	t.Helper()
+
+	passwordHash, err := h.Di.PasswordHasher().Hash(testPassword)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	user, err := dal.StoreUser(context.Background(), model.User{Email: "user@example.com", Password: passwordHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user
+}
+
+// Adding a way to sign in has to take more than an access token.
+func TestPasskeyRegistrationRequiresStepUp(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal, relyingParty: &testRelyingParty{}})
+	user := newTestPasswordUser(t, h, dal)
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.BeginPasskeyRegistration(ctx, &authProto.BeginPasskeyRegistrationRequest{AuthorizationToken: tokenString}); !errors.Is(err, svc.ErrStepUpRequired) {
+		t.Fatalf("begin before step up: got %v, want %v", err, svc.ErrStepUpRequired)
+	}
+
+	if _, err = h.StepUp(ctx, &authProto.StepUpRequest{AuthorizationToken: tokenString, Method: model.StepUpMethodPassword, Code: "wrong password"}); !errors.Is(err, svc.ErrWrongPassword) {
+		t.Fatalf("step up with wrong password: got %v, want %v", err, svc.ErrWrongPassword)
+	}
+
+	if _, err = h.StepUp(ctx, &authProto.StepUpRequest{AuthorizationToken: tokenString, Code: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.BeginPasskeyRegistration(ctx, &authProto.BeginPasskeyRegistrationRequest{AuthorizationToken: tokenString}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.FinishPasskeyRegistration(ctx, &authProto.FinishPasskeyRegistrationRequest{AuthorizationToken: tokenString, Credential: "credential"}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTOTPEnrollmentRequiresStepUp(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal})
+	user := newTestPasswordUser(t, h, dal)
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.StartTOTPEnrollment(ctx, &authProto.StartTOTPEnrollmentRequest{AuthorizationToken: tokenString}); !errors.Is(err, svc.ErrStepUpRequired) {
+		t.Fatalf("got %v, want %v", err, svc.ErrStepUpRequired)
+	}
+}
+
+// Tokens of OAuth clients act for the user within their scope only, even
+// when the client stepped up its session.
+func TestPasskeyRegistrationRejectsOAuthTokens(t *testing.T) {
+	ctx := context.Background()
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{authDAL: dal, relyingParty: &testRelyingParty{}})
+	user := newTestPasswordUser(t, h, dal)
+
+	tokenString, _, err := h.issueFamilyTokenPair(ctx, user, model.TokenFamily{UserID: user.ID, ClientID: "client", Scope: "openid"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.StepUp(ctx, &authProto.StepUpRequest{AuthorizationToken: tokenString, Code: testPassword}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.BeginPasskeyRegistration(ctx, &authProto.BeginPasskeyRegistrationRequest{AuthorizationToken: tokenString}); !errors.Is(err, svc.ErrInsufficientScope) {
+		t.Fatalf("got %v, want %v", err, svc.ErrInsufficientScope)
+	}
+}
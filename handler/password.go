@@ -0,0 +1,130 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/handler/validator"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/notify"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"github.com/google/uuid"
+	"strconv"
+	"time"
+)
+
+func (h *Handler) ChangePassword(ctx context.Context, request *authProto.ChangePasswordRequest) (*authProto.ChangePasswordResponse, error) {
+	pendData := validator.ChangePasswordStruct{
+		ChangePasswordRequest: request,
+		ClientInfo:            clientInfoFromContext(ctx, h.Di.TrustedProxies()),
+	}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := h.Di.PasswordHasher().Hash(pendData.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.Di.AuthDAL().UpdatePassword(ctx, pendData.User.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
+	revokedCount, err := h.Di.AuthDAL().RevokeUserTokenFamilies(ctx, pendData.User.ID, pendData.TokenDetail.FamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	h.sendPasswordChanged(ctx, pendData.User)
+
+	return &authProto.ChangePasswordResponse{
+		RevokedCount: int32(revokedCount),
+	}, nil
+}
+
+func (h *Handler) RequestPasswordReset(ctx context.Context, request *authProto.RequestPasswordResetRequest) (*authProto.RequestPasswordResetResponse, error) {
+	pendData := validator.RequestPasswordResetStruct{RequestPasswordResetRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	// The reset is sent in the background so that the response looks and
+	// takes the same whether or not the email belongs to an account.
+	if pendData.UserFound {
+		go h.sendPasswordReset(h.AppCtx, pendData.User, localeFromContext(ctx))
+	}
+
+	return &authProto.RequestPasswordResetResponse{}, nil
+}
+
+func (h *Handler) ResetPassword(ctx context.Context, request *authProto.ResetPasswordRequest) (*authProto.ResetPasswordResponse, error) {
+	pendData := validator.ResetPasswordStruct{ResetPasswordRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	if err := h.Di.AuthDAL().DeletePasswordResetToken(ctx, request.ResetToken); err != nil {
+		return nil, err
+	}
+
+	hashedPassword, err := h.Di.PasswordHasher().Hash(pendData.NewPassword)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = h.Di.AuthDAL().UpdatePassword(ctx, pendData.User.ID, hashedPassword); err != nil {
+		return nil, err
+	}
+
+	if _, err = h.Di.AuthDAL().RevokeUserTokenFamilies(ctx, pendData.User.ID, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	// Whoever can reset the password owns the account, so a lockout caused
+	// by someone guessing it no longer needs to stand.
+	if err = h.Di.AuthDAL().ClearLoginFailures(ctx, pendData.User.Email); err != nil {
+		log.WithError(err).Warnf(ctx, "error in clear login failures")
+	}
+
+	h.sendPasswordChanged(ctx, pendData.User)
+
+	return &authProto.ResetPasswordResponse{}, nil
+}
+
+func (h *Handler) sendPasswordReset(ctx context.Context, user model.User, locale string) {
+	resetToken, err := h.Di.AuthDAL().StorePasswordResetToken(ctx, user, h.Di.Config().PasswordReset.Duration)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in store password reset token")
+		return
+	}
+
+	if err = h.Di.Notifier().Notify(ctx, notify.Message{
+		Kind:   notify.KindPasswordReset,
+		To:     user.Email,
+		Locale: locale,
+		Data: map[string]string{
+			"first_name":      user.FirstName,
+			"reset_token":     resetToken,
+			"reset_url":       tokenURL(h.Di.Config().PasswordReset.URL, resetToken),
+			"expire_duration": strconv.FormatUint(uint64(h.Di.Config().PasswordReset.Duration), 10),
+		},
+	}); err != nil {
+		log.WithError(err).Errorf(ctx, "error in send password reset")
+	}
+}
+
+// sendPasswordChanged alerts user that their password was changed, so that
+// a change they did not make does not go unnoticed.
+func (h *Handler) sendPasswordChanged(ctx context.Context, user model.User) {
+	if err := h.Di.Notifier().Notify(ctx, notify.Message{
+		Kind:   notify.KindPasswordChanged,
+		To:     user.Email,
+		Locale: localeFromContext(ctx),
+		Data: map[string]string{
+			"first_name": user.FirstName,
+			"changed_at": time.Now().UTC().Format(time.RFC1123),
+		},
+	}); err != nil {
+		log.WithError(err).Errorf(ctx, "error in send password changed alert")
+	}
+}
@@ -0,0 +1,52 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"testing"
+)
+
+// A stolen access token must not be a way around the login lock for
+// guessing the password.
+func TestChangePasswordCountsWrongPasswordsAsFailedLogins(t *testing.T) {
+	ctx := context.Background()
+
+	configuration := newTestConfig()
+	configuration.LoginThrottle.AccountLockoutThreshold = 3
+	configuration.LoginThrottle.LockoutDuration = 15
+
+	dal := newTestAuthDAL(t, nil)
+	h := newTestHandler(t, &testDI{config: configuration, authDAL: dal, notifier: &testNotifier{}})
+	user := newTestPasswordUser(t, h, dal)
+
+	tokenString, _, err := h.issueTokenPair(ctx, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	changePassword := func(currentPassword string) error {
+		_, err := h.ChangePassword(ctx, &authProto.ChangePasswordRequest{
+			AuthorizationToken: tokenString,
+			CurrentPassword:    currentPassword,
+			NewPassword:        "a new password",
+		})
+
+		return err
+	}
+
+	for attempt := 0; attempt < 3; attempt++ {
+		if err = changePassword("wrong password"); !errors.Is(err, svc.ErrWrongPassword) {
+			t.Fatalf("attempt %d: got %v, want %v", attempt, err, svc.ErrWrongPassword)
+		}
+	}
+
+	if err = changePassword(testPassword); !errors.Is(err, svc.ErrAccountLocked) {
+		t.Fatalf("got %v, want %v", err, svc.ErrAccountLocked)
+	}
+
+	if _, err = h.Login(ctx, &authProto.LoginRequest{Email: user.Email, Password: testPassword}); !errors.Is(err, svc.ErrAccountLocked) {
+		t.Fatalf("login: got %v, want %v", err, svc.ErrAccountLocked)
+	}
+}
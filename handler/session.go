@@ -0,0 +1,65 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/handler/validator"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func (h *Handler) ListSessions(ctx context.Context, request *authProto.ListSessionsRequest) (*authProto.ListSessionsResponse, error) {
+	pendData := validator.ListSessionsStruct{ListSessionsRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	sessions := make([]*authProto.Session, 0, len(pendData.TokenFamilies))
+	for _, tokenFamily := range pendData.TokenFamilies {
+		session := &authProto.Session{
+			Id:         tokenFamily.ID.String(),
+			IssuedAt:   timestamppb.New(tokenFamily.IssuedAt),
+			ExpiredAt:  timestamppb.New(tokenFamily.ExpiredAt),
+			ClientIp:   tokenFamily.IP,
+			UserAgent:  tokenFamily.UserAgent,
+			Device:     tokenFamily.Device,
+			LastUsedAt: timestamppb.New(tokenFamily.LastUsedAt),
+		}
+
+		if tokenFamily.Elevated() {
+			session.ElevatedUntil = timestamppb.New(*tokenFamily.ElevatedUntil)
+		}
+
+		sessions = append(sessions, session)
+	}
+
+	return &authProto.ListSessionsResponse{
+		Sessions: sessions,
+	}, nil
+}
+
+func (h *Handler) RevokeSession(ctx context.Context, request *authProto.RevokeSessionRequest) (*authProto.RevokeSessionResponse, error) {
+	pendData := validator.RevokeSessionStruct{RevokeSessionRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	h.Di.AuthDAL().RevokeTokenFamily(ctx, pendData.FamilyID)
+
+	return &authProto.RevokeSessionResponse{}, nil
+}
+
+func (h *Handler) RevokeAllSessions(ctx context.Context, request *authProto.RevokeAllSessionsRequest) (*authProto.RevokeAllSessionsResponse, error) {
+	pendData := validator.RevokeAllSessionsStruct{RevokeAllSessionsRequest: request}
+	if err := pendData.Validate(ctx, h.Di); err != nil {
+		return nil, err
+	}
+
+	revokedCount, err := h.Di.AuthDAL().RevokeUserTokenFamilies(ctx, pendData.UserID, pendData.CurrentFamilyID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &authProto.RevokeAllSessionsResponse{
+		RevokedCount: int32(revokedCount),
+	}, nil
+}
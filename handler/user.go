@@ -0,0 +1,18 @@
+package handler
+
+import (
+	"github.com/erfansahebi/lamia_auth/model"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+)
+
+// userResponse is the only place users are turned into responses. It copies
+// the public profile fields and never the password hash, which
+// UserStruct.Password only carries on the way in, in RegisterRequest.
+func userResponse(user model.User) *authProto.UserStruct {
+	return &authProto.UserStruct{
+		Id:        user.ID.String(),
+		FirstName: user.FirstName,
+		LastName:  user.LastName,
+		Email:     user.Email,
+	}
+}
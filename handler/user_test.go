@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/config"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/notify"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"testing"
+	"time"
+)
+
+const testPassword = "correct horse battery staple"
+
+// userResponseCalls makes each RPC whose response carries a user return one
+// for user, whose password is testPassword.
+var userResponseCalls = map[string]func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error){
+	"Register": func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error) {
+		return h.Register(context.Background(), &authProto.RegisterRequest{
+			User: &authProto.UserStruct{
+				FirstName: "New",
+				LastName:  "User",
+				Email:     "new.user@example.com",
+				Password:  testPassword,
+			},
+		})
+	},
+	"Login": func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error) {
+		return h.Login(context.Background(), &authProto.LoginRequest{Email: user.Email, Password: testPassword})
+	},
+	"GetUser": func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error) {
+		return h.GetUser(context.Background(), &authProto.GetUserRequest{UserId: user.ID.String()})
+	},
+	"RefreshToken": func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error) {
+		_, refreshToken, err := h.issueTokenPair(context.Background(), user)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return h.RefreshToken(context.Background(), &authProto.RefreshTokenRequest{RefreshToken: refreshToken})
+	},
+	"VerifyMFA": func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error) {
+		ctx := context.Background()
+		h.Di.Config().EmailOTP.Mode = config.EmailOTPModeAlways
+
+		loginResponse, err := h.Login(ctx, &authProto.LoginRequest{Email: user.Email, Password: testPassword})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		message, ok := h.Di.Notifier().(*testNotifier).lastMessage(notify.KindEmailOTP, user.Email)
+		if !ok {
+			t.Fatal("no email passcode was sent")
+		}
+
+		return h.VerifyMFA(ctx, &authProto.VerifyMFARequest{
+			MfaChallengeToken: loginResponse.MfaChallengeToken,
+			Method:            model.MFAMethodEmailOTP,
+			Code:              message.Data["code"],
+		})
+	},
+	"ConsumeMagicLink": func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error) {
+		ctx := context.Background()
+
+		magicLinkToken, err := dal.StoreMagicLink(ctx, model.MagicLink{
+			UserID:    user.ID,
+			Email:     user.Email,
+			FirstName: user.FirstName,
+			LastName:  user.LastName,
+		}, "nonce", 15)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return h.ConsumeMagicLink(ctx, &authProto.ConsumeMagicLinkRequest{Token: magicLinkToken, Nonce: "nonce"})
+	},
+	"FinishPasskeyAssertion": func(t *testing.T, h *Handler, dal *testAuthDAL, user model.User) (proto.Message, error) {
+		ctx := context.Background()
+
+		if _, err := dal.StoreWebAuthnCredential(ctx, model.WebAuthnCredential{
+			UserID:       user.ID,
+			CredentialID: []byte("credential"),
+			PublicKey:    []byte("public-key"),
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		beginResponse, err := h.BeginPasskeyAssertion(ctx, &authProto.BeginPasskeyAssertionRequest{})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return h.FinishPasskeyAssertion(ctx, &authProto.FinishPasskeyAssertionRequest{
+			SessionToken: beginResponse.SessionToken,
+			Credential:   "credential",
+		})
+	},
+}
+
+// No RPC response may carry a password hash. The test finds the RPCs that
+// respond with users from the service definition, so new ones fail it until
+// they are called here too.
+func TestResponsesNeverCarryPasswordHashes(t *testing.T) {
+	userStruct := (&authProto.UserStruct{}).ProtoReflect().Descriptor()
+	methods := authProto.File_proto_auth_auth_proto.Services().ByName("AuthService").Methods()
+
+	for i := 0; i < methods.Len(); i++ {
+		method := methods.Get(i)
+		if !containsMessage(method.Output(), userStruct, map[protoreflect.FullName]bool{}) {
+			continue
+		}
+
+		name := string(method.Name())
+		t.Run(name, func(t *testing.T) {
+			call, ok := userResponseCalls[name]
+			if !ok {
+				t.Fatalf("%s responds with users but is not called by this test", name)
+			}
+
+			dal := newTestAuthDAL(t, nil)
+			h := newTestHandler(t, &testDI{
+				authDAL:      dal,
+				notifier:     &testNotifier{},
+				relyingParty: &testRelyingParty{},
+			})
+
+			passwordHash, err := h.Di.PasswordHasher().Hash(testPassword)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			verifiedAt := time.Now()
+			user, err := dal.StoreUser(context.Background(), model.User{
+				FirstName:       "Test",
+				LastName:        "User",
+				Email:           "test.user@example.com",
+				Password:        passwordHash,
+				EmailVerifiedAt: &verifiedAt,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			response, err := call(t, h, dal, user)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			users := 0
+			walkMessages(response.ProtoReflect(), func(message protoreflect.Message) {
+				if message.Descriptor() != userStruct {
+					return
+				}
+
+				users++
+				if password := message.Interface().(*authProto.UserStruct).Password; password != "" {
+					t.Errorf("response carries password %q", password)
+				}
+			})
+
+			if users == 0 {
+				t.Fatal("response carries no user")
+			}
+		})
+	}
+}
+
+// containsMessage reports whether messages of descriptor can hold target.
+func containsMessage(descriptor protoreflect.MessageDescriptor, target protoreflect.MessageDescriptor, seen map[protoreflect.FullName]bool) bool {
+	if descriptor.FullName() == target.FullName() {
+		return true
+	}
+
+	if seen[descriptor.FullName()] {
+		return false
+	}
+	seen[descriptor.FullName()] = true
+
+	fields := descriptor.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		field := fields.Get(i)
+		if field.IsMap() {
+			field = field.MapValue()
+		}
+
+		if field.Message() != nil && containsMessage(field.Message(), target, seen) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// walkMessages calls visit for message and every message set within it.
+func walkMessages(message protoreflect.Message, visit func(message protoreflect.Message)) {
+	visit(message)
+
+	message.Range(func(field protoreflect.FieldDescriptor, value protoreflect.Value) bool {
+		switch {
+		case field.IsList() && field.Message() != nil:
+			list := value.List()
+			for i := 0; i < list.Len(); i++ {
+				walkMessages(list.Get(i).Message(), visit)
+			}
+		case field.IsMap() && field.MapValue().Message() != nil:
+			value.Map().Range(func(_ protoreflect.MapKey, mapValue protoreflect.Value) bool {
+				walkMessages(mapValue.Message(), visit)
+				return true
+			})
+		case field.Message() != nil:
+			walkMessages(value.Message(), visit)
+		}
+
+		return true
+	})
+}
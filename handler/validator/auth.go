@@ -1,13 +1,20 @@
 package validator
 
 import (
+	"bytes"
 	"context"
+	"github.com/erfansahebi/lamia_auth/config"
 	"github.com/erfansahebi/lamia_auth/di"
+	"github.com/erfansahebi/lamia_auth/mfa"
 	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/password"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/pwned"
 	"github.com/erfansahebi/lamia_auth/svc"
-	authProto "github.com/erfansahebi/lamia_shared/go/proto/auth"
+	"github.com/erfansahebi/lamia_auth/webauthn"
+	"github.com/erfansahebi/lamia_shared/go/log"
 	"github.com/google/uuid"
-	"golang.org/x/crypto/bcrypt"
+	"time"
 )
 
 type RegisterStruct struct {
@@ -19,26 +26,317 @@ func (rs *RegisterStruct) Validate(ctx context.Context, di di.DIContainerInterfa
 		return svc.ErrUserExists
 	}
 
+	return checkPassword(ctx, di, "user.password", rs.User.Password, rs.User.FirstName, rs.User.LastName, rs.User.Email)
+}
+
+// checkPassword validates a new password against the password policy and the
+// pwned passwords data. personalInfo holds the names and email of its user.
+func checkPassword(ctx context.Context, di di.DIContainerInterface, field string, newPassword string, personalInfo ...string) error {
+	violations := di.PasswordPolicy().Check(newPassword, personalInfo...)
+
+	if pwnedChecker := di.PwnedChecker(); pwnedChecker != nil {
+		count, err := pwnedChecker.Count(ctx, newPassword)
+		switch {
+		case err != nil:
+			log.WithError(err).Errorf(ctx, "error in check pwned passwords")
+		case count == 0 || count < di.Config().PwnedPasswords.MinCount:
+			break
+		case di.Config().PwnedPasswords.Mode == pwned.ModeWarn:
+			log.Warnf(ctx, "password found %d times in pwned passwords", count)
+		default:
+			violations = append(violations, password.Violation{
+				Rule:        password.RuleBreached,
+				Description: "password has appeared in a data breach",
+			})
+		}
+	}
+
+	if len(violations) > 0 {
+		return passwordPolicyError(field, violations)
+	}
+
+	return nil
+}
+
+// checkEmailVerified refuses to hand out tokens to unverified users when the
+// email verification policy blocks them.
+func checkEmailVerified(di di.DIContainerInterface, user model.User) error {
+	if user.EmailVerifiedAt == nil && di.Config().EmailVerification.Policy == config.EmailVerificationPolicyBlock {
+		return svc.ErrEmailNotVerified
+	}
+
 	return nil
 }
 
 type LoginStruct struct {
+	ClientInfo  model.ClientInfo
 	FetchedUser model.User
+	MFAMethods  []string
 	*authProto.LoginRequest
 }
 
 func (ls *LoginStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	retryAfter, err := di.AuthDAL().FetchLoginLock(ctx, ls.Email, ls.ClientInfo.IP)
+	if err != nil {
+		return err
+	}
+
+	if retryAfter > 0 {
+		return &svc.AccountLockedError{RetryAfter: retryAfter}
+	}
+
+	passwordHasher := di.PasswordHasher()
+
+	// Unknown emails and passwordless accounts are checked against a dummy
+	// hash so that they take as long as wrong passwords and fail with the
+	// same error.
+	hasPassword := true
 	ls.FetchedUser, err = di.AuthDAL().FetchUserByEmail(ctx, ls.Email)
+	switch err {
+	case nil:
+		hasPassword = ls.FetchedUser.Password != ""
+	case svc.ErrUserDoesNotExists:
+		hasPassword = false
+	default:
+		return err
+	}
+
+	passwordHash := ls.FetchedUser.Password
+	if !hasPassword {
+		passwordHash = passwordHasher.DummyHash()
+	}
+
+	matched, err := passwordHasher.Verify(ls.Password, passwordHash)
+	if err != nil {
+		return err
+	}
+
+	// Hashes made with an older algorithm or outdated parameters can verify
+	// much faster than the dummy hash, which would set their accounts apart
+	// from unknown emails. Checking the dummy hash as well evens that out.
+	if hasPassword && passwordHasher.NeedsRehash(passwordHash) {
+		if _, err = passwordHasher.Verify(ls.Password, passwordHasher.DummyHash()); err != nil {
+			return err
+		}
+	}
+
+	if !hasPassword || !matched {
+		ls.FetchedUser = model.User{}
+
+		accountPolicy, ipPolicy := loginThrottlePolicies(di.Config())
+		if err = di.AuthDAL().RecordLoginFailure(ctx, ls.Email, ls.ClientInfo.IP, accountPolicy, ipPolicy); err != nil {
+			return err
+		}
+
+		return svc.ErrInvalidCredentials
+	}
+
+	if err = di.AuthDAL().ClearLoginFailures(ctx, ls.Email); err != nil {
+		log.WithError(err).Warnf(ctx, "error in clear login failures")
+	}
+
+	if passwordHasher.NeedsRehash(ls.FetchedUser.Password) {
+		ls.rehashPassword(ctx, di, passwordHasher)
+	}
+
+	if err = checkEmailVerified(di, ls.FetchedUser); err != nil {
+		return err
+	}
+
+	mfaMethods, mfaRequired, err := secondFactors(ctx, di, ls.FetchedUser)
+	if err != nil {
+		return err
+	}
+
+	if mfaRequired {
+		ls.MFAMethods = mfaMethods
+	}
+
+	return nil
+}
+
+// secondFactors returns the second factors user can answer. required is
+// false when the user may log in without one.
+func secondFactors(ctx context.Context, di di.DIContainerInterface, user model.User) (methods []string, required bool, err error) {
+	fetchedTOTP, err := di.AuthDAL().FetchTOTP(ctx, user.ID)
+	switch {
+	case err == svc.ErrMFANotEnabled:
+		break
+	case err != nil:
+		return nil, false, err
+	case fetchedTOTP.ConfirmedAt != nil:
+		methods = append(methods, model.MFAMethodTOTP, model.MFAMethodRecoveryCode)
+		required = true
+	}
+
+	credentials, err := di.AuthDAL().FetchUserWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return nil, false, err
+	}
+
+	for _, credential := range credentials {
+		if credential.Active() {
+			methods = append(methods, model.MFAMethodWebAuthn)
+			required = true
+			break
+		}
+	}
+
+	// A code sent to the inbox is weaker than an authenticator app or a
+	// passkey. Offering one next to them would let whoever reads the email
+	// skip the stronger factor.
+	emailOTPMode := di.Config().EmailOTP.Mode
+	if len(methods) == 0 && user.EmailVerifiedAt != nil && emailOTPMode != config.EmailOTPModeOff {
+		methods = append(methods, model.MFAMethodEmailOTP)
+		required = required || emailOTPMode == config.EmailOTPModeAlways
+	}
+
+	return methods, required, nil
+}
+
+func loginThrottlePolicies(configuration *config.Config) (accountPolicy svc.LoginThrottlePolicy, ipPolicy svc.LoginThrottlePolicy) {
+	accountPolicy = svc.LoginThrottlePolicy{
+		Window:           time.Duration(configuration.LoginThrottle.Window) * time.Minute,
+		BackoffBase:      time.Duration(configuration.LoginThrottle.BackoffBase) * time.Second,
+		BackoffThreshold: configuration.LoginThrottle.AccountBackoffThreshold,
+		LockoutThreshold: configuration.LoginThrottle.AccountLockoutThreshold,
+		LockoutDuration:  time.Duration(configuration.LoginThrottle.LockoutDuration) * time.Minute,
+	}
+
+	ipPolicy = accountPolicy
+	ipPolicy.BackoffThreshold = configuration.LoginThrottle.IPBackoffThreshold
+	ipPolicy.LockoutThreshold = configuration.LoginThrottle.IPLockoutThreshold
+
+	return accountPolicy, ipPolicy
+}
+
+// rehashPassword upgrades a hash made with an outdated algorithm or outdated
+// parameters while the plaintext password is at hand. Failing to do so is not
+// a reason to refuse the login, the upgrade is retried on the next one.
+func (ls *LoginStruct) rehashPassword(ctx context.Context, di di.DIContainerInterface, passwordHasher password.HasherInterface) {
+	passwordHash, err := passwordHasher.Hash(ls.Password)
+	if err != nil {
+		log.WithError(err).Warnf(ctx, "error in rehash password")
+		return
+	}
+
+	if err = di.AuthDAL().UpdatePasswordHash(ctx, ls.FetchedUser.ID, passwordHash); err != nil {
+		log.WithError(err).Warnf(ctx, "error in update password hash")
+		return
+	}
+
+	ls.FetchedUser.Password = passwordHash
+}
+
+type ChangePasswordStruct struct {
+	TokenDetail model.Token
+	User        model.User
+	ClientInfo  model.ClientInfo
+	*authProto.ChangePasswordRequest
+}
+
+func (cs *ChangePasswordStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	cs.TokenDetail, _, err = fetchSession(ctx, di, cs.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	cs.User, err = di.AuthDAL().FetchUser(ctx, cs.TokenDetail.UserID)
+	if err != nil {
+		return err
+	}
+
+	// Guessing the current password here counts against the same lock as
+	// guessing it at login.
+	if err = checkSecondFactor(ctx, di, cs.User, cs.ClientInfo, model.StepUpMethodPassword, cs.CurrentPassword, ""); err != nil {
+		return err
+	}
+
+	return checkPassword(ctx, di, "new_password", cs.NewPassword, cs.User.FirstName, cs.User.LastName, cs.User.Email)
+}
+
+// verifyCurrentPassword rejects currentPassword with ErrWrongPassword unless
+// it is the password of user.
+func verifyCurrentPassword(di di.DIContainerInterface, user model.User, currentPassword string) error {
+	// Passwordless accounts get their first password through a reset, which
+	// proves they own the email.
+	if user.Password == "" {
+		return svc.ErrWrongPassword
+	}
+
+	matched, err := di.PasswordHasher().Verify(currentPassword, user.Password)
+	if err != nil {
+		return err
+	}
+
+	if !matched {
+		return svc.ErrWrongPassword
+	}
+
+	return nil
+}
+
+type RequestPasswordResetStruct struct {
+	User      model.User
+	UserFound bool
+	*authProto.RequestPasswordResetRequest
+}
+
+func (rs *RequestPasswordResetStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	rs.User, err = di.AuthDAL().FetchUserByEmail(ctx, rs.Email)
+	switch err {
+	case nil:
+		rs.UserFound = true
+	case svc.ErrUserDoesNotExists:
+		break
+	default:
+		return err
+	}
+
+	return nil
+}
+
+type ResetPasswordStruct struct {
+	User model.User
+	*authProto.ResetPasswordRequest
+}
+
+func (rs *ResetPasswordStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	rs.User, err = di.AuthDAL().FetchPasswordResetToken(ctx, rs.ResetToken)
+	if err != nil {
+		return err
+	}
+
+	return checkPassword(ctx, di, "new_password", rs.NewPassword, rs.User.FirstName, rs.User.LastName, rs.User.Email)
+}
+
+type VerifyEmailStruct struct {
+	User model.User
+	*authProto.VerifyEmailRequest
+}
+
+func (vs *VerifyEmailStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	vs.User, err = di.AuthDAL().ConsumeEmailVerificationCode(ctx, vs.Code)
 	if err != nil {
 		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(ls.FetchedUser.Password), []byte(ls.Password))
+	return nil
+}
+
+type ResendVerificationStruct struct {
+	User         model.User
+	NeedsSending bool
+	*authProto.ResendVerificationRequest
+}
+
+func (rs *ResendVerificationStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	rs.User, err = di.AuthDAL().FetchUserByEmail(ctx, rs.Email)
 	switch err {
 	case nil:
+		rs.NeedsSending = rs.User.EmailVerifiedAt == nil
+	case svc.ErrUserDoesNotExists:
 		break
-	case bcrypt.ErrMismatchedHashAndPassword:
-		return svc.ErrUserDoesNotExists
 	default:
 		return err
 	}
@@ -48,11 +346,12 @@ func (ls *LoginStruct) Validate(ctx context.Context, di di.DIContainerInterface)
 
 type AuthenticateStruct struct {
 	TokenDetail model.Token
+	TokenFamily model.TokenFamily
 	*authProto.AuthenticateRequest
 }
 
 func (as *AuthenticateStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
-	as.TokenDetail, err = di.AuthDAL().FetchToken(ctx, as.AuthorizationToken)
+	as.TokenDetail, as.TokenFamily, err = fetchSession(ctx, di, as.AuthorizationToken)
 	if err != nil {
 		return err
 	}
@@ -60,6 +359,52 @@ func (as *AuthenticateStruct) Validate(ctx context.Context, di di.DIContainerInt
 	return nil
 }
 
+// fetchSession returns the access token authorizationToken along with the
+// session it belongs to. Tokens whose session has ended are rejected even
+// when they are still stored, so that a revoked session cannot live on
+// through a token its revocation missed. Tokens outside of any session, such
+// as client credentials grants, come with an empty TokenFamily.
+func fetchSession(ctx context.Context, di di.DIContainerInterface, authorizationToken string) (tokenDetail model.Token, tokenFamily model.TokenFamily, err error) {
+	tokenDetail, err = di.AuthDAL().FetchToken(ctx, authorizationToken)
+	if err != nil {
+		return model.Token{}, model.TokenFamily{}, err
+	}
+
+	if tokenDetail.FamilyID == uuid.Nil {
+		return tokenDetail, model.TokenFamily{}, nil
+	}
+
+	tokenFamily, err = di.AuthDAL().FetchTokenFamily(ctx, tokenDetail.FamilyID)
+	switch {
+	case err == svc.ErrEntryNotFound:
+		return model.Token{}, model.TokenFamily{}, svc.ErrInvalidToken
+	case err != nil:
+		return model.Token{}, model.TokenFamily{}, err
+	}
+
+	return tokenDetail, tokenFamily, nil
+}
+
+// fetchElevatedSession is fetchSession for actions that add a way to sign
+// in. Those need a session the user stepped up recently, so that a stolen
+// access token alone cannot be turned into lasting access, and are never
+// open to tokens issued to OAuth clients.
+func fetchElevatedSession(ctx context.Context, di di.DIContainerInterface, authorizationToken string) (tokenDetail model.Token, err error) {
+	tokenDetail, tokenFamily, err := fetchSession(ctx, di, authorizationToken)
+	switch {
+	case err != nil:
+		return model.Token{}, err
+	case tokenDetail.ClientID != "":
+		return model.Token{}, svc.ErrInsufficientScope
+	case tokenDetail.FamilyID == uuid.Nil:
+		return model.Token{}, svc.ErrInvalidToken
+	case !tokenFamily.Elevated():
+		return model.Token{}, svc.ErrStepUpRequired
+	}
+
+	return tokenDetail, nil
+}
+
 type UserStruct struct {
 	*authProto.GetUserRequest
 	User model.User
@@ -67,10 +412,62 @@ type UserStruct struct {
 
 func (us *UserStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
 	userID, err := uuid.Parse(us.UserId)
+	if err != nil {
+		return svc.ErrInvalidUUID
+	}
+
+	us.User, err = di.AuthDAL().FetchUser(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type RefreshTokenStruct struct {
+	ClientInfo      model.ClientInfo
+	TokenFamily     model.TokenFamily
+	NewRefreshToken string
+	User            model.User
+	*authProto.RefreshTokenRequest
+}
+
+func (rs *RefreshTokenStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	// The user is checked before the refresh token is used up, otherwise a
+	// failed check would leave the client without a refresh token.
+	refreshTokenDetail, err := di.AuthDAL().FetchRefreshToken(ctx, rs.RefreshToken)
+	if err != nil {
+		return err
+	}
+
+	rs.User, err = di.AuthDAL().FetchUser(ctx, refreshTokenDetail.UserID)
+	if err != nil {
+		return err
+	}
+
+	if err = checkEmailVerified(di, rs.User); err != nil {
+		return err
+	}
+
+	rs.TokenFamily, rs.NewRefreshToken, err = di.AuthDAL().RotateRefreshToken(ctx, rs.RefreshToken, "", rs.ClientInfo, di.Config().RefreshToken.Duration)
 	if err != nil {
 		return err
 	}
 
+	return nil
+}
+
+type UnlockAccountStruct struct {
+	*authProto.UnlockAccountRequest
+	User model.User
+}
+
+func (us *UnlockAccountStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	userID, err := uuid.Parse(us.UserId)
+	if err != nil {
+		return svc.ErrInvalidUUID
+	}
+
 	us.User, err = di.AuthDAL().FetchUser(ctx, userID)
 	if err != nil {
 		return err
@@ -78,3 +475,690 @@ func (us *UserStruct) Validate(ctx context.Context, di di.DIContainerInterface)
 
 	return nil
 }
+
+type ListSessionsStruct struct {
+	*authProto.ListSessionsRequest
+	TokenFamilies []model.TokenFamily
+}
+
+func (ls *ListSessionsStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	userID, err := uuid.Parse(ls.UserId)
+	if err != nil {
+		return svc.ErrInvalidUUID
+	}
+
+	ls.TokenFamilies, err = di.AuthDAL().FetchUserTokenFamilies(ctx, userID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type RevokeSessionStruct struct {
+	*authProto.RevokeSessionRequest
+	FamilyID uuid.UUID
+}
+
+func (rs *RevokeSessionStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	rs.FamilyID, err = uuid.Parse(rs.SessionId)
+	if err != nil {
+		return svc.ErrInvalidUUID
+	}
+
+	return nil
+}
+
+type RevokeAllSessionsStruct struct {
+	*authProto.RevokeAllSessionsRequest
+	UserID          uuid.UUID
+	CurrentFamilyID uuid.UUID
+}
+
+func (rs *RevokeAllSessionsStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	rs.UserID, err = uuid.Parse(rs.UserId)
+	if err != nil {
+		return svc.ErrInvalidUUID
+	}
+
+	if !rs.ExceptCurrent {
+		return nil
+	}
+
+	tokenDetail, _, err := fetchSession(ctx, di, rs.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	if tokenDetail.UserID != rs.UserID {
+		return svc.ErrInvalidToken
+	}
+
+	rs.CurrentFamilyID = tokenDetail.FamilyID
+
+	return nil
+}
+
+type VerifyMFAStruct struct {
+	ClientInfo model.ClientInfo
+	Challenge  model.MFAChallenge
+	User       model.User
+	*authProto.VerifyMFARequest
+}
+
+func (vs *VerifyMFAStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	vs.Challenge, err = di.AuthDAL().FetchMFAChallenge(ctx, vs.MfaChallengeToken)
+	if err != nil {
+		return err
+	}
+
+	if vs.Method == "" && len(vs.Challenge.Methods) > 0 {
+		vs.Method = vs.Challenge.Methods[0]
+	}
+
+	if !containsString(vs.Challenge.Methods, vs.Method) {
+		return svc.ErrMFAMethodInvalid
+	}
+
+	vs.User, err = di.AuthDAL().FetchUser(ctx, vs.Challenge.UserID)
+	if err != nil {
+		return err
+	}
+
+	err = checkSecondFactor(ctx, di, vs.User, vs.ClientInfo, vs.Method, vs.Code, svc.MFAChallengeSubject(vs.MfaChallengeToken))
+	if err == svc.ErrInvalidMFACode {
+		if recordErr := di.AuthDAL().RecordMFAChallengeFailure(ctx, vs.MfaChallengeToken, di.Config().MFA.ChallengeMaxAttempts); recordErr != nil {
+			return recordErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkSecondFactor verifies code like verifySecondFactor, or as the password
+// of user for StepUpMethodPassword. It refuses while logins of user are
+// locked and counts wrong codes as failed logins, so that starting over with
+// the password does not buy unlimited guesses.
+func checkSecondFactor(ctx context.Context, di di.DIContainerInterface, user model.User, clientInfo model.ClientInfo, method string, code string, subject string) error {
+	retryAfter, err := di.AuthDAL().FetchLoginLock(ctx, user.Email, clientInfo.IP)
+	if err != nil {
+		return err
+	}
+
+	if retryAfter > 0 {
+		return &svc.AccountLockedError{RetryAfter: retryAfter}
+	}
+
+	if method == model.StepUpMethodPassword {
+		err = verifyCurrentPassword(di, user, code)
+	} else {
+		err = verifySecondFactor(ctx, di, user.ID, method, code, subject)
+	}
+	if err == svc.ErrInvalidMFACode || err == svc.ErrWrongPassword {
+		accountPolicy, ipPolicy := loginThrottlePolicies(di.Config())
+		if recordErr := di.AuthDAL().RecordLoginFailure(ctx, user.Email, clientInfo.IP, accountPolicy, ipPolicy); recordErr != nil {
+			return recordErr
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	if err = di.AuthDAL().ClearLoginFailures(ctx, user.Email); err != nil {
+		log.WithError(err).Warnf(ctx, "error in clear login failures")
+	}
+
+	return nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// verifySecondFactor checks code with the given method for userID and spends
+// it, so that it cannot be used again. Email passcodes and passkey
+// ceremonies are looked up under subject.
+func verifySecondFactor(ctx context.Context, di di.DIContainerInterface, userID uuid.UUID, method string, code string, subject string) error {
+	switch method {
+	case model.MFAMethodTOTP:
+		fetchedTOTP, err := di.AuthDAL().FetchTOTP(ctx, userID)
+		if err != nil {
+			return err
+		}
+
+		step, err := verifyTOTPCode(di, fetchedTOTP, code)
+		if err != nil {
+			return err
+		}
+
+		return di.AuthDAL().UseTOTPStep(ctx, userID, step)
+	case model.MFAMethodRecoveryCode:
+		return di.AuthDAL().UseRecoveryCode(ctx, userID, code)
+	case model.MFAMethodEmailOTP:
+		return di.AuthDAL().VerifyEmailOTP(ctx, subject, code, di.Config().EmailOTP.MaxAttempts)
+	case model.MFAMethodWebAuthn:
+		_, err := verifyPasskey(ctx, di, subject, userID, code)
+		if err == svc.ErrInvalidPasskey {
+			return svc.ErrInvalidMFACode
+		}
+
+		return err
+	default:
+		return svc.ErrMFAMethodInvalid
+	}
+}
+
+// verifyTOTPCode checks code against the authenticator app of fetchedTOTP and
+// returns the time step it belongs to.
+func verifyTOTPCode(di di.DIContainerInterface, fetchedTOTP model.TOTP, code string) (step int64, err error) {
+	secret, err := di.MFACipher().Decrypt(fetchedTOTP.EncryptedSecret, fetchedTOTP.UserID[:])
+	if err != nil {
+		return 0, err
+	}
+
+	step, ok := mfa.VerifyTOTP(string(secret), code, time.Now())
+	if !ok {
+		return 0, svc.ErrInvalidMFACode
+	}
+
+	return step, nil
+}
+
+// fetchMFAUser returns the user of tokenDetail along with their
+// authenticator app, which may not be set up or confirmed yet.
+func fetchMFAUser(ctx context.Context, di di.DIContainerInterface, tokenDetail model.Token) (user model.User, fetchedTOTP model.TOTP, err error) {
+	user, err = di.AuthDAL().FetchUser(ctx, tokenDetail.UserID)
+	if err != nil {
+		return model.User{}, model.TOTP{}, err
+	}
+
+	fetchedTOTP, err = di.AuthDAL().FetchTOTP(ctx, user.ID)
+	if err != nil && err != svc.ErrMFANotEnabled {
+		return model.User{}, model.TOTP{}, err
+	}
+
+	return user, fetchedTOTP, nil
+}
+
+type StartTOTPEnrollmentStruct struct {
+	User model.User
+	*authProto.StartTOTPEnrollmentRequest
+}
+
+func (ss *StartTOTPEnrollmentStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	tokenDetail, err := fetchElevatedSession(ctx, di, ss.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	var fetchedTOTP model.TOTP
+	ss.User, fetchedTOTP, err = fetchMFAUser(ctx, di, tokenDetail)
+	if err != nil {
+		return err
+	}
+
+	if fetchedTOTP.ConfirmedAt != nil {
+		return svc.ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+type ConfirmTOTPEnrollmentStruct struct {
+	User model.User
+	Step int64
+	*authProto.ConfirmTOTPEnrollmentRequest
+}
+
+func (cs *ConfirmTOTPEnrollmentStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	tokenDetail, _, err := fetchSession(ctx, di, cs.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	var fetchedTOTP model.TOTP
+	cs.User, fetchedTOTP, err = fetchMFAUser(ctx, di, tokenDetail)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case fetchedTOTP.ConfirmedAt != nil:
+		return svc.ErrMFAAlreadyEnabled
+	case fetchedTOTP.EncryptedSecret == nil:
+		return svc.ErrMFANotEnabled
+	}
+
+	cs.Step, err = verifyTOTPCode(di, fetchedTOTP, cs.Code)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type DisableTOTPStruct struct {
+	User       model.User
+	ClientInfo model.ClientInfo
+	*authProto.DisableTOTPRequest
+}
+
+func (ds *DisableTOTPStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	ds.User, err = checkMFACode(ctx, di, ds.AuthorizationToken, ds.ClientInfo, ds.Code)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type RegenerateRecoveryCodesStruct struct {
+	User       model.User
+	ClientInfo model.ClientInfo
+	*authProto.RegenerateRecoveryCodesRequest
+}
+
+func (rs *RegenerateRecoveryCodesStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	rs.User, err = checkMFACode(ctx, di, rs.AuthorizationToken, rs.ClientInfo, rs.Code)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// checkMFACode guards changes to the two-factor settings of the user
+// authorizationToken belongs to with a TOTP code or a recovery code. Wrong
+// codes count against the login lock, like they do at login.
+func checkMFACode(ctx context.Context, di di.DIContainerInterface, authorizationToken string, clientInfo model.ClientInfo, code string) (user model.User, err error) {
+	tokenDetail, _, err := fetchSession(ctx, di, authorizationToken)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	user, fetchedTOTP, err := fetchMFAUser(ctx, di, tokenDetail)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if fetchedTOTP.ConfirmedAt == nil {
+		return model.User{}, svc.ErrMFANotEnabled
+	}
+
+	method := model.MFAMethodRecoveryCode
+	if mfa.IsTOTPCode(code) {
+		method = model.MFAMethodTOTP
+	}
+
+	if err = checkSecondFactor(ctx, di, user, clientInfo, method, code, ""); err != nil {
+		return model.User{}, err
+	}
+
+	return user, nil
+}
+
+type SendMFAEmailCodeStruct struct {
+	User model.User
+	*authProto.SendMFAEmailCodeRequest
+}
+
+func (ss *SendMFAEmailCodeStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	challenge, err := di.AuthDAL().FetchMFAChallenge(ctx, ss.MfaChallengeToken)
+	if err != nil {
+		return err
+	}
+
+	if !containsString(challenge.Methods, model.MFAMethodEmailOTP) {
+		return svc.ErrMFAMethodInvalid
+	}
+
+	ss.User, err = di.AuthDAL().FetchUser(ctx, challenge.UserID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// fetchStepUpUser returns the session authorizationToken belongs to along with
+// its user and the methods it can be stepped up with.
+func fetchStepUpUser(ctx context.Context, di di.DIContainerInterface, authorizationToken string) (tokenDetail model.Token, user model.User, methods []string, err error) {
+	tokenDetail, _, err = fetchSession(ctx, di, authorizationToken)
+	if err != nil {
+		return model.Token{}, model.User{}, nil, err
+	}
+
+	if tokenDetail.FamilyID == uuid.Nil {
+		return model.Token{}, model.User{}, nil, svc.ErrInvalidToken
+	}
+
+	user, err = di.AuthDAL().FetchUser(ctx, tokenDetail.UserID)
+	if err != nil {
+		return model.Token{}, model.User{}, nil, err
+	}
+
+	methods, _, err = secondFactors(ctx, di, user)
+	if err != nil {
+		return model.Token{}, model.User{}, nil, err
+	}
+
+	// Without a second factor the password is the fresh proof of identity.
+	if len(methods) == 0 && user.Password != "" {
+		methods = []string{model.StepUpMethodPassword}
+	}
+
+	if len(methods) == 0 {
+		return model.Token{}, model.User{}, nil, svc.ErrMFANotEnabled
+	}
+
+	return tokenDetail, user, methods, nil
+}
+
+type StartStepUpStruct struct {
+	TokenDetail model.Token
+	User        model.User
+	Methods     []string
+	*authProto.StartStepUpRequest
+}
+
+func (ss *StartStepUpStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	ss.TokenDetail, ss.User, ss.Methods, err = fetchStepUpUser(ctx, di, ss.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	if ss.Method != "" && !containsString(ss.Methods, ss.Method) {
+		return svc.ErrMFAMethodInvalid
+	}
+
+	return nil
+}
+
+type StepUpStruct struct {
+	ClientInfo  model.ClientInfo
+	TokenDetail model.Token
+	User        model.User
+	*authProto.StepUpRequest
+}
+
+func (ss *StepUpStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	var methods []string
+	ss.TokenDetail, ss.User, methods, err = fetchStepUpUser(ctx, di, ss.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	if ss.Method == "" {
+		ss.Method = methods[0]
+	}
+
+	if !containsString(methods, ss.Method) {
+		return svc.ErrMFAMethodInvalid
+	}
+
+	return checkSecondFactor(ctx, di, ss.User, ss.ClientInfo, ss.Method, ss.Code, svc.StepUpSubject(ss.TokenDetail.FamilyID))
+}
+
+type RequestMagicLinkStruct struct {
+	User      model.User
+	UserFound bool
+	*authProto.RequestMagicLinkRequest
+}
+
+func (rs *RequestMagicLinkStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	rs.User, err = di.AuthDAL().FetchUserByEmail(ctx, rs.Email)
+	switch err {
+	case nil:
+		rs.UserFound = true
+	case svc.ErrUserDoesNotExists:
+		break
+	default:
+		return err
+	}
+
+	return nil
+}
+
+type ConsumeMagicLinkStruct struct {
+	MagicLink  model.MagicLink
+	User       model.User
+	NewUser    bool
+	MFAMethods []string
+	*authProto.ConsumeMagicLinkRequest
+}
+
+func (cs *ConsumeMagicLinkStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	cs.MagicLink, err = di.AuthDAL().FetchMagicLink(ctx, cs.Token, cs.Nonce)
+	if err != nil {
+		return err
+	}
+
+	if cs.MagicLink.UserID != uuid.Nil {
+		cs.User, err = di.AuthDAL().FetchUser(ctx, cs.MagicLink.UserID)
+		if err != nil {
+			return err
+		}
+
+		// Links sent to an email the user no longer has are void.
+		if cs.User.Email != cs.MagicLink.Email {
+			return svc.ErrInvalidToken
+		}
+	} else {
+		// The account may have been created since the link was sent.
+		cs.User, err = di.AuthDAL().FetchUserByEmail(ctx, cs.MagicLink.Email)
+		switch err {
+		case nil:
+			break
+		case svc.ErrUserDoesNotExists:
+			cs.NewUser = true
+			return nil
+		default:
+			return err
+		}
+	}
+
+	// Following the link proves the user owns the email.
+	if cs.User.EmailVerifiedAt == nil {
+		verifiedAt := time.Now()
+		cs.User.EmailVerifiedAt = &verifiedAt
+	}
+
+	mfaMethods, mfaRequired, err := secondFactors(ctx, di, cs.User)
+	if err != nil {
+		return err
+	}
+
+	if !mfaRequired {
+		return nil
+	}
+
+	// An email passcode goes to the inbox the link was just read from, so
+	// it proves nothing the link did not.
+	for _, method := range mfaMethods {
+		if method != model.MFAMethodEmailOTP {
+			cs.MFAMethods = append(cs.MFAMethods, method)
+		}
+	}
+
+	return nil
+}
+
+// verifyPasskey checks response against the passkey ceremony pending for
+// subject and records the use of the passkey. userID is uuid.Nil for
+// passwordless logins, where the passkey tells whose it is. Responses that
+// fail verification are rejected with ErrInvalidPasskey.
+func verifyPasskey(ctx context.Context, di di.DIContainerInterface, subject string, userID uuid.UUID, response string) (credential model.WebAuthnCredential, err error) {
+	session, err := di.AuthDAL().ConsumeWebAuthnSession(ctx, subject)
+	if err != nil {
+		return model.WebAuthnCredential{}, err
+	}
+
+	assertion, err := di.RelyingParty().ParseAssertion([]byte(response))
+	if err != nil {
+		return model.WebAuthnCredential{}, svc.ErrInvalidPasskey
+	}
+
+	credential, err = di.AuthDAL().FetchWebAuthnCredential(ctx, assertion.CredentialID)
+	switch {
+	case err == svc.ErrEntryNotFound:
+		return model.WebAuthnCredential{}, svc.ErrInvalidPasskey
+	case err != nil:
+		return model.WebAuthnCredential{}, err
+	case userID != uuid.Nil && credential.UserID != userID:
+		return model.WebAuthnCredential{}, svc.ErrInvalidPasskey
+	case !credential.Active():
+		return model.WebAuthnCredential{}, svc.ErrPasskeyCloned
+	}
+
+	signCount, err := di.RelyingParty().FinishAssertion(session, assertion, webauthn.Credential{
+		ID:         credential.CredentialID,
+		UserHandle: credential.UserID[:],
+		PublicKey:  credential.PublicKey,
+		SignCount:  uint32(credential.SignCount),
+	})
+	switch err {
+	case nil:
+		break
+	case webauthn.ErrCloneDetected:
+		if markErr := di.AuthDAL().MarkWebAuthnCredentialCloned(ctx, credential.ID); markErr != nil {
+			return model.WebAuthnCredential{}, markErr
+		}
+
+		log.Warnf(ctx, "passkey %s of user %s reported a sign count that did not increase", credential.ID, credential.UserID)
+
+		return model.WebAuthnCredential{}, svc.ErrPasskeyCloned
+	default:
+		return model.WebAuthnCredential{}, svc.ErrInvalidPasskey
+	}
+
+	if err = di.AuthDAL().UseWebAuthnCredential(ctx, credential.ID, int64(signCount)); err != nil {
+		return model.WebAuthnCredential{}, err
+	}
+
+	return credential, nil
+}
+
+// fetchPasskeyUser returns the session authorizationToken belongs to along
+// with its user and their passkeys. The session has to be stepped up.
+func fetchPasskeyUser(ctx context.Context, di di.DIContainerInterface, authorizationToken string) (tokenDetail model.Token, user model.User, credentials []model.WebAuthnCredential, err error) {
+	tokenDetail, err = fetchElevatedSession(ctx, di, authorizationToken)
+	if err != nil {
+		return model.Token{}, model.User{}, nil, err
+	}
+
+	user, err = di.AuthDAL().FetchUser(ctx, tokenDetail.UserID)
+	if err != nil {
+		return model.Token{}, model.User{}, nil, err
+	}
+
+	credentials, err = di.AuthDAL().FetchUserWebAuthnCredentials(ctx, user.ID)
+	if err != nil {
+		return model.Token{}, model.User{}, nil, err
+	}
+
+	return tokenDetail, user, credentials, nil
+}
+
+type BeginPasskeyRegistrationStruct struct {
+	TokenDetail model.Token
+	User        model.User
+	Credentials []model.WebAuthnCredential
+	*authProto.BeginPasskeyRegistrationRequest
+}
+
+func (bs *BeginPasskeyRegistrationStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	bs.TokenDetail, bs.User, bs.Credentials, err = fetchPasskeyUser(ctx, di, bs.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type FinishPasskeyRegistrationStruct struct {
+	User       model.User
+	Credential webauthn.Credential
+	*authProto.FinishPasskeyRegistrationRequest
+}
+
+func (fs *FinishPasskeyRegistrationStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	var tokenDetail model.Token
+	tokenDetail, fs.User, _, err = fetchPasskeyUser(ctx, di, fs.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	session, err := di.AuthDAL().ConsumeWebAuthnSession(ctx, svc.PasskeyRegistrationSubject(tokenDetail.FamilyID))
+	if err != nil {
+		return err
+	}
+
+	if !bytes.Equal(session.UserID, fs.User.ID[:]) {
+		return svc.ErrInvalidPasskey
+	}
+
+	fs.Credential, err = di.RelyingParty().FinishRegistration(session, []byte(fs.GetCredential()))
+	if err != nil {
+		return svc.ErrInvalidPasskey
+	}
+
+	return nil
+}
+
+type BeginPasskeyAssertionStruct struct {
+	User model.User
+	*authProto.BeginPasskeyAssertionRequest
+}
+
+func (bs *BeginPasskeyAssertionStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	if bs.MfaChallengeToken == "" {
+		return nil
+	}
+
+	challenge, err := di.AuthDAL().FetchMFAChallenge(ctx, bs.MfaChallengeToken)
+	if err != nil {
+		return err
+	}
+
+	if !containsString(challenge.Methods, model.MFAMethodWebAuthn) {
+		return svc.ErrMFAMethodInvalid
+	}
+
+	bs.User, err = di.AuthDAL().FetchUser(ctx, challenge.UserID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+type FinishPasskeyAssertionStruct struct {
+	User model.User
+	*authProto.FinishPasskeyAssertionRequest
+}
+
+func (fs *FinishPasskeyAssertionStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	credential, err := verifyPasskey(ctx, di, svc.PasskeyLoginSubject(fs.SessionToken), uuid.Nil, fs.GetCredential())
+	switch {
+	case err == svc.ErrInvalidPasskey:
+		return svc.ErrInvalidCredentials
+	case err != nil:
+		return err
+	}
+
+	fs.User, err = di.AuthDAL().FetchUser(ctx, credential.UserID)
+	if err != nil {
+		return err
+	}
+
+	return checkEmailVerified(di, fs.User)
+}
@@ -0,0 +1,46 @@
+package validator
+
+import (
+	"github.com/erfansahebi/lamia_auth/password"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"strings"
+)
+
+const passwordPolicyErrorMessage = "password does not satisfy the password policy"
+
+// passwordPolicyError reports every rule the password given in field violates
+// as an InvalidArgument status. The BadRequest detail carries a description
+// per rule and the ErrorInfo detail the machine readable rule names.
+func passwordPolicyError(field string, violations []password.Violation) error {
+	rules := make([]string, 0, len(violations))
+	fieldViolations := make([]*errdetails.BadRequest_FieldViolation, 0, len(violations))
+
+	for _, violation := range violations {
+		rules = append(rules, violation.Rule)
+		fieldViolations = append(fieldViolations, &errdetails.BadRequest_FieldViolation{
+			Field:       field,
+			Description: violation.Description,
+		})
+	}
+
+	st, err := status.New(codes.InvalidArgument, passwordPolicyErrorMessage).WithDetails(
+		&errdetails.BadRequest{
+			FieldViolations: fieldViolations,
+		},
+		&errdetails.ErrorInfo{
+			Reason: "PASSWORD_POLICY_VIOLATION",
+			Domain: svc.ErrorDomain,
+			Metadata: map[string]string{
+				"rules": strings.Join(rules, ","),
+			},
+		},
+	)
+	if err != nil {
+		return status.Error(codes.InvalidArgument, passwordPolicyErrorMessage)
+	}
+
+	return st.Err()
+}
@@ -0,0 +1,406 @@
+package validator
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/di"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/oauth"
+	"github.com/erfansahebi/lamia_auth/svc"
+	"github.com/google/uuid"
+)
+
+// OAuthAuthorizeStruct validates an authorization request. Errors are
+// *oauth.Error values; once RedirectURI is resolved they can be reported to
+// the client by redirecting to it. ConsentRequired tells whether the user
+// has yet to allow the client access to Scopes.
+type OAuthAuthorizeStruct struct {
+	ResponseType        string
+	ClientID            string
+	RedirectURI         string
+	Scope               string
+	State               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	AuthorizationToken  string
+
+	Client           model.OAuthClient
+	User             model.User
+	Scopes           []string
+	RedirectResolved bool
+	ConsentRequired  bool
+}
+
+func (as *OAuthAuthorizeStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	as.Client, err = fetchOAuthClient(ctx, di, as.ClientID)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case as.RedirectURI == "" && len(as.Client.RedirectURIs) == 1:
+		as.RedirectURI = as.Client.RedirectURIs[0]
+	case !containsString(as.Client.RedirectURIs, as.RedirectURI):
+		return oauth.NewError(oauth.ErrorInvalidRequest, "redirect_uri is not registered for the client")
+	}
+
+	as.RedirectResolved = true
+
+	if as.ResponseType != oauth.ResponseTypeCode {
+		return oauth.NewError(oauth.ErrorUnsupportedResponseType, "")
+	}
+
+	if !containsString(as.Client.GrantTypes, model.OAuthGrantAuthorizationCode) {
+		return oauth.NewError(oauth.ErrorUnauthorizedClient, "")
+	}
+
+	if as.CodeChallengeMethod != oauth.CodeChallengeMethodS256 || !oauth.IsCodeChallenge(as.CodeChallenge) {
+		return oauth.NewError(oauth.ErrorInvalidRequest, "an S256 code_challenge is required")
+	}
+
+	var ok bool
+	as.Scopes, ok = oauth.NarrowScope(oauth.ParseScope(as.Scope), as.Client.Scopes)
+	if !ok {
+		return oauth.NewError(oauth.ErrorInvalidScope, "")
+	}
+
+	as.User, err = fetchOAuthSessionUser(ctx, di, as.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	as.ConsentRequired, err = oauthConsentRequired(ctx, di, as.Client, as.User.ID, as.Scopes)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// OAuthConsentStruct validates a look at a consent challenge by the user it
+// was made for, or with Answered set, their answer to it. Answering uses the
+// challenge up. Errors are *oauth.Error values.
+type OAuthConsentStruct struct {
+	ConsentChallenge   string
+	Consent            string
+	Answered           bool
+	AuthorizationToken string
+
+	Challenge model.OAuthConsentChallenge
+	Client    model.OAuthClient
+}
+
+func (cs *OAuthConsentStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	if cs.Answered && cs.Consent != oauth.ConsentAllow && cs.Consent != oauth.ConsentDeny {
+		return oauth.NewError(oauth.ErrorInvalidRequest, "consent must be allow or deny")
+	}
+
+	if cs.ConsentChallenge == "" {
+		return oauth.NewError(oauth.ErrorInvalidRequest, "consent_challenge is required")
+	}
+
+	cs.Challenge, err = di.AuthDAL().FetchOAuthConsentChallenge(ctx, cs.ConsentChallenge)
+	switch {
+	case err == svc.ErrEntryNotFound, err == svc.ErrInvalidToken:
+		return oauth.NewError(oauth.ErrorInvalidRequest, "consent_challenge is invalid or expired")
+	case err != nil:
+		return err
+	}
+
+	user, err := fetchOAuthSessionUser(ctx, di, cs.AuthorizationToken)
+	if err != nil {
+		return err
+	}
+
+	if user.ID != cs.Challenge.AuthorizationCode.UserID {
+		return oauth.NewError(oauth.ErrorAccessDenied, "consent_challenge belongs to another user")
+	}
+
+	cs.Client, err = di.AuthDAL().FetchOAuthClient(ctx, cs.Challenge.AuthorizationCode.ClientID)
+	switch {
+	case err == svc.ErrEntryNotFound:
+		return oauth.NewError(oauth.ErrorInvalidClient, "")
+	case err != nil:
+		return err
+	}
+
+	if !cs.Answered {
+		return nil
+	}
+
+	err = di.AuthDAL().DeleteOAuthConsentChallenge(ctx, cs.ConsentChallenge)
+	switch {
+	case err == svc.ErrEntryNotFound:
+		return oauth.NewError(oauth.ErrorInvalidRequest, "consent_challenge is invalid or expired")
+	case err != nil:
+		return err
+	}
+
+	return nil
+}
+
+// OAuthTokenStruct validates a token request and authenticates the client
+// making it. Errors are *oauth.Error values.
+type OAuthTokenStruct struct {
+	GrantType    string
+	ClientID     string
+	ClientSecret string
+	Code         string
+	RedirectURI  string
+	CodeVerifier string
+	RefreshToken string
+	Scope        string
+	ClientInfo   model.ClientInfo
+
+	Client          model.OAuthClient
+	User            model.User
+	Scopes          []string
+	TokenFamily     model.TokenFamily
+	NewRefreshToken string
+}
+
+func (ts *OAuthTokenStruct) Validate(ctx context.Context, di di.DIContainerInterface) (err error) {
+	switch ts.GrantType {
+	case model.OAuthGrantAuthorizationCode, model.OAuthGrantClientCredentials, model.OAuthGrantRefreshToken:
+		break
+	case "":
+		return oauth.NewError(oauth.ErrorInvalidRequest, "grant_type is required")
+	default:
+		return oauth.NewError(oauth.ErrorUnsupportedGrantType, "")
+	}
+
+	if err = ts.authenticateClient(ctx, di); err != nil {
+		return err
+	}
+
+	if !containsString(ts.Client.GrantTypes, ts.GrantType) {
+		return oauth.NewError(oauth.ErrorUnauthorizedClient, "")
+	}
+
+	switch ts.GrantType {
+	case model.OAuthGrantAuthorizationCode:
+		return ts.validateAuthorizationCode(ctx, di)
+	case model.OAuthGrantClientCredentials:
+		return ts.validateClientCredentials()
+	default:
+		return ts.validateRefreshToken(ctx, di)
+	}
+}
+
+// authenticateClient checks the secret of confidential clients. Public
+// clients only identify themselves.
+func (ts *OAuthTokenStruct) authenticateClient(ctx context.Context, di di.DIContainerInterface) (err error) {
+	if ts.ClientSecret == "" {
+		ts.Client, err = fetchOAuthClient(ctx, di, ts.ClientID)
+		if err != nil {
+			return err
+		}
+
+		if ts.Client.Confidential() {
+			return oauth.NewError(oauth.ErrorInvalidClient, "")
+		}
+
+		return nil
+	}
+
+	clientID, err := uuid.Parse(ts.ClientID)
+	if err != nil {
+		return oauth.NewError(oauth.ErrorInvalidClient, "")
+	}
+
+	ts.Client, err = di.AuthDAL().AuthenticateOAuthClient(ctx, clientID, ts.ClientSecret)
+	switch {
+	case err == svc.ErrInvalidClient:
+		return oauth.NewError(oauth.ErrorInvalidClient, "")
+	case err != nil:
+		return err
+	}
+
+	return nil
+}
+
+func (ts *OAuthTokenStruct) validateAuthorizationCode(ctx context.Context, di di.DIContainerInterface) error {
+	if ts.Code == "" || ts.CodeVerifier == "" {
+		return oauth.NewError(oauth.ErrorInvalidRequest, "code and code_verifier are required")
+	}
+
+	authorizationCode, err := di.AuthDAL().ConsumeOAuthAuthorizationCode(ctx, ts.Code)
+	switch {
+	case err == svc.ErrEntryNotFound, err == svc.ErrInvalidToken:
+		return oauth.NewError(oauth.ErrorInvalidGrant, "")
+	case err != nil:
+		return err
+	}
+
+	switch {
+	case authorizationCode.ClientID != ts.Client.ID,
+		authorizationCode.RedirectURI != "" && ts.RedirectURI != authorizationCode.RedirectURI,
+		!oauth.VerifyCodeChallenge(authorizationCode.CodeChallenge, ts.CodeVerifier):
+		return oauth.NewError(oauth.ErrorInvalidGrant, "")
+	}
+
+	ts.User, err = fetchOAuthUser(ctx, di, authorizationCode.UserID)
+	if err != nil {
+		return err
+	}
+
+	ts.Scopes = oauth.ParseScope(authorizationCode.Scope)
+
+	return nil
+}
+
+func (ts *OAuthTokenStruct) validateClientCredentials() error {
+	if !ts.Client.Confidential() {
+		return oauth.NewError(oauth.ErrorUnauthorizedClient, "")
+	}
+
+	var ok bool
+	ts.Scopes, ok = oauth.NarrowScope(oauth.ParseScope(ts.Scope), ts.Client.Scopes)
+	if !ok {
+		return oauth.NewError(oauth.ErrorInvalidScope, "")
+	}
+
+	return nil
+}
+
+func (ts *OAuthTokenStruct) validateRefreshToken(ctx context.Context, di di.DIContainerInterface) (err error) {
+	if ts.RefreshToken == "" {
+		return oauth.NewError(oauth.ErrorInvalidRequest, "refresh_token is required")
+	}
+
+	// Everything is checked before the refresh token is used up, a client
+	// turned away here keeps the one it has.
+	refreshTokenDetail, err := di.AuthDAL().FetchRefreshToken(ctx, ts.RefreshToken)
+	switch {
+	case err == svc.ErrEntryNotFound, err == svc.ErrInvalidToken:
+		return oauth.NewError(oauth.ErrorInvalidGrant, "")
+	case err != nil:
+		return err
+	}
+
+	tokenFamily, err := di.AuthDAL().FetchTokenFamily(ctx, refreshTokenDetail.FamilyID)
+	switch {
+	case err == svc.ErrEntryNotFound:
+		return oauth.NewError(oauth.ErrorInvalidGrant, "")
+	case err != nil:
+		return err
+	case tokenFamily.ClientID != ts.Client.ID.String():
+		return oauth.NewError(oauth.ErrorInvalidGrant, "")
+	}
+
+	ts.User, err = fetchOAuthUser(ctx, di, tokenFamily.UserID)
+	if err != nil {
+		return err
+	}
+
+	// The new access token may be narrowed, the session keeps its scope.
+	var ok bool
+	ts.Scopes, ok = oauth.NarrowScope(oauth.ParseScope(ts.Scope), oauth.ParseScope(tokenFamily.Scope))
+	if !ok {
+		return oauth.NewError(oauth.ErrorInvalidScope, "")
+	}
+
+	ts.TokenFamily, ts.NewRefreshToken, err = di.AuthDAL().RotateRefreshToken(ctx, ts.RefreshToken, ts.Client.ID.String(), ts.ClientInfo, di.Config().RefreshToken.Duration)
+	switch {
+	case err == svc.ErrEntryNotFound, err == svc.ErrInvalidToken, err == svc.ErrRefreshTokenUsed:
+		return oauth.NewError(oauth.ErrorInvalidGrant, "")
+	case err != nil:
+		return err
+	}
+
+	return nil
+}
+
+// fetchOAuthClient returns the client clientID names, reporting unknown ones
+// as invalid_client.
+func fetchOAuthClient(ctx context.Context, di di.DIContainerInterface, clientID string) (model.OAuthClient, error) {
+	parsedClientID, err := uuid.Parse(clientID)
+	if err != nil {
+		return model.OAuthClient{}, oauth.NewError(oauth.ErrorInvalidClient, "")
+	}
+
+	client, err := di.AuthDAL().FetchOAuthClient(ctx, parsedClientID)
+	switch {
+	case err == svc.ErrEntryNotFound:
+		return model.OAuthClient{}, oauth.NewError(oauth.ErrorInvalidClient, "")
+	case err != nil:
+		return model.OAuthClient{}, err
+	}
+
+	return client, nil
+}
+
+// fetchOAuthSessionUser returns the user signed in with authorizationToken,
+// who is the one to grant clients access to their account.
+func fetchOAuthSessionUser(ctx context.Context, di di.DIContainerInterface, authorizationToken string) (model.User, error) {
+	if authorizationToken == "" {
+		return model.User{}, oauth.NewError(oauth.ErrorLoginRequired, "")
+	}
+
+	tokenDetail, _, err := fetchSession(ctx, di, authorizationToken)
+	switch {
+	case err == svc.ErrInvalidToken:
+		return model.User{}, oauth.NewError(oauth.ErrorLoginRequired, "")
+	case err != nil:
+		return model.User{}, err
+	case tokenDetail.UserID == uuid.Nil, tokenDetail.ClientID != "":
+		// Only sessions of the user themselves can grant access to clients.
+		return model.User{}, oauth.NewError(oauth.ErrorLoginRequired, "")
+	}
+
+	user, err := di.AuthDAL().FetchUser(ctx, tokenDetail.UserID)
+	switch {
+	case err == svc.ErrUserDoesNotExists:
+		return model.User{}, oauth.NewError(oauth.ErrorLoginRequired, "")
+	case err != nil:
+		return model.User{}, err
+	}
+
+	if tokenDetail.Restricted || checkEmailVerified(di, user) != nil {
+		return model.User{}, oauth.NewError(oauth.ErrorAccessDenied, "email address has not been verified")
+	}
+
+	return user, nil
+}
+
+// oauthConsentRequired reports whether userID has yet to allow client
+// access to scopes. First party clients are part of the service and do not
+// ask.
+func oauthConsentRequired(ctx context.Context, di di.DIContainerInterface, client model.OAuthClient, userID uuid.UUID, scopes []string) (bool, error) {
+	if client.FirstParty {
+		return false, nil
+	}
+
+	consent, err := di.AuthDAL().FetchOAuthConsent(ctx, userID, client.ID)
+	switch {
+	case err == svc.ErrEntryNotFound:
+		return true, nil
+	case err != nil:
+		return false, err
+	}
+
+	for _, scope := range scopes {
+		if !containsString(consent.Scopes, scope) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// fetchOAuthUser returns the user a grant was made by, as long as they may
+// still sign in.
+func fetchOAuthUser(ctx context.Context, di di.DIContainerInterface, userID uuid.UUID) (model.User, error) {
+	user, err := di.AuthDAL().FetchUser(ctx, userID)
+	switch {
+	case err == svc.ErrUserDoesNotExists:
+		return model.User{}, oauth.NewError(oauth.ErrorInvalidGrant, "")
+	case err != nil:
+		return model.User{}, err
+	}
+
+	if checkEmailVerified(di, user) != nil {
+		return model.User{}, oauth.NewError(oauth.ErrorInvalidGrant, "email address has not been verified")
+	}
+
+	return user, nil
+}
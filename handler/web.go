@@ -0,0 +1,21 @@
+package handler
+
+import (
+	"net/http"
+)
+
+// WebHandler serves the HTTP endpoints of the service: the JWKS document in
+// JWT mode and the OAuth authorization server when it is enabled.
+func (h *Handler) WebHandler() http.Handler {
+	mux := http.NewServeMux()
+
+	if h.Di.JWTIssuer() != nil {
+		mux.HandleFunc(jwksPath, h.jwksDocument)
+	}
+
+	if h.Di.Config().OAuth.Enabled {
+		h.handleOAuth(mux)
+	}
+
+	return mux
+}
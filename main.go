@@ -8,13 +8,17 @@ import (
 	"github.com/erfansahebi/lamia_auth/database"
 	"github.com/erfansahebi/lamia_auth/di"
 	"github.com/erfansahebi/lamia_auth/handler"
+	"github.com/erfansahebi/lamia_auth/model"
+	authProto "github.com/erfansahebi/lamia_auth/proto/auth"
+	"github.com/erfansahebi/lamia_auth/pwned"
 	sharedCommon "github.com/erfansahebi/lamia_shared/go/common"
 	"github.com/erfansahebi/lamia_shared/go/log"
-	authProto "github.com/erfansahebi/lamia_shared/go/proto/auth"
 	"google.golang.org/grpc"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
@@ -30,6 +34,14 @@ func main() {
 
 	migrateSteps := flag.Int("migrate", 0, "number of steps to migrate")
 	migrateName := flag.String("mname", "", "migration name")
+	pwnedSource := flag.String("pwned-source", "", "pwned passwords range directory or dump to index")
+	pwnedIndex := flag.String("pwned-index", "", "path of the pwned passwords index to build")
+	clientName := flag.String("client-name", "", "name of the oauth client to create")
+	clientRedirectURIs := flag.String("redirect-uris", "", "comma separated redirect uris of the oauth client")
+	clientGrantTypes := flag.String("grant-types", model.OAuthGrantAuthorizationCode+","+model.OAuthGrantRefreshToken, "comma separated grant types of the oauth client")
+	clientScopes := flag.String("scopes", "", "comma separated scopes of the oauth client")
+	publicClient := flag.Bool("public", false, "create a public oauth client without a secret")
+	firstPartyClient := flag.Bool("first-party", false, "create an oauth client of the service itself that users are not asked to consent to")
 	flag.Parse()
 
 	cmd := flag.Arg(0)
@@ -46,15 +58,32 @@ func main() {
 				panic(err)
 			}
 
-			grpcServer := grpc.NewServer()
+			diContainer := di.NewDIContainer(ctx, configurations)
+
+			// Parsed up front, so that a bad proxy CIDR stops the server
+			// before it takes any calls.
+			diContainer.TrustedProxies()
+
+			grpcServer := grpc.NewServer(
+				grpc.ChainUnaryInterceptor(
+					handler.ErrorInterceptor(),
+					handler.TokenInterceptor(diContainer),
+					handler.RateLimitInterceptor(diContainer),
+					handler.ScopeInterceptor(),
+				),
+			)
 
 			h := handler.Handler{
 				AppCtx: ctx,
-				Di:     di.NewDIContainer(ctx, configurations),
+				Di:     diContainer,
 			}
 
 			authProto.RegisterAuthServiceServer(grpcServer, &h)
 
+			if configurations.OAuth.Enabled || configurations.AuthorizationToken.Type == config.AuthorizationTokenTypeJWT {
+				go serveWeb(ctx, configurations, &h)
+			}
+
 			if err = grpcServer.Serve(lis); err != nil {
 				log.WithError(err).Fatalf(ctx, "failed to serve grpc server")
 				panic(err)
@@ -67,6 +96,54 @@ func main() {
 				panic(err)
 			}
 
+			cancel()
+		case "rotate-keys":
+			diContainer := di.NewDIContainer(ctx, configurations)
+			gracePeriod := time.Duration(configurations.JWT.KeyRotationGracePeriod) * time.Minute
+
+			if err = diContainer.SigningKeyStore().RotateSigningKeys(ctx, configurations.JWT.Algorithm, gracePeriod); err != nil {
+				log.WithError(err).Fatalf(ctx, "failed to rotate signing keys")
+				panic(err)
+			}
+
+			log.Infof(ctx, "Successfully rotated signing keys")
+
+			cancel()
+		case "create-oauth-client":
+			client := model.OAuthClient{
+				Name:         *clientName,
+				RedirectURIs: splitFlagList(*clientRedirectURIs),
+				GrantTypes:   splitFlagList(*clientGrantTypes),
+				Scopes:       splitFlagList(*clientScopes),
+				FirstParty:   *firstPartyClient,
+			}
+			if err = checkOAuthClient(client, !*publicClient); err != nil {
+				log.WithError(err).Fatalf(ctx, "invalid oauth client")
+				panic(err)
+			}
+
+			diContainer := di.NewDIContainer(ctx, configurations)
+
+			client, clientSecret, err := diContainer.AuthDAL().StoreOAuthClient(ctx, client, !*publicClient)
+			if err != nil {
+				log.WithError(err).Fatalf(ctx, "failed to create oauth client")
+				panic(err)
+			}
+
+			fmt.Printf("client_id: %s\n", client.ID)
+			if clientSecret != "" {
+				fmt.Printf("client_secret: %s\n", clientSecret)
+			}
+
+			cancel()
+		case "build-pwned-index":
+			if err = buildPwnedIndex(*pwnedSource, *pwnedIndex); err != nil {
+				log.WithError(err).Fatalf(ctx, "failed to build pwned passwords index")
+				panic(err)
+			}
+
+			log.Infof(ctx, "Successfully built pwned passwords index: %s", *pwnedIndex)
+
 			cancel()
 		case "makemigration":
 			if err = database.MakeMigration(ctx, configurations, *migrateName); err != nil {
@@ -94,3 +171,77 @@ func main() {
 
 	time.Sleep(1 * time.Second)
 }
+
+func serveWeb(ctx context.Context, configurations *config.Config, h *handler.Handler) {
+	server := &http.Server{
+		Addr:              fmt.Sprintf("%s:%s", configurations.Web.Host, configurations.Web.Port),
+		Handler:           h.WebHandler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	log.Infof(ctx, "Web Server starting on: %s", server.Addr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.WithError(err).Fatalf(ctx, "failed to serve web server")
+		panic(err)
+	}
+}
+
+// checkOAuthClient rejects clients that could never complete a grant.
+func checkOAuthClient(client model.OAuthClient, confidential bool) error {
+	if client.Name == "" || len(client.GrantTypes) == 0 {
+		return sharedCommon.ErrWrongCommand
+	}
+
+	for _, grantType := range client.GrantTypes {
+		switch grantType {
+		case model.OAuthGrantAuthorizationCode:
+			if len(client.RedirectURIs) == 0 {
+				return sharedCommon.ErrWrongCommand
+			}
+		case model.OAuthGrantClientCredentials:
+			if !confidential {
+				return sharedCommon.ErrWrongCommand
+			}
+		case model.OAuthGrantRefreshToken:
+		default:
+			return sharedCommon.ErrWrongCommand
+		}
+	}
+
+	return nil
+}
+
+func splitFlagList(value string) []string {
+	values := make([]string, 0)
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			values = append(values, v)
+		}
+	}
+
+	return values
+}
+
+func buildPwnedIndex(source string, index string) error {
+	if source == "" || index == "" {
+		return sharedCommon.ErrWrongCommand
+	}
+
+	output, err := os.Create(index)
+	if err != nil {
+		return err
+	}
+
+	if _, err = pwned.BuildIndex(source, output); err != nil {
+		output.Close()
+		os.Remove(index)
+		return err
+	}
+
+	return output.Close()
+}
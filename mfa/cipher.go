@@ -0,0 +1,73 @@
+package mfa
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+)
+
+var (
+	ErrInvalidEncryptionKey = errors.New("mfa encryption key must be 32 base64 encoded bytes")
+	ErrDecryptionFailed     = errors.New("mfa secret could not be decrypted")
+)
+
+// CipherInterface encrypts factor secrets before they are stored. The
+// additional data binds a ciphertext to its owner, so that a secret copied
+// to another row fails to decrypt.
+type CipherInterface interface {
+	Encrypt(plaintext []byte, additionalData []byte) ([]byte, error)
+	Decrypt(ciphertext []byte, additionalData []byte) ([]byte, error)
+}
+
+type gcmCipher struct {
+	aead cipher.AEAD
+}
+
+// NewCipher returns an AES-256-GCM cipher for the base64 encoded key.
+func NewCipher(encodedKey string) (CipherInterface, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil || len(key) != 32 {
+		return nil, ErrInvalidEncryptionKey
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	return &gcmCipher{
+		aead: aead,
+	}, nil
+}
+
+// Encrypt returns the random nonce followed by the sealed plaintext.
+func (c *gcmCipher) Encrypt(plaintext []byte, additionalData []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+
+	return c.aead.Seal(nonce, nonce, plaintext, additionalData), nil
+}
+
+func (c *gcmCipher) Decrypt(ciphertext []byte, additionalData []byte) ([]byte, error) {
+	if len(ciphertext) < c.aead.NonceSize() {
+		return nil, ErrDecryptionFailed
+	}
+
+	nonce, sealed := ciphertext[:c.aead.NonceSize()], ciphertext[c.aead.NonceSize():]
+
+	plaintext, err := c.aead.Open(nil, nonce, sealed, additionalData)
+	if err != nil {
+		return nil, ErrDecryptionFailed
+	}
+
+	return plaintext, nil
+}
@@ -0,0 +1,49 @@
+package mfa
+
+import (
+	"crypto/rand"
+	"math/big"
+	"strings"
+)
+
+const (
+	// recoveryCodeAlphabet leaves out characters that are easily mistaken
+	// for one another, such as 0 and o or 1 and l.
+	recoveryCodeAlphabet = "23456789abcdefghjkmnpqrstuvwxyz"
+	recoveryCodeLength   = 10
+)
+
+// GenerateRecoveryCodes returns count new recovery codes formatted as
+// xxxxx-xxxxx. Each one carries about 50 bits of randomness.
+func GenerateRecoveryCodes(count int) ([]string, error) {
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	codes := make([]string, 0, count)
+
+	for len(codes) < count {
+		code := make([]byte, recoveryCodeLength)
+		for i := range code {
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, err
+			}
+
+			code[i] = recoveryCodeAlphabet[n.Int64()]
+		}
+
+		codes = append(codes, string(code[:recoveryCodeLength/2])+"-"+string(code[recoveryCodeLength/2:]))
+	}
+
+	return codes, nil
+}
+
+// NormalizeRecoveryCode returns code the way it is hashed, ignoring case,
+// spaces and dashes typed by the user.
+func NormalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || r == ' ' {
+			return -1
+		}
+
+		return r
+	}, strings.ToLower(strings.TrimSpace(code)))
+}
@@ -0,0 +1,15 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// EmailVerification is a pending verification of Email. It no longer applies
+// once the user changes their email.
+type EmailVerification struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
@@ -0,0 +1,20 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// MagicLink is a pending passwordless sign-in for Email. UserID is nil when
+// no account existed at request time, in which case FirstName and LastName
+// are used to create it. NonceHash binds the link to the device it was
+// requested from, when that device sent a nonce.
+type MagicLink struct {
+	UserID    uuid.UUID `json:"user_id"`
+	Email     string    `json:"email"`
+	FirstName string    `json:"first_name"`
+	LastName  string    `json:"last_name"`
+	NonceHash string    `json:"nonce_hash"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
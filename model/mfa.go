@@ -0,0 +1,38 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	MFAMethodTOTP         = "totp"
+	MFAMethodRecoveryCode = "recovery_code"
+	MFAMethodEmailOTP     = "email_otp"
+	MFAMethodWebAuthn     = "webauthn"
+)
+
+// StepUpMethodPassword steps up the sessions of users who have no second
+// factor to do it with.
+const StepUpMethodPassword = "password"
+
+// TOTP is the authenticator app of a user. It only counts as a factor once
+// ConfirmedAt is set. LastUsedStep is the time step of the last accepted
+// code, so that codes cannot be replayed.
+type TOTP struct {
+	UserID          uuid.UUID  `json:"user_id"`
+	EncryptedSecret []byte     `json:"encrypted_secret"`
+	LastUsedStep    int64      `json:"last_used_step"`
+	ConfirmedAt     *time.Time `json:"confirmed_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// MFAChallenge is a login that passed the password check and waits for a
+// second factor. Methods lists the factors the user can answer it with.
+type MFAChallenge struct {
+	UserID uuid.UUID `json:"user_id"`
+	ClientInfo
+	Methods   []string  `json:"methods"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
@@ -0,0 +1,68 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+const (
+	OAuthGrantAuthorizationCode = "authorization_code"
+	OAuthGrantClientCredentials = "client_credentials"
+	OAuthGrantRefreshToken      = "refresh_token"
+)
+
+// OAuthClient is an application registered with the authorization server.
+// Public clients, such as browser and mobile apps, have no secret and can
+// only use the grants that PKCE protects. Users are asked for consent before
+// clients that are not FirstParty get access to their account.
+type OAuthClient struct {
+	ID           uuid.UUID `json:"id"`
+	Name         string    `json:"name"`
+	SecretHash   string    `json:"secret_hash"`
+	RedirectURIs []string  `json:"redirect_uris"`
+	GrantTypes   []string  `json:"grant_types"`
+	Scopes       []string  `json:"scopes"`
+	FirstParty   bool      `json:"first_party"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Confidential reports whether the client authenticates with a secret.
+func (c OAuthClient) Confidential() bool {
+	return c.SecretHash != ""
+}
+
+// OAuthAuthorizationCode is a grant of Scope by a user to a client, waiting
+// to be exchanged for tokens along with the verifier of CodeChallenge.
+// RedirectURI is the redirect_uri of the authorization request, empty when
+// the client left it out; the token request has to repeat it.
+type OAuthAuthorizationCode struct {
+	ClientID uuid.UUID `json:"client_id"`
+	UserID   uuid.UUID `json:"user_id"`
+	ClientInfo
+	RedirectURI   string    `json:"redirect_uri"`
+	Scope         string    `json:"scope"`
+	CodeChallenge string    `json:"code_challenge"`
+	IssuedAt      time.Time `json:"issued_at"`
+	ExpiredAt     time.Time `json:"expired_at"`
+}
+
+// OAuthConsent is what a user allowed a client to access. Each consent adds
+// to Scopes.
+type OAuthConsent struct {
+	UserID    uuid.UUID `json:"user_id"`
+	ClientID  uuid.UUID `json:"client_id"`
+	Scopes    []string  `json:"scopes"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// OAuthConsentChallenge is an authorization request waiting for the user to
+// allow or deny it. Once allowed, AuthorizationCode is issued and sent to
+// RedirectURI along with State.
+type OAuthConsentChallenge struct {
+	AuthorizationCode OAuthAuthorizationCode `json:"authorization_code"`
+	RedirectURI       string                 `json:"redirect_uri"`
+	State             string                 `json:"state"`
+	IssuedAt          time.Time              `json:"issued_at"`
+	ExpiredAt         time.Time              `json:"expired_at"`
+}
@@ -0,0 +1,16 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// PasswordReset is a pending password reset. PasswordFingerprint is derived
+// from the password hash of the user when the reset was requested so that the
+// reset stops working once the password changes.
+type PasswordReset struct {
+	UserID              uuid.UUID `json:"user_id"`
+	PasswordFingerprint string    `json:"password_fingerprint"`
+	IssuedAt            time.Time `json:"issued_at"`
+	ExpiredAt           time.Time `json:"expired_at"`
+}
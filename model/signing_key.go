@@ -0,0 +1,34 @@
+package model
+
+import (
+	"crypto"
+	"time"
+)
+
+const (
+	SigningKeyStatusNext     = "next"
+	SigningKeyStatusActive   = "active"
+	SigningKeyStatusPrevious = "previous"
+	SigningKeyStatusRetired  = "retired"
+)
+
+type SigningKey struct {
+	KID         string
+	Algorithm   string
+	PrivateKey  crypto.Signer
+	Status      string
+	CreatedAt   time.Time
+	ActivatedAt *time.Time
+	RetireAt    *time.Time
+}
+
+type JSONWebKey struct {
+	KeyType   string `json:"kty"`
+	KeyID     string `json:"kid"`
+	Use       string `json:"use"`
+	Algorithm string `json:"alg"`
+	Curve     string `json:"crv,omitempty"`
+	X         string `json:"x,omitempty"`
+	N         string `json:"n,omitempty"`
+	E         string `json:"e,omitempty"`
+}
@@ -6,7 +6,28 @@ import (
 )
 
 type Token struct {
-	UserID    uuid.UUID `json:"user_id"`
-	IssuedAt  time.Time `json:"issued_at"`
-	ExpiredAt time.Time `json:"expired_at"`
+	UserID   uuid.UUID `json:"user_id"`
+	FamilyID uuid.UUID `json:"family_id"`
+	ClientInfo
+	IssuedAt     time.Time `json:"issued_at"`
+	LastUsedAt   time.Time `json:"last_used_at"`
+	ExpiredAt    time.Time `json:"expired_at"`
+	MaxExpiredAt time.Time `json:"max_expired_at"`
+
+	// EmailVerified is the verification state of the user when the token was
+	// issued. Restricted tokens were handed out to unverified users.
+	EmailVerified bool `json:"email_verified"`
+	Restricted    bool `json:"restricted"`
+
+	// ClientID is the OAuth client the token was issued to and Scope what it
+	// was granted, both empty for tokens handed out by Login. Tokens a client
+	// obtained for itself have no UserID.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+type ClientInfo struct {
+	IP        string `json:"client_ip"`
+	UserAgent string `json:"user_agent"`
+	Device    string `json:"device"`
 }
@@ -0,0 +1,37 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+type TokenFamily struct {
+	ID              uuid.UUID `json:"id"`
+	UserID          uuid.UUID `json:"user_id"`
+	RefreshTokenKey string    `json:"refresh_token_key"`
+	ClientInfo
+	IssuedAt   time.Time `json:"issued_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiredAt  time.Time `json:"expired_at"`
+
+	// ElevatedUntil is when the last step-up of the session stops counting.
+	ElevatedUntil *time.Time `json:"elevated_until,omitempty"`
+
+	// ClientID is the OAuth client the session was granted to. Only that
+	// client can refresh it.
+	ClientID string `json:"client_id,omitempty"`
+	Scope    string `json:"scope,omitempty"`
+}
+
+// Elevated reports whether the session was stepped up recently enough to
+// perform sensitive actions.
+func (f TokenFamily) Elevated() bool {
+	return f.ElevatedUntil != nil && time.Now().Before(*f.ElevatedUntil)
+}
+
+type RefreshToken struct {
+	FamilyID  uuid.UUID `json:"family_id"`
+	UserID    uuid.UUID `json:"user_id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
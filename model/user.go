@@ -10,15 +10,19 @@ type User struct {
 	FirstName string    `json:"first_name"`
 	LastName  string    `json:"last_name"`
 	Email     string    `json:"email"`
+	// Password is the password hash, empty for passwordless accounts.
 	Password  string    `json:"password"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+
+	PasswordChangedAt *time.Time `json:"password_changed_at"`
+	EmailVerifiedAt   *time.Time `json:"email_verified_at"`
 }
 
 type scanFunc func(dest ...interface{}) error
 
 func ScanToUser(f scanFunc) (User, error) {
 	u := User{}
-	err := f(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.CreatedAt, &u.UpdatedAt)
+	err := f(&u.ID, &u.FirstName, &u.LastName, &u.Email, &u.Password, &u.CreatedAt, &u.UpdatedAt, &u.PasswordChangedAt, &u.EmailVerifiedAt)
 	return u, err
 }
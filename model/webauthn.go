@@ -0,0 +1,28 @@
+package model
+
+import (
+	"github.com/google/uuid"
+	"time"
+)
+
+// WebAuthnCredential is a passkey of a user. SignCount is the last counter
+// the authenticator reported; once it fails to increase the credential may
+// have been cloned, CloneDetectedAt is set and it is no longer accepted.
+type WebAuthnCredential struct {
+	ID              uuid.UUID  `json:"id"`
+	UserID          uuid.UUID  `json:"user_id"`
+	CredentialID    []byte     `json:"credential_id"`
+	PublicKey       []byte     `json:"public_key"`
+	SignCount       int64      `json:"sign_count"`
+	AAGUID          []byte     `json:"aaguid"`
+	Transports      []string   `json:"transports"`
+	Name            string     `json:"name"`
+	CloneDetectedAt *time.Time `json:"clone_detected_at"`
+	LastUsedAt      *time.Time `json:"last_used_at"`
+	CreatedAt       time.Time  `json:"created_at"`
+}
+
+// Active reports whether the passkey is still accepted.
+func (c WebAuthnCredential) Active() bool {
+	return c.CloneDetectedAt == nil
+}
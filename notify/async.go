@@ -0,0 +1,94 @@
+package notify
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"time"
+)
+
+type asyncNotifier struct {
+	renderer *renderer
+	sender   SenderInterface
+	queue    chan Email
+
+	maxAttempts  int
+	retryBackoff time.Duration
+}
+
+func newAsyncNotifier(ctx context.Context, renderer *renderer, sender SenderInterface, config Config) NotifierInterface {
+	a := &asyncNotifier{
+		renderer:     renderer,
+		sender:       sender,
+		queue:        make(chan Email, atLeastOne(config.QueueSize)),
+		maxAttempts:  atLeastOne(config.MaxAttempts),
+		retryBackoff: config.RetryBackoff,
+	}
+
+	for i := 0; i < atLeastOne(config.Workers); i++ {
+		go a.work(ctx)
+	}
+
+	return a
+}
+
+// Notify renders message right away so template errors reach the caller,
+// and leaves the delivery to the workers.
+func (a *asyncNotifier) Notify(ctx context.Context, message Message) error {
+	email, err := a.renderer.render(message)
+	if err != nil {
+		return err
+	}
+
+	select {
+	case a.queue <- email:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+func (a *asyncNotifier) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case email := <-a.queue:
+			a.deliver(ctx, email)
+		}
+	}
+}
+
+// deliver sends email, retrying with exponential backoff.
+func (a *asyncNotifier) deliver(ctx context.Context, email Email) {
+	backoff := a.retryBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := a.sender.Send(ctx, email)
+		if err == nil {
+			return
+		}
+
+		if attempt >= a.maxAttempts {
+			log.WithError(err).Errorf(ctx, "giving up on %s notification after %d attempts", email.Kind, attempt)
+			return
+		}
+
+		log.WithError(err).Warnf(ctx, "error in send %s notification, attempt %d", email.Kind, attempt)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+	}
+}
+
+func atLeastOne(n int) int {
+	if n < 1 {
+		return 1
+	}
+
+	return n
+}
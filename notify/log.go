@@ -0,0 +1,21 @@
+package notify
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_shared/go/log"
+)
+
+type logSender struct{}
+
+// NewLogSender returns a sender that only writes emails to the log. Emails
+// may carry secrets such as reset links, so it is meant for local development
+// only.
+func NewLogSender() SenderInterface {
+	return &logSender{}
+}
+
+func (l *logSender) Send(ctx context.Context, email Email) error {
+	log.Infof(ctx, "notification %s to %s: %s\n%s", email.Kind, email.To, email.Subject, email.Body)
+
+	return nil
+}
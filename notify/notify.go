@@ -0,0 +1,99 @@
+package notify
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const (
+	BackendLog    = "log"
+	BackendSMTP   = "smtp"
+	BackendOutbox = "outbox"
+)
+
+const (
+	KindPasswordReset     = "password_reset"
+	KindPasswordChanged   = "password_changed"
+	KindEmailVerification = "email_verification"
+	KindEmailOTP          = "email_otp"
+	KindMagicLink         = "magic_link"
+)
+
+var (
+	ErrUnsupportedBackend = errors.New("unsupported notification backend")
+	ErrTemplateNotFound   = errors.New("notification template could not be found")
+	ErrQueueFull          = errors.New("notification queue is full")
+)
+
+// Message is a notification for a single recipient. Data holds the values
+// the template of the given Kind is rendered with, in the language given by
+// Locale when there is a translation for it.
+type Message struct {
+	Kind   string
+	To     string
+	Locale string
+	Data   map[string]string
+}
+
+// Email is a rendered Message.
+type Email struct {
+	Kind    string `json:"kind"`
+	To      string `json:"to"`
+	Locale  string `json:"locale"`
+	Subject string `json:"subject"`
+	Body    string `json:"body"`
+}
+
+type NotifierInterface interface {
+	// Notify queues message for delivery and returns without waiting for
+	// it. Delivery failures are retried and logged.
+	Notify(ctx context.Context, message Message) error
+}
+
+// SenderInterface delivers rendered emails through one backend.
+type SenderInterface interface {
+	Send(ctx context.Context, email Email) error
+}
+
+type Config struct {
+	Backend       string
+	DefaultLocale string
+
+	SMTP      SMTPConfig
+	OutboxDir string
+
+	Workers      int
+	QueueSize    int
+	MaxAttempts  int
+	RetryBackoff time.Duration
+}
+
+// NewNotifier returns a notifier delivering through the configured backend.
+// Its workers stop once ctx is done.
+func NewNotifier(ctx context.Context, config Config) (NotifierInterface, error) {
+	var sender SenderInterface
+
+	switch config.Backend {
+	case BackendLog:
+		sender = NewLogSender()
+	case BackendSMTP:
+		sender = NewSMTPSender(config.SMTP)
+	case BackendOutbox:
+		outboxSender, err := NewOutboxSender(config.OutboxDir)
+		if err != nil {
+			return nil, err
+		}
+
+		sender = outboxSender
+	default:
+		return nil, ErrUnsupportedBackend
+	}
+
+	renderer, err := newRenderer(config.DefaultLocale)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAsyncNotifier(ctx, renderer, sender, config), nil
+}
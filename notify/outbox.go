@@ -0,0 +1,58 @@
+package notify
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+type outboxSender struct {
+	directory string
+}
+
+// NewOutboxSender returns a sender that writes every email as a JSON file
+// into directory instead of delivering it, for development and tests.
+func NewOutboxSender(directory string) (SenderInterface, error) {
+	if err := os.MkdirAll(directory, 0o700); err != nil {
+		return nil, err
+	}
+
+	return &outboxSender{
+		directory: directory,
+	}, nil
+}
+
+type outboxEntry struct {
+	Email
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func (o *outboxSender) Send(ctx context.Context, email Email) error {
+	data, err := json.MarshalIndent(outboxEntry{
+		Email:     email,
+		CreatedAt: time.Now(),
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	suffix := make([]byte, 4)
+	if _, err = rand.Read(suffix); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%s-%s-%s.json", time.Now().UTC().Format("20060102T150405.000000000"), email.Kind, hex.EncodeToString(suffix))
+
+	// Write then rename so readers never see a partial file.
+	tmpPath := filepath.Join(o.directory, "."+name)
+	if err = os.WriteFile(tmpPath, data, 0o600); err != nil {
+		return err
+	}
+
+	return os.Rename(tmpPath, filepath.Join(o.directory, name))
+}
@@ -0,0 +1,68 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+type SMTPConfig struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	From     string
+}
+
+type smtpSender struct {
+	config SMTPConfig
+}
+
+// NewSMTPSender returns a sender delivering through an SMTP relay. The
+// connection is upgraded with STARTTLS whenever the server offers it, and
+// credentials are only sent when a username is configured.
+func NewSMTPSender(config SMTPConfig) SenderInterface {
+	return &smtpSender{
+		config: config,
+	}
+}
+
+func (s *smtpSender) Send(ctx context.Context, email Email) error {
+	var auth smtp.Auth
+	if s.config.Username != "" {
+		auth = smtp.PlainAuth("", s.config.Username, s.config.Password, s.config.Host)
+	}
+
+	return smtp.SendMail(
+		net.JoinHostPort(s.config.Host, s.config.Port),
+		auth,
+		s.config.From,
+		[]string{email.To},
+		s.buildMessage(email),
+	)
+}
+
+func (s *smtpSender) buildMessage(email Email) []byte {
+	var message strings.Builder
+
+	fmt.Fprintf(&message, "From: %s\r\n", headerValue(s.config.From))
+	fmt.Fprintf(&message, "To: %s\r\n", headerValue(email.To))
+	fmt.Fprintf(&message, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", email.Subject))
+	fmt.Fprintf(&message, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	message.WriteString("MIME-Version: 1.0\r\n")
+	message.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	message.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	message.WriteString("\r\n")
+	message.WriteString(strings.ReplaceAll(email.Body, "\n", "\r\n"))
+
+	return []byte(message.String())
+}
+
+// headerValue drops line breaks so that a value cannot inject headers.
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
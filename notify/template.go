@@ -0,0 +1,100 @@
+package notify
+
+import (
+	"bytes"
+	"embed"
+	"io/fs"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// Templates live in templates/<locale>/<kind>.tmpl and define a "subject"
+// and a "body" template, both rendered with the Data of the message.
+//
+//go:embed templates
+var templateFiles embed.FS
+
+type renderer struct {
+	defaultLocale string
+	templates     map[string]map[string]*template.Template
+}
+
+func newRenderer(defaultLocale string) (*renderer, error) {
+	r := &renderer{
+		defaultLocale: defaultLocale,
+		templates:     make(map[string]map[string]*template.Template),
+	}
+
+	paths, err := fs.Glob(templateFiles, "templates/*/*.tmpl")
+	if err != nil {
+		return nil, err
+	}
+
+	for _, templatePath := range paths {
+		locale := path.Base(path.Dir(templatePath))
+		kind := strings.TrimSuffix(path.Base(templatePath), ".tmpl")
+
+		tmpl, err := template.New(kind).Option("missingkey=zero").ParseFS(templateFiles, templatePath)
+		if err != nil {
+			return nil, err
+		}
+
+		if r.templates[locale] == nil {
+			r.templates[locale] = make(map[string]*template.Template)
+		}
+
+		r.templates[locale][kind] = tmpl
+	}
+
+	if _, ok := r.templates[defaultLocale]; !ok {
+		return nil, ErrTemplateNotFound
+	}
+
+	return r, nil
+}
+
+func (r *renderer) render(message Message) (Email, error) {
+	locale, tmpl := r.lookup(message.Kind, message.Locale)
+	if tmpl == nil {
+		return Email{}, ErrTemplateNotFound
+	}
+
+	var subject, body bytes.Buffer
+
+	if err := tmpl.ExecuteTemplate(&subject, "subject", message.Data); err != nil {
+		return Email{}, err
+	}
+
+	if err := tmpl.ExecuteTemplate(&body, "body", message.Data); err != nil {
+		return Email{}, err
+	}
+
+	return Email{
+		Kind:    message.Kind,
+		To:      message.To,
+		Locale:  locale,
+		Subject: strings.TrimSpace(subject.String()),
+		Body:    strings.TrimSpace(body.String()) + "\n",
+	}, nil
+}
+
+// lookup finds the template of kind for locale, falling back from a regional
+// locale such as fa-IR to fa and then to the default locale.
+func (r *renderer) lookup(kind string, locale string) (string, *template.Template) {
+	locale = strings.ToLower(strings.ReplaceAll(locale, "_", "-"))
+
+	candidates := []string{locale}
+	if language, _, ok := strings.Cut(locale, "-"); ok {
+		candidates = append(candidates, language)
+	}
+	candidates = append(candidates, r.defaultLocale)
+
+	for _, candidate := range candidates {
+		if tmpl, ok := r.templates[candidate][kind]; ok {
+			return candidate, tmpl
+		}
+	}
+
+	return "", nil
+}
@@ -0,0 +1,143 @@
+package oauth
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"strings"
+)
+
+const (
+	ResponseTypeCode        = "code"
+	CodeChallengeMethodS256 = "S256"
+	TokenTypeBearer         = "Bearer"
+
+	ConsentAllow = "allow"
+	ConsentDeny  = "deny"
+)
+
+// Error codes of RFC 6749, and login_required and consent_required of
+// OpenID Connect for authorization requests the user has yet to sign in to
+// or allow.
+const (
+	ErrorInvalidRequest          = "invalid_request"
+	ErrorInvalidClient           = "invalid_client"
+	ErrorInvalidGrant            = "invalid_grant"
+	ErrorUnauthorizedClient      = "unauthorized_client"
+	ErrorUnsupportedGrantType    = "unsupported_grant_type"
+	ErrorUnsupportedResponseType = "unsupported_response_type"
+	ErrorInvalidScope            = "invalid_scope"
+	ErrorAccessDenied            = "access_denied"
+	ErrorTemporarilyUnavailable  = "temporarily_unavailable"
+	ErrorLoginRequired           = "login_required"
+	ErrorConsentRequired         = "consent_required"
+	ErrorServerError             = "server_error"
+)
+
+const (
+	minCodeVerifierLength = 43
+	maxCodeVerifierLength = 128
+	codeChallengeLength   = 43
+)
+
+// Error is an error reported to OAuth clients.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description,omitempty"`
+}
+
+func NewError(code string, description string) *Error {
+	return &Error{Code: code, Description: description}
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return e.Code
+	}
+
+	return e.Code + ": " + e.Description
+}
+
+// IsCodeChallenge reports whether challenge looks like an S256 challenge,
+// the unpadded base64url encoding of a SHA-256 hash.
+func IsCodeChallenge(challenge string) bool {
+	if len(challenge) != codeChallengeLength {
+		return false
+	}
+
+	_, err := base64.RawURLEncoding.DecodeString(challenge)
+
+	return err == nil
+}
+
+// VerifyCodeChallenge reports whether verifier is the PKCE code verifier the
+// S256 challenge was derived from.
+func VerifyCodeChallenge(challenge string, verifier string) bool {
+	if len(verifier) < minCodeVerifierLength || len(verifier) > maxCodeVerifierLength {
+		return false
+	}
+
+	for _, r := range verifier {
+		if !isUnreserved(r) {
+			return false
+		}
+	}
+
+	hashedVerifier := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(hashedVerifier[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
+
+func isUnreserved(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	default:
+		return strings.ContainsRune("-._~", r)
+	}
+}
+
+// ParseScope splits a space separated scope into its distinct values.
+func ParseScope(scope string) []string {
+	var scopes []string
+
+	for _, value := range strings.Fields(scope) {
+		if !contains(scopes, value) {
+			scopes = append(scopes, value)
+		}
+	}
+
+	return scopes
+}
+
+// JoinScope is the space separated form of scopes.
+func JoinScope(scopes []string) string {
+	return strings.Join(scopes, " ")
+}
+
+// NarrowScope returns the scopes of requested, or all of allowed when none
+// were requested. ok is false when something beyond allowed was requested.
+func NarrowScope(requested []string, allowed []string) (scopes []string, ok bool) {
+	if len(requested) == 0 {
+		return allowed, true
+	}
+
+	for _, scope := range requested {
+		if !contains(allowed, scope) {
+			return nil, false
+		}
+	}
+
+	return requested, true
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,46 @@
+package oauth
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestVerifyCodeChallenge(t *testing.T) {
+	const (
+		verifier  = "dBjftJeZ4CVP-mB92K9uhvHBFLMV3jTtQYV-ZNcCmd8"
+		challenge = "IL9y6TAuwJ7iPiUyeAWmbQbrpN86-R41oVG0o2r46D0"
+	)
+
+	for _, test := range []struct {
+		name      string
+		challenge string
+		verifier  string
+		want      bool
+	}{
+		{name: "matching verifier", challenge: challenge, verifier: verifier, want: true},
+		{name: "other verifier", challenge: challenge, verifier: verifier[1:] + "x", want: false},
+		{name: "challenge as verifier", challenge: challenge, verifier: challenge, want: false},
+		{name: "too short", challenge: challenge, verifier: verifier[:minCodeVerifierLength-1], want: false},
+		{name: "too long", challenge: challenge, verifier: strings.Repeat("a", maxCodeVerifierLength+1), want: false},
+		{name: "reserved characters", challenge: challenge, verifier: verifier[:42] + "/", want: false},
+		{name: "empty challenge", challenge: "", verifier: verifier, want: false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			if got := VerifyCodeChallenge(test.challenge, test.verifier); got != test.want {
+				t.Fatalf("got %t, want %t", got, test.want)
+			}
+		})
+	}
+}
+
+func TestIsCodeChallenge(t *testing.T) {
+	if !IsCodeChallenge("IL9y6TAuwJ7iPiUyeAWmbQbrpN86-R41oVG0o2r46D0") {
+		t.Fatal("rejected an S256 challenge")
+	}
+
+	for _, challenge := range []string{"", "plain-verifier", "IL9y6TAuwJ7iPiUyeAWmbQbrpN86+R41oVG0o2r46D0"} {
+		if IsCodeChallenge(challenge) {
+			t.Fatalf("accepted %q", challenge)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package password
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"strings"
+)
+
+const (
+	argon2idSaltLength = 16
+	argon2idKeyLength  = 32
+)
+
+type Argon2idParams struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+func (p Argon2idParams) validate() error {
+	if p.Memory < 8*uint32(p.Parallelism) || p.Iterations == 0 || p.Parallelism == 0 {
+		return ErrInvalidParams
+	}
+
+	return nil
+}
+
+type argon2idAlgorithm struct {
+	params Argon2idParams
+}
+
+func newArgon2id(params Argon2idParams) algorithmInterface {
+	return &argon2idAlgorithm{params: params}
+}
+
+func (a *argon2idAlgorithm) hash(password string) (string, error) {
+	salt := make([]byte, argon2idSaltLength)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+
+	key := argon2.IDKey([]byte(password), salt, a.params.Iterations, a.params.Memory, a.params.Parallelism, argon2idKeyLength)
+
+	return fmt.Sprintf(
+		"$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version,
+		a.params.Memory,
+		a.params.Iterations,
+		a.params.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key),
+	), nil
+}
+
+func (a *argon2idAlgorithm) verify(password string, encodedHash string) (bool, error) {
+	params, salt, key, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return false, err
+	}
+
+	otherKey := argon2.IDKey([]byte(password), salt, params.Iterations, params.Memory, params.Parallelism, uint32(len(key)))
+
+	return subtle.ConstantTimeCompare(key, otherKey) == 1, nil
+}
+
+func (a *argon2idAlgorithm) needsRehash(encodedHash string) bool {
+	params, salt, key, err := decodeArgon2id(encodedHash)
+	if err != nil {
+		return true
+	}
+
+	return params != a.params || len(salt) != argon2idSaltLength || len(key) != argon2idKeyLength
+}
+
+// decodeArgon2id parses a PHC string such as
+// $argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>.
+func decodeArgon2id(encodedHash string) (params Argon2idParams, salt []byte, key []byte, err error) {
+	parts := strings.Split(encodedHash, "$")
+	if len(parts) != 6 || parts[1] != AlgorithmArgon2id {
+		return Argon2idParams{}, nil, nil, ErrInvalidHash
+	}
+
+	var version int
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+		return Argon2idParams{}, nil, nil, ErrInvalidHash
+	}
+
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &params.Memory, &params.Iterations, &params.Parallelism); err != nil {
+		return Argon2idParams{}, nil, nil, ErrInvalidHash
+	}
+
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return Argon2idParams{}, nil, nil, ErrInvalidHash
+	}
+
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil || len(key) == 0 {
+		return Argon2idParams{}, nil, nil, ErrInvalidHash
+	}
+
+	return params, salt, key, nil
+}
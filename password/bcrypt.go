@@ -0,0 +1,55 @@
+package password
+
+import (
+	"golang.org/x/crypto/bcrypt"
+)
+
+type BcryptParams struct {
+	Cost int
+}
+
+func (p BcryptParams) validate() error {
+	if p.Cost < bcrypt.MinCost || p.Cost > bcrypt.MaxCost {
+		return ErrInvalidParams
+	}
+
+	return nil
+}
+
+type bcryptAlgorithm struct {
+	params BcryptParams
+}
+
+func newBcrypt(params BcryptParams) algorithmInterface {
+	return &bcryptAlgorithm{params: params}
+}
+
+func (b *bcryptAlgorithm) hash(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), b.params.Cost)
+	if err != nil {
+		return "", err
+	}
+
+	return string(hashedPassword), nil
+}
+
+func (b *bcryptAlgorithm) verify(password string, encodedHash string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword([]byte(encodedHash), []byte(password))
+	switch err {
+	case nil:
+		return true, nil
+	case bcrypt.ErrMismatchedHashAndPassword:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+func (b *bcryptAlgorithm) needsRehash(encodedHash string) bool {
+	cost, err := bcrypt.Cost([]byte(encodedHash))
+	if err != nil {
+		return true
+	}
+
+	return cost != b.params.Cost
+}
@@ -0,0 +1,129 @@
+package password
+
+import (
+	"crypto/rand"
+	"errors"
+	"strings"
+)
+
+const (
+	AlgorithmArgon2id = "argon2id"
+	AlgorithmBcrypt   = "bcrypt"
+)
+
+var (
+	ErrUnsupportedAlgorithm = errors.New("unsupported password hash algorithm")
+	ErrInvalidHash          = errors.New("the encoded password hash is invalid")
+	ErrInvalidParams        = errors.New("invalid password hash parameters")
+)
+
+type HasherInterface interface {
+	// Hash returns the encoded hash of password made with the configured
+	// algorithm and parameters.
+	Hash(password string) (string, error)
+	// Verify reports whether password matches encodedHash. Hashes made with
+	// any supported algorithm are accepted, not only the configured one.
+	Verify(password string, encodedHash string) (bool, error)
+	// NeedsRehash reports whether encodedHash was made with another
+	// algorithm or with outdated parameters.
+	NeedsRehash(encodedHash string) bool
+	// DummyHash returns a hash of a random password made with the configured
+	// algorithm and parameters. Verifying against it takes as long as
+	// verifying against a real hash, which hides whether a user exists.
+	DummyHash() string
+}
+
+type algorithmInterface interface {
+	hash(password string) (string, error)
+	verify(password string, encodedHash string) (bool, error)
+	needsRehash(encodedHash string) bool
+}
+
+type Config struct {
+	Algorithm string
+	Argon2id  Argon2idParams
+	Bcrypt    BcryptParams
+}
+
+type hasher struct {
+	current    algorithmInterface
+	algorithms map[string]algorithmInterface
+	dummyHash  string
+}
+
+func NewHasher(config Config) (HasherInterface, error) {
+	if err := config.Argon2id.validate(); err != nil {
+		return nil, err
+	}
+
+	if err := config.Bcrypt.validate(); err != nil {
+		return nil, err
+	}
+
+	algorithms := map[string]algorithmInterface{
+		AlgorithmArgon2id: newArgon2id(config.Argon2id),
+		AlgorithmBcrypt:   newBcrypt(config.Bcrypt),
+	}
+
+	current, ok := algorithms[config.Algorithm]
+	if !ok {
+		return nil, ErrUnsupportedAlgorithm
+	}
+
+	dummyPassword := make([]byte, 32)
+	if _, err := rand.Read(dummyPassword); err != nil {
+		return nil, err
+	}
+
+	dummyHash, err := current.hash(string(dummyPassword))
+	if err != nil {
+		return nil, err
+	}
+
+	return &hasher{
+		current:    current,
+		algorithms: algorithms,
+		dummyHash:  dummyHash,
+	}, nil
+}
+
+func (h *hasher) Hash(password string) (string, error) {
+	return h.current.hash(password)
+}
+
+func (h *hasher) Verify(password string, encodedHash string) (bool, error) {
+	algorithm, ok := h.algorithms[identify(encodedHash)]
+	if !ok {
+		return false, ErrUnsupportedAlgorithm
+	}
+
+	return algorithm.verify(password, encodedHash)
+}
+
+func (h *hasher) DummyHash() string {
+	return h.dummyHash
+}
+
+func (h *hasher) NeedsRehash(encodedHash string) bool {
+	if h.algorithms[identify(encodedHash)] != h.current {
+		return true
+	}
+
+	return h.current.needsRehash(encodedHash)
+}
+
+// identify returns the algorithm an encoded hash was made with. Argon2id
+// hashes use the PHC string format ($argon2id$...), bcrypt hashes keep their
+// own $2a$/$2b$/$2y$ prefixes which predate it.
+func identify(encodedHash string) string {
+	switch {
+	case strings.HasPrefix(encodedHash, "$argon2id$"):
+		return AlgorithmArgon2id
+	case strings.HasPrefix(encodedHash, "$2a$"),
+		strings.HasPrefix(encodedHash, "$2b$"),
+		strings.HasPrefix(encodedHash, "$2y$"):
+		return AlgorithmBcrypt
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,177 @@
+package password
+
+import (
+	_ "embed"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	RuleMinLength    = "min_length"
+	RuleMaxLength    = "max_length"
+	RuleLowercase    = "lowercase"
+	RuleUppercase    = "uppercase"
+	RuleDigit        = "digit"
+	RuleSymbol       = "symbol"
+	RulePersonalInfo = "personal_info"
+	RuleCommon       = "common_password"
+	RuleBreached     = "breached"
+)
+
+// minPersonalInfoLength keeps short names such as "Al" from ruling out every
+// password that happens to contain them.
+const minPersonalInfoLength = 3
+
+// commonPasswords lists the most common leaked passwords, most frequent first.
+//
+//go:embed common_passwords.txt
+var commonPasswords string
+
+type PolicyInterface interface {
+	// Check returns every rule password violates, or nil when it satisfies
+	// the policy. personalInfo holds the names and email of the user the
+	// password belongs to.
+	Check(password string, personalInfo ...string) []Violation
+}
+
+type Violation struct {
+	Rule        string
+	Description string
+}
+
+type PolicyConfig struct {
+	MinLength            int
+	MaxLength            int
+	RequireLowercase     bool
+	RequireUppercase     bool
+	RequireDigit         bool
+	RequireSymbol        bool
+	DisallowPersonalInfo bool
+	DisallowCommon       bool
+	// CommonPasswordLimit only checks the top N common passwords, zero checks
+	// the whole list.
+	CommonPasswordLimit int
+}
+
+type policy struct {
+	config          PolicyConfig
+	commonPasswords map[string]struct{}
+}
+
+func NewPolicy(config PolicyConfig) PolicyInterface {
+	p := &policy{
+		config:          config,
+		commonPasswords: make(map[string]struct{}),
+	}
+
+	if config.DisallowCommon {
+		for i, commonPassword := range strings.Fields(commonPasswords) {
+			if config.CommonPasswordLimit > 0 && i >= config.CommonPasswordLimit {
+				break
+			}
+
+			p.commonPasswords[commonPassword] = struct{}{}
+		}
+	}
+
+	return p
+}
+
+func (p *policy) Check(password string, personalInfo ...string) (violations []Violation) {
+	length := utf8.RuneCountInString(password)
+
+	if length < p.config.MinLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMinLength,
+			Description: fmt.Sprintf("password must be at least %d characters long", p.config.MinLength),
+		})
+	}
+
+	if p.config.MaxLength > 0 && length > p.config.MaxLength {
+		violations = append(violations, Violation{
+			Rule:        RuleMaxLength,
+			Description: fmt.Sprintf("password must be at most %d characters long", p.config.MaxLength),
+		})
+	}
+
+	var hasLowercase, hasUppercase, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			hasLowercase = true
+		case unicode.IsUpper(r):
+			hasUppercase = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case unicode.IsPunct(r) || unicode.IsSymbol(r) || unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+
+	if p.config.RequireLowercase && !hasLowercase {
+		violations = append(violations, Violation{
+			Rule:        RuleLowercase,
+			Description: "password must contain a lowercase letter",
+		})
+	}
+
+	if p.config.RequireUppercase && !hasUppercase {
+		violations = append(violations, Violation{
+			Rule:        RuleUppercase,
+			Description: "password must contain an uppercase letter",
+		})
+	}
+
+	if p.config.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{
+			Rule:        RuleDigit,
+			Description: "password must contain a digit",
+		})
+	}
+
+	if p.config.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{
+			Rule:        RuleSymbol,
+			Description: "password must contain a symbol",
+		})
+	}
+
+	normalizedPassword := strings.ToLower(password)
+
+	if p.config.DisallowPersonalInfo && containsPersonalInfo(normalizedPassword, personalInfo) {
+		violations = append(violations, Violation{
+			Rule:        RulePersonalInfo,
+			Description: "password must not contain your name or email",
+		})
+	}
+
+	if _, ok := p.commonPasswords[normalizedPassword]; ok {
+		violations = append(violations, Violation{
+			Rule:        RuleCommon,
+			Description: "password is too common",
+		})
+	}
+
+	return violations
+}
+
+func containsPersonalInfo(normalizedPassword string, personalInfo []string) bool {
+	for _, info := range personalInfo {
+		info = strings.ToLower(strings.TrimSpace(info))
+
+		candidates := []string{info}
+		if localPart, _, ok := strings.Cut(info, "@"); ok {
+			candidates = append(candidates, localPart)
+		}
+
+		for _, candidate := range candidates {
+			if utf8.RuneCountInString(candidate) >= minPersonalInfoLength && strings.Contains(normalizedPassword, candidate) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,77 @@
+package pwned
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// rangePrefixLength is the number of hex characters of the hash that name a
+// range file, e.g. 21BD1.txt holding lines such as
+// 0018A45C4D1DEF81644B54AB7F969B88D65:10.
+const rangePrefixLength = 5
+
+type directoryChecker struct {
+	directory string
+}
+
+func NewDirectoryChecker(directory string) CheckerInterface {
+	return &directoryChecker{
+		directory: directory,
+	}
+}
+
+func (d *directoryChecker) Count(ctx context.Context, password string) (uint32, error) {
+	hash := hashPassword(password)
+
+	file, err := os.Open(filepath.Join(d.directory, hash[:rangePrefixLength]+".txt"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return 0, nil
+		}
+
+		return 0, err
+	}
+
+	defer file.Close()
+
+	suffix := hash[rangePrefixLength:]
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		lineSuffix, count, err := parseRangeLine(scanner.Text())
+		if err != nil {
+			return 0, err
+		}
+
+		if lineSuffix == suffix {
+			return count, nil
+		}
+	}
+
+	return 0, scanner.Err()
+}
+
+func (d *directoryChecker) Close() error {
+	return nil
+}
+
+// parseRangeLine splits a "<hash>:<count>" line. Lines without a count, as
+// in some older dumps, count once.
+func parseRangeLine(line string) (hash string, count uint32, err error) {
+	hash, rawCount, ok := strings.Cut(strings.TrimSpace(line), ":")
+	if !ok {
+		return strings.ToUpper(hash), 1, nil
+	}
+
+	parsedCount, err := strconv.ParseUint(rawCount, 10, 32)
+	if err != nil {
+		return "", 0, ErrInvalidEntry
+	}
+
+	return strings.ToUpper(hash), uint32(parsedCount), nil
+}
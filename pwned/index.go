@@ -0,0 +1,289 @@
+package pwned
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// An index file is laid out as
+//
+//	magic | bucket offsets | entries
+//
+// where the 65537 big-endian uint64 bucket offsets give, for every value of
+// the first two bytes of the hash, the position of its first entry. Each
+// entry holds the next eight bytes of the hash followed by the breach count
+// as a big-endian uint32. Entries are sorted within their bucket, so a lookup
+// reads two offsets and binary searches one bucket. Keeping 80 of the 160
+// bits of every hash makes the index roughly a quarter of the size of the
+// text dump while false positives stay negligible.
+const (
+	indexMagic       = "LPWNIDX1"
+	indexBucketCount = 1 << 16
+	indexHashLength  = 8
+	indexEntryLength = indexHashLength + 4
+	indexTableLength = (indexBucketCount + 1) * 8
+)
+
+type indexChecker struct {
+	file       *os.File
+	entryCount uint64
+}
+
+func NewIndexChecker(path string) (CheckerInterface, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	magic := make([]byte, len(indexMagic))
+	if _, err = file.ReadAt(magic, 0); err != nil || string(magic) != indexMagic {
+		file.Close()
+		return nil, ErrInvalidIndex
+	}
+
+	entriesLength := info.Size() - int64(len(indexMagic)+indexTableLength)
+	if entriesLength < 0 || entriesLength%indexEntryLength != 0 {
+		file.Close()
+		return nil, ErrInvalidIndex
+	}
+
+	return &indexChecker{
+		file:       file,
+		entryCount: uint64(entriesLength / indexEntryLength),
+	}, nil
+}
+
+func (i *indexChecker) Count(ctx context.Context, password string) (uint32, error) {
+	hash, err := hex.DecodeString(hashPassword(password))
+	if err != nil {
+		return 0, err
+	}
+
+	bucket := int64(binary.BigEndian.Uint16(hash[:2]))
+
+	offsets := make([]byte, 16)
+	if _, err = i.file.ReadAt(offsets, int64(len(indexMagic))+bucket*8); err != nil {
+		return 0, err
+	}
+
+	start := binary.BigEndian.Uint64(offsets[:8])
+	end := binary.BigEndian.Uint64(offsets[8:])
+	if start > end || end > i.entryCount {
+		return 0, ErrInvalidIndex
+	}
+
+	target := hash[2 : 2+indexHashLength]
+	entry := make([]byte, indexEntryLength)
+
+	var readErr error
+	position := sort.Search(int(end-start), func(n int) bool {
+		if readErr != nil {
+			return true
+		}
+
+		if _, readErr = i.file.ReadAt(entry, i.entryOffset(start+uint64(n))); readErr != nil {
+			return true
+		}
+
+		return bytes.Compare(entry[:indexHashLength], target) >= 0
+	})
+	if readErr != nil {
+		return 0, readErr
+	}
+
+	if uint64(position) == end-start {
+		return 0, nil
+	}
+
+	if _, err = i.file.ReadAt(entry, i.entryOffset(start+uint64(position))); err != nil {
+		return 0, err
+	}
+
+	if !bytes.Equal(entry[:indexHashLength], target) {
+		return 0, nil
+	}
+
+	return binary.BigEndian.Uint32(entry[indexHashLength:]), nil
+}
+
+func (i *indexChecker) Close() error {
+	return i.file.Close()
+}
+
+func (i *indexChecker) entryOffset(n uint64) int64 {
+	return int64(len(indexMagic)+indexTableLength) + int64(n)*indexEntryLength
+}
+
+// BuildIndex writes the index of source to output and returns the number of
+// hashes it holds. source is either a directory of range files or a single
+// "<hash>:<count>" dump ordered by hash, both as published by HIBP.
+func BuildIndex(source string, output io.WriteSeeker) (entryCount uint64, err error) {
+	if _, err = output.Write([]byte(indexMagic)); err != nil {
+		return 0, err
+	}
+
+	if _, err = output.Write(make([]byte, indexTableLength)); err != nil {
+		return 0, err
+	}
+
+	builder := &indexBuilder{
+		writer: bufio.NewWriter(output),
+	}
+
+	info, err := os.Stat(source)
+	if err != nil {
+		return 0, err
+	}
+
+	if info.IsDir() {
+		err = builder.addDirectory(source)
+	} else {
+		err = builder.addFile(source, "")
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	builder.fill()
+
+	if err = builder.writer.Flush(); err != nil {
+		return 0, err
+	}
+
+	if _, err = output.Seek(int64(len(indexMagic)), io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	table := make([]byte, indexTableLength)
+	for bucket, offset := range builder.bucketStarts {
+		binary.BigEndian.PutUint64(table[bucket*8:], offset)
+	}
+	binary.BigEndian.PutUint64(table[indexBucketCount*8:], builder.entryCount)
+
+	if _, err = output.Write(table); err != nil {
+		return 0, err
+	}
+
+	return builder.entryCount, nil
+}
+
+type indexBuilder struct {
+	writer       *bufio.Writer
+	bucketStarts [indexBucketCount]uint64
+	nextBucket   int
+	entryCount   uint64
+	previousHash []byte
+}
+
+func (b *indexBuilder) addDirectory(directory string) error {
+	paths, err := filepath.Glob(filepath.Join(directory, "*.txt"))
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(paths, func(i, j int) bool {
+		return strings.ToUpper(filepath.Base(paths[i])) < strings.ToUpper(filepath.Base(paths[j]))
+	})
+
+	for _, path := range paths {
+		prefix := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		if len(prefix) != rangePrefixLength {
+			continue
+		}
+
+		if err = b.addFile(path, strings.ToUpper(prefix)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addFile adds every line of path, prepending prefix to the hash of each
+// line as range files only hold the hash suffix.
+func (b *indexBuilder) addFile(path string, prefix string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		suffix, count, err := parseRangeLine(scanner.Text())
+		if err != nil {
+			return err
+		}
+
+		hash, err := hex.DecodeString(prefix + suffix)
+		if err != nil || len(hash) != 20 {
+			return ErrInvalidEntry
+		}
+
+		if err = b.add(hash, count); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+func (b *indexBuilder) add(hash []byte, count uint32) error {
+	if b.previousHash != nil && bytes.Compare(hash, b.previousHash) < 0 {
+		return ErrUnsorted
+	}
+
+	// Hashes sharing their first 80 bits collapse into one entry.
+	if b.previousHash != nil && bytes.Equal(hash[:2+indexHashLength], b.previousHash[:2+indexHashLength]) {
+		return nil
+	}
+
+	b.previousHash = hash
+
+	bucket := int(binary.BigEndian.Uint16(hash[:2]))
+	for ; b.nextBucket <= bucket; b.nextBucket++ {
+		b.bucketStarts[b.nextBucket] = b.entryCount
+	}
+
+	if count == 0 {
+		count = 1
+	}
+
+	entry := make([]byte, indexEntryLength)
+	copy(entry, hash[2:2+indexHashLength])
+	binary.BigEndian.PutUint32(entry[indexHashLength:], count)
+
+	if _, err := b.writer.Write(entry); err != nil {
+		return err
+	}
+
+	b.entryCount++
+
+	return nil
+}
+
+// fill points the buckets after the last hash at the end of the entries.
+func (b *indexBuilder) fill() {
+	for ; b.nextBucket < indexBucketCount; b.nextBucket++ {
+		b.bucketStarts[b.nextBucket] = b.entryCount
+	}
+}
@@ -0,0 +1,54 @@
+package pwned
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+)
+
+// Breached passwords are looked up by the SHA-1 of the password the way the
+// Have I Been Pwned range API does it: the hash is split into a short prefix
+// that selects a bucket and a suffix that is searched inside the bucket. The
+// data never leaves the machine, so no part of the hash is sent anywhere.
+
+const (
+	ModeReject = "reject"
+	ModeWarn   = "warn"
+)
+
+var (
+	ErrInvalidIndex = errors.New("the pwned passwords index is invalid")
+	ErrInvalidEntry = errors.New("the pwned passwords data contains an invalid entry")
+	ErrUnsorted     = errors.New("the pwned passwords data is not sorted by hash")
+)
+
+type CheckerInterface interface {
+	// Count returns how many times password appears in the breach corpus,
+	// zero when it does not appear at all.
+	Count(ctx context.Context, password string) (count uint32, err error)
+	Close() error
+}
+
+// Open returns a checker for source, which is either a directory of range
+// files as written by the HIBP downloader or an index built by BuildIndex.
+func Open(source string) (CheckerInterface, error) {
+	info, err := os.Stat(source)
+	if err != nil {
+		return nil, err
+	}
+
+	if info.IsDir() {
+		return NewDirectoryChecker(source), nil
+	}
+
+	return NewIndexChecker(source)
+}
+
+func hashPassword(password string) string {
+	sum := sha1.Sum([]byte(password))
+
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,77 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// sweepInterval is how often buckets that are full again are dropped.
+const sweepInterval = time.Minute
+
+type bucket struct {
+	tokens    float64
+	countedAt time.Time
+	expiresAt time.Time
+}
+
+type memoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+}
+
+// NewMemoryStore returns a store keeping the buckets of this replica only.
+func NewMemoryStore() StoreInterface {
+	return &memoryStore{
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (s *memoryStore) Take(ctx context.Context, key string, rule Rule, now time.Time) (allowed bool, retryAfter time.Duration, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sweep(now)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens:    float64(rule.Limit),
+			countedAt: now,
+		}
+		s.buckets[key] = b
+	}
+
+	if now.After(b.countedAt) {
+		refilled := float64(now.Sub(b.countedAt)) * float64(rule.Limit) / float64(rule.Period)
+		b.tokens = math.Min(float64(rule.Limit), b.tokens+refilled)
+		b.countedAt = now
+	}
+
+	b.expiresAt = now.Add(rule.Period)
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0, nil
+	}
+
+	retryAfter = time.Duration(math.Ceil((1 - b.tokens) * float64(rule.Period) / float64(rule.Limit)))
+
+	return false, retryAfter, nil
+}
+
+func (s *memoryStore) sweep(now time.Time) {
+	if now.Sub(s.lastSweep) < sweepInterval {
+		return
+	}
+
+	for key, b := range s.buckets {
+		if now.After(b.expiresAt) {
+			delete(s.buckets, key)
+		}
+	}
+
+	s.lastSweep = now
+}
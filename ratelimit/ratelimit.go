@@ -0,0 +1,142 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultRule is the name of the rule applied to RPCs without one of their
+// own.
+const DefaultRule = "*"
+
+// Unlimited is the rule value that exempts an RPC from the default rule.
+const Unlimited = "none"
+
+var ErrInvalidRule = errors.New("rate limit rules must look like 10/1m or none")
+
+// Rule is a token bucket holding up to Limit tokens that refills at Limit
+// tokens per Period. Every call takes one token, so a caller can burst Limit
+// calls and then keeps Limit calls per Period.
+type Rule struct {
+	Limit  int64
+	Period time.Duration
+}
+
+// Rules maps short RPC names, e.g. Login, to their rule. A zero Rule means
+// the RPC is not limited.
+type Rules map[string]Rule
+
+// ParseRules parses rules such as {"*": "100/1s", "Login": "10/1m",
+// "Authenticate": "none"}.
+func ParseRules(values map[string]string) (Rules, error) {
+	rules := make(Rules, len(values))
+
+	for method, value := range values {
+		rule, err := ParseRule(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", method, err)
+		}
+
+		rules[strings.TrimSpace(method)] = rule
+	}
+
+	return rules, nil
+}
+
+// ParseRule parses a rule such as 10/1m, ten calls per minute, or none.
+func ParseRule(value string) (Rule, error) {
+	value = strings.TrimSpace(value)
+	if value == Unlimited {
+		return Rule{}, nil
+	}
+
+	limit, period, found := strings.Cut(value, "/")
+	if !found {
+		return Rule{}, ErrInvalidRule
+	}
+
+	parsedLimit, err := strconv.ParseInt(limit, 10, 64)
+	if err != nil || parsedLimit <= 0 {
+		return Rule{}, ErrInvalidRule
+	}
+
+	parsedPeriod, err := time.ParseDuration(period)
+	if err != nil || parsedPeriod < time.Millisecond {
+		return Rule{}, ErrInvalidRule
+	}
+
+	return Rule{
+		Limit:  parsedLimit,
+		Period: parsedPeriod,
+	}, nil
+}
+
+// For returns the rule of method, falling back to the default rule. ok is
+// false when method is not limited.
+func (r Rules) For(method string) (rule Rule, ok bool) {
+	rule, ok = r[method]
+	if !ok {
+		rule = r[DefaultRule]
+	}
+
+	return rule, rule.Limit > 0
+}
+
+// StoreInterface keeps the token buckets.
+type StoreInterface interface {
+	// Take takes a token from the bucket of key. When the bucket is empty
+	// allowed is false and retryAfter is how long until a token is back.
+	Take(ctx context.Context, key string, rule Rule, now time.Time) (allowed bool, retryAfter time.Duration, err error)
+}
+
+type LimiterInterface interface {
+	// Allow takes a token for a call to method by identity.
+	Allow(ctx context.Context, method string, identity string) (allowed bool, retryAfter time.Duration)
+}
+
+type limiter struct {
+	rules    Rules
+	store    StoreInterface
+	fallback StoreInterface
+}
+
+// NewLimiter returns a limiter keeping its buckets in store. While store
+// fails, e.g. when Redis is down, buckets are kept in fallback instead, so
+// limits keep holding per replica.
+func NewLimiter(rules Rules, store StoreInterface, fallback StoreInterface) LimiterInterface {
+	return &limiter{
+		rules:    rules,
+		store:    store,
+		fallback: fallback,
+	}
+}
+
+func (l *limiter) Allow(ctx context.Context, method string, identity string) (allowed bool, retryAfter time.Duration) {
+	rule, ok := l.rules.For(method)
+	if !ok {
+		return true, 0
+	}
+
+	key := fmt.Sprintf("rate_limit.%s.%s", method, identity)
+	now := time.Now()
+
+	allowed, retryAfter, err := l.store.Take(ctx, key, rule, now)
+	if err == nil {
+		return allowed, retryAfter
+	}
+
+	log.WithError(err).Warnf(ctx, "error in rate limit store, using the in-memory fallback")
+
+	allowed, retryAfter, err = l.fallback.Take(ctx, key, rule, now)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in rate limit fallback store")
+		return true, 0
+	}
+
+	return allowed, retryAfter
+}
@@ -0,0 +1,63 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// takeScript refills and takes from a bucket atomically, so that replicas
+// sharing Redis share the limits. Buckets are hashes of the tokens left and
+// the time in milliseconds they were counted at. A bucket left alone for a
+// period is full again and is allowed to expire.
+var takeScript = redis.NewScript(`
+local limit = tonumber(ARGV[1])
+local period = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+
+local state = redis.call("HMGET", KEYS[1], "tokens", "ts")
+local tokens = tonumber(state[1])
+local ts = tonumber(state[2])
+if tokens == nil or ts == nil then
+	tokens = limit
+	ts = now
+end
+
+if now > ts then
+	tokens = math.min(limit, tokens + (now - ts) * limit / period)
+	ts = now
+end
+
+local allowed = 0
+local retry_after = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+else
+	retry_after = math.ceil((1 - tokens) * period / limit)
+end
+
+redis.call("HSET", KEYS[1], "tokens", tostring(tokens), "ts", ts)
+redis.call("PEXPIRE", KEYS[1], period)
+
+return {allowed, retry_after}
+`)
+
+type redisStore struct {
+	redis *redis.Client
+}
+
+func NewRedisStore(redis *redis.Client) StoreInterface {
+	return &redisStore{
+		redis: redis,
+	}
+}
+
+func (s *redisStore) Take(ctx context.Context, key string, rule Rule, now time.Time) (allowed bool, retryAfter time.Duration, err error) {
+	result, err := takeScript.Run(ctx, s.redis, []string{key}, rule.Limit, rule.Period.Milliseconds(), now.UnixMilli()).Int64Slice()
+	if err != nil {
+		return false, 0, err
+	}
+
+	return result[0] == 1, time.Duration(result[1]) * time.Millisecond, nil
+}
@@ -2,24 +2,56 @@ package svc
 
 import (
 	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/erfansahebi/lamia_auth/model"
 	"github.com/erfansahebi/lamia_shared/go/log"
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
+	"strconv"
 	"time"
 )
 
+const maxTokenFamilyUpdateAttempts = 5
+
+// extendTTLScript pushes the expiry of KEYS[1] out to ARGV[1] milliseconds
+// from now, but never pulls it in. Keys without an expiry get one; missing
+// keys are left alone.
+var extendTTLScript = redis.NewScript(`
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl == -1 or (ttl >= 0 and ttl < tonumber(ARGV[1])) then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+
+return 0
+`)
+
 type auth struct {
-	pgx   PgxConn
-	redis *redis.Client
+	pgx       PgxConn
+	redis     *redis.Client
+	jwtIssuer JWTIssuerInterface
+
+	tokenHashSecret   []byte
+	legacyKeyFallback bool
 }
 
-func NewAuthDAL(pgx PgxConn, redis *redis.Client) AuthDALInterface {
+// NewAuthDAL creates the auth DAL. jwtIssuer is optional; when it is set,
+// access tokens are handed out as signed JWTs whose jti points at the stored
+// token instead of the opaque token itself.
+//
+// Tokens are stored under an HMAC of tokenHashSecret, never in plaintext.
+// With legacyKeyFallback, tokens stored under their plaintext key by earlier
+// releases are still accepted until they expire.
+func NewAuthDAL(pgx PgxConn, redis *redis.Client, jwtIssuer JWTIssuerInterface, tokenHashSecret string, legacyKeyFallback bool) AuthDALInterface {
 	return &auth{
-		pgx:   pgx,
-		redis: redis,
+		pgx:               pgx,
+		redis:             redis,
+		jwtIssuer:         jwtIssuer,
+		tokenHashSecret:   []byte(tokenHashSecret),
+		legacyKeyFallback: legacyKeyFallback,
 	}
 }
 
@@ -35,7 +67,7 @@ func (a *auth) StoreUser(ctx context.Context, user model.User) (model.User, erro
 	              	email,
 	              	password
 			) VALUES (
-				  	$1, $2, $3, $4, $5
+				  	$1, $2, $3, $4, NULLIF($5, '')
 			) RETURNING id`,
 		user.ID,
 		user.FirstName,
@@ -58,9 +90,11 @@ func (a *auth) FetchUser(ctx context.Context, userID uuid.UUID) (fetchedUser mod
 					first_name,
 					last_name,
 					email,
-					password,
+					COALESCE(password, ''),
 					created_at,
-					updated_at
+					updated_at,
+					password_changed_at,
+					email_verified_at
 			FROM users
 			WHERE id = $1`,
 		userID,
@@ -91,9 +125,11 @@ func (a *auth) FetchUserByEmail(ctx context.Context, email string) (fetchedUser
 					first_name,
 					last_name,
 					email,
-					password,
+					COALESCE(password, ''),
 					created_at,
-					updated_at
+					updated_at,
+					password_changed_at,
+					email_verified_at
 			FROM users
 			WHERE email = $1`,
 		email,
@@ -117,11 +153,68 @@ func (a *auth) FetchUserByEmail(ctx context.Context, email string) (fetchedUser
 	return model.User{}, ErrUserDoesNotExists
 }
 
+func (a *auth) UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	commandTag, err := a.pgx.Exec(
+		ctx,
+		`UPDATE users
+			SET password = $2
+			WHERE id = $1`,
+		userID,
+		passwordHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return ErrUserDoesNotExists
+	}
+
+	return nil
+}
+
+func (a *auth) UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) error {
+	commandTag, err := a.pgx.Exec(
+		ctx,
+		`UPDATE users
+			SET password = $2,
+				password_changed_at = NOW()
+			WHERE id = $1`,
+		userID,
+		passwordHash,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return ErrUserDoesNotExists
+	}
+
+	return nil
+}
+
 func (a *auth) StoreToken(ctx context.Context, tokenDetail model.Token, expireDuration uint) (tokenString string, err error) {
-	tokenString = a.generateToken()
+	tokenID, err := a.generateTokenID()
+	if err != nil {
+		return "", err
+	}
 
 	tokenDetail.IssuedAt = time.Now()
+	tokenDetail.LastUsedAt = tokenDetail.IssuedAt
 	tokenDetail.ExpiredAt = tokenDetail.IssuedAt.Add(time.Duration(expireDuration) * time.Minute)
+	if tokenDetail.MaxExpiredAt.Before(tokenDetail.ExpiredAt) {
+		tokenDetail.MaxExpiredAt = tokenDetail.ExpiredAt
+	}
+
+	tokenString = tokenID
+	if a.jwtIssuer != nil {
+		tokenString, err = a.jwtIssuer.Issue(ctx, tokenDetail, tokenID)
+		if err != nil {
+			log.WithError(err).Errorf(ctx, "error in sign jwt")
+			return "", err
+		}
+	}
 
 	data, err := json.Marshal(tokenDetail)
 	if err != nil {
@@ -129,20 +222,43 @@ func (a *auth) StoreToken(ctx context.Context, tokenDetail model.Token, expireDu
 		return "", err
 	}
 
-	a.redis.Set(ctx, a.generateTokenKey(tokenString), data, time.Duration(expireDuration)*time.Minute)
+	tokenKey := a.generateTokenKey(tokenID)
+	if _, err = a.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Set(ctx, tokenKey, data, time.Duration(expireDuration)*time.Minute)
+
+		// The tokens of a family are tracked as long as the longest lived
+		// of them, so that revoking the family still finds every one.
+		if tokenDetail.FamilyID != uuid.Nil {
+			familyTokensKey := a.generateTokenFamilyTokensKey(tokenDetail.FamilyID)
+			pipe.SAdd(ctx, familyTokensKey, tokenKey)
+			extendTTLScript.Eval(ctx, pipe, []string{familyTokensKey}, (time.Duration(expireDuration) * time.Minute).Milliseconds())
+		}
+
+		return nil
+	}); err != nil {
+		log.WithError(err).Errorf(ctx, "error in store token on redis")
+		return "", err
+	}
 
 	return tokenString, nil
 }
 
+// FetchToken returns the access token token. Tokens that expired, were
+// revoked or never existed are all rejected with ErrInvalidToken.
 func (a *auth) FetchToken(ctx context.Context, token string) (fetchedToken model.Token, err error) {
-	fetchedData, err := a.redis.Get(ctx, a.generateTokenKey(token)).Result()
+	tokenID, err := a.tokenID(ctx, token)
+	if err != nil {
+		return fetchedToken, err
+	}
+
+	_, fetchedData, err := a.getWithLegacyFallback(ctx, a.generateTokenKey(tokenID), a.generateLegacyTokenKey(tokenID))
 	if err != nil && err.Error() != "redis: nil" {
 		log.WithError(err).Fatalf(ctx, "error in fetch data from redis")
 		return fetchedToken, err
 	}
 
 	if fetchedData == "" {
-		return fetchedToken, ErrEntryNotFound
+		return fetchedToken, ErrInvalidToken
 	}
 
 	if err = json.Unmarshal([]byte(fetchedData), &fetchedToken); err != nil {
@@ -153,14 +269,492 @@ func (a *auth) FetchToken(ctx context.Context, token string) (fetchedToken model
 	return fetchedToken, nil
 }
 
+// DeleteToken removes token and ends the session it belongs to.
 func (a *auth) DeleteToken(ctx context.Context, token string) {
-	a.redis.Del(ctx, a.generateTokenKey(token))
+	tokenDetail, err := a.FetchToken(ctx, token)
+	if err != nil {
+		return
+	}
+
+	if tokenDetail.FamilyID != uuid.Nil {
+		a.RevokeTokenFamily(ctx, tokenDetail.FamilyID)
+	}
+
+	tokenID, err := a.tokenID(ctx, token)
+	if err != nil {
+		return
+	}
+
+	a.redis.Del(ctx, a.generateTokenKey(tokenID), a.generateLegacyTokenKey(tokenID))
 }
 
-func (a *auth) generateToken() string {
-	return uuid.New().String()
+func (a *auth) StoreTokenFamily(ctx context.Context, tokenFamily model.TokenFamily, expireDuration uint) (storedTokenFamily model.TokenFamily, refreshToken string, err error) {
+	refreshToken, err = generateOpaqueToken(TokenPrefixRefresh)
+	if err != nil {
+		return model.TokenFamily{}, "", err
+	}
+
+	tokenFamily.ID = uuid.New()
+	tokenFamily.RefreshTokenKey = a.generateRefreshTokenKey(refreshToken)
+	tokenFamily.IssuedAt = time.Now()
+	tokenFamily.LastUsedAt = tokenFamily.IssuedAt
+	tokenFamily.ExpiredAt = tokenFamily.IssuedAt.Add(time.Duration(expireDuration) * time.Minute)
+
+	if _, err = a.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		return a.storeRefreshToken(ctx, pipe, tokenFamily)
+	}); err != nil {
+		log.WithError(err).Errorf(ctx, "error in store token family on redis")
+		return model.TokenFamily{}, "", err
+	}
+
+	return tokenFamily, refreshToken, nil
+}
+
+// FetchRefreshToken returns what refreshToken points at without using it up,
+// so that callers can check its user before rotating it.
+func (a *auth) FetchRefreshToken(ctx context.Context, refreshToken string) (fetchedRefreshToken model.RefreshToken, err error) {
+	_, fetchedRefreshToken, err = a.fetchRefreshToken(ctx, refreshToken)
+
+	return fetchedRefreshToken, err
+}
+
+// RotateRefreshToken exchanges refreshToken for a new one in the same family.
+// Presenting a refresh token that has already been rotated is treated as
+// token theft: the whole family is revoked and ErrRefreshTokenUsed is returned.
+// Families granted to another OAuth client than clientID are left alone and
+// rejected with ErrInvalidToken.
+func (a *auth) RotateRefreshToken(ctx context.Context, refreshToken string, clientID string, clientInfo model.ClientInfo, expireDuration uint) (tokenFamily model.TokenFamily, newRefreshToken string, err error) {
+	refreshTokenKey, fetchedRefreshToken, err := a.fetchRefreshToken(ctx, refreshToken)
+	if err != nil {
+		return model.TokenFamily{}, "", err
+	}
+
+	newRefreshToken, err = generateOpaqueToken(TokenPrefixRefresh)
+	if err != nil {
+		return model.TokenFamily{}, "", err
+	}
+
+	tokenFamily, err = a.updateTokenFamily(ctx, fetchedRefreshToken.FamilyID, func(pipe redis.Pipeliner, tokenFamily *model.TokenFamily) error {
+		if tokenFamily.RefreshTokenKey != refreshTokenKey {
+			return ErrRefreshTokenUsed
+		}
+
+		if tokenFamily.ClientID != clientID {
+			return ErrInvalidToken
+		}
+
+		tokenFamily.RefreshTokenKey = a.generateRefreshTokenKey(newRefreshToken)
+		tokenFamily.ClientInfo = clientInfo
+		tokenFamily.LastUsedAt = time.Now()
+		tokenFamily.ExpiredAt = tokenFamily.LastUsedAt.Add(time.Duration(expireDuration) * time.Minute)
+
+		return a.storeRefreshToken(ctx, pipe, *tokenFamily)
+	})
+
+	switch {
+	case err == nil:
+		return tokenFamily, newRefreshToken, nil
+	case err == ErrRefreshTokenUsed:
+		log.WithField("family_id", fetchedRefreshToken.FamilyID).Warnf(ctx, "refresh token reuse detected, revoking token family")
+		a.RevokeTokenFamily(ctx, fetchedRefreshToken.FamilyID)
+		return model.TokenFamily{}, "", ErrRefreshTokenUsed
+	case err == ErrEntryNotFound, err == ErrInvalidToken:
+		return model.TokenFamily{}, "", err
+	default:
+		log.WithError(err).Errorf(ctx, "error in rotate refresh token on redis")
+		return model.TokenFamily{}, "", err
+	}
+}
+
+// TouchToken records that token was just used. With a non-zero idleTimeout
+// the token's expiry slides to idleTimeout from now, but never past its
+// MaxExpiredAt, and the family keeps tracking it for as long. To keep
+// Authenticate cheap the write is skipped while the previous one is younger
+// than interval.
+func (a *auth) TouchToken(ctx context.Context, token string, tokenDetail model.Token, interval time.Duration, idleTimeout time.Duration) (touchedToken model.Token, err error) {
+	now := time.Now()
+	if now.Sub(tokenDetail.LastUsedAt) < interval {
+		return tokenDetail, nil
+	}
+
+	tokenID, err := a.tokenID(ctx, token)
+	if err != nil {
+		return tokenDetail, err
+	}
+
+	tokenDetail.LastUsedAt = now
+
+	ttl := time.Duration(redis.KeepTTL)
+	if idleTimeout > 0 {
+		expiredAt := now.Add(idleTimeout)
+		if expiredAt.After(tokenDetail.MaxExpiredAt) {
+			expiredAt = tokenDetail.MaxExpiredAt
+		}
+
+		if expiredAt.After(tokenDetail.ExpiredAt) {
+			tokenDetail.ExpiredAt = expiredAt
+			ttl = time.Until(expiredAt)
+		}
+	}
+
+	data, err := json.Marshal(tokenDetail)
+	if err != nil {
+		return tokenDetail, err
+	}
+
+	isSet, err := a.redis.SetXX(ctx, a.generateTokenKey(tokenID), data, ttl).Result()
+	if err == nil && !isSet && a.legacyKeyFallback {
+		err = a.redis.SetXX(ctx, a.generateLegacyTokenKey(tokenID), data, ttl).Err()
+	}
+
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in touch token on redis")
+		return tokenDetail, err
+	}
+
+	if tokenDetail.FamilyID == uuid.Nil {
+		return tokenDetail, nil
+	}
+
+	if _, err = a.updateTokenFamily(ctx, tokenDetail.FamilyID, func(pipe redis.Pipeliner, tokenFamily *model.TokenFamily) error {
+		tokenFamily.LastUsedAt = now
+
+		familyData, err := json.Marshal(tokenFamily)
+		if err != nil {
+			return err
+		}
+
+		pipe.SetXX(ctx, a.generateTokenFamilyKey(tokenFamily.ID), familyData, redis.KeepTTL)
+
+		if ttl != time.Duration(redis.KeepTTL) {
+			extendTTLScript.Eval(ctx, pipe, []string{a.generateTokenFamilyTokensKey(tokenFamily.ID)}, ttl.Milliseconds())
+		}
+
+		return nil
+	}); err != nil && err != ErrEntryNotFound {
+		log.WithError(err).Errorf(ctx, "error in touch token family on redis")
+		return tokenDetail, err
+	}
+
+	return tokenDetail, nil
+}
+
+func (a *auth) RevokeTokenFamily(ctx context.Context, familyID uuid.UUID) {
+	tokenFamilyKey := a.generateTokenFamilyKey(familyID)
+	familyTokensKey := a.generateTokenFamilyTokensKey(familyID)
+
+	tokenFamily, err := a.FetchTokenFamily(ctx, familyID)
+	if err != nil && err != ErrEntryNotFound {
+		log.WithError(err).Errorf(ctx, "error in fetch token family from redis")
+	}
+
+	tokenKeys, err := a.redis.SMembers(ctx, familyTokensKey).Result()
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in fetch token family members from redis")
+	}
+
+	if _, err = a.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, append(tokenKeys, tokenFamilyKey, familyTokensKey)...)
+
+		if tokenFamily.UserID != uuid.Nil {
+			pipe.ZRem(ctx, a.generateUserSessionsKey(tokenFamily.UserID), familyID.String())
+		}
+
+		return nil
+	}); err != nil {
+		log.WithError(err).Errorf(ctx, "error in revoke token family on redis")
+	}
+}
+
+// FetchUserTokenFamilies lists the live sessions of userID. Index entries of
+// sessions that expired in the meantime are pruned on the way.
+func (a *auth) FetchUserTokenFamilies(ctx context.Context, userID uuid.UUID) (tokenFamilies []model.TokenFamily, err error) {
+	userSessionsKey := a.generateUserSessionsKey(userID)
+
+	if err = a.redis.ZRemRangeByScore(ctx, userSessionsKey, "-inf", strconv.FormatInt(time.Now().Unix(), 10)).Err(); err != nil {
+		log.WithError(err).Errorf(ctx, "error in prune user sessions on redis")
+		return nil, err
+	}
+
+	familyIDs, err := a.redis.ZRange(ctx, userSessionsKey, 0, -1).Result()
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in fetch user sessions from redis")
+		return nil, err
+	}
+
+	tokenFamilies = make([]model.TokenFamily, 0, len(familyIDs))
+	if len(familyIDs) == 0 {
+		return tokenFamilies, nil
+	}
+
+	tokenFamilyKeys := make([]string, 0, len(familyIDs))
+	for _, familyID := range familyIDs {
+		parsedFamilyID, err := uuid.Parse(familyID)
+		if err != nil {
+			return nil, err
+		}
+
+		tokenFamilyKeys = append(tokenFamilyKeys, a.generateTokenFamilyKey(parsedFamilyID))
+	}
+
+	fetchedData, err := a.redis.MGet(ctx, tokenFamilyKeys...).Result()
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in fetch token families from redis")
+		return nil, err
+	}
+
+	staleFamilyIDs := make([]interface{}, 0)
+	for i, data := range fetchedData {
+		encodedFamily, ok := data.(string)
+		if !ok {
+			staleFamilyIDs = append(staleFamilyIDs, familyIDs[i])
+			continue
+		}
+
+		var tokenFamily model.TokenFamily
+		if err = json.Unmarshal([]byte(encodedFamily), &tokenFamily); err != nil {
+			log.WithError(err).Errorf(ctx, "error in unmarshal token family from redis")
+			return nil, err
+		}
+
+		tokenFamilies = append(tokenFamilies, tokenFamily)
+	}
+
+	if len(staleFamilyIDs) > 0 {
+		a.redis.ZRem(ctx, userSessionsKey, staleFamilyIDs...)
+	}
+
+	return tokenFamilies, nil
+}
+
+func (a *auth) RevokeUserTokenFamilies(ctx context.Context, userID uuid.UUID, exceptFamilyID uuid.UUID) (revokedCount int, err error) {
+	tokenFamilies, err := a.FetchUserTokenFamilies(ctx, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, tokenFamily := range tokenFamilies {
+		if tokenFamily.ID == exceptFamilyID {
+			continue
+		}
+
+		a.RevokeTokenFamily(ctx, tokenFamily.ID)
+		revokedCount++
+	}
+
+	return revokedCount, nil
+}
+
+// ElevateTokenFamily records on the session familyID that the user just
+// re-authenticated, which lets it perform sensitive actions for duration.
+func (a *auth) ElevateTokenFamily(ctx context.Context, familyID uuid.UUID, duration time.Duration) (tokenFamily model.TokenFamily, err error) {
+	return a.updateTokenFamily(ctx, familyID, func(pipe redis.Pipeliner, tokenFamily *model.TokenFamily) error {
+		elevatedUntil := time.Now().Add(duration)
+		tokenFamily.ElevatedUntil = &elevatedUntil
+
+		familyData, err := json.Marshal(tokenFamily)
+		if err != nil {
+			return err
+		}
+
+		pipe.SetXX(ctx, a.generateTokenFamilyKey(tokenFamily.ID), familyData, redis.KeepTTL)
+
+		return nil
+	})
+}
+
+// updateTokenFamily applies update to the stored family inside a WATCH on
+// its key, retrying when a concurrent writer got in between. update mutates
+// the family and queues the writes that should be committed with it.
+func (a *auth) updateTokenFamily(ctx context.Context, familyID uuid.UUID, update func(pipe redis.Pipeliner, tokenFamily *model.TokenFamily) error) (tokenFamily model.TokenFamily, err error) {
+	tokenFamilyKey := a.generateTokenFamilyKey(familyID)
+
+	for attempt := 0; attempt < maxTokenFamilyUpdateAttempts; attempt++ {
+		err = a.redis.Watch(ctx, func(tx *redis.Tx) error {
+			familyData, err := tx.Get(ctx, tokenFamilyKey).Result()
+			switch {
+			case err == redis.Nil:
+				return ErrEntryNotFound
+			case err != nil:
+				return err
+			}
+
+			if err = json.Unmarshal([]byte(familyData), &tokenFamily); err != nil {
+				return err
+			}
+
+			_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+				return update(pipe, &tokenFamily)
+			})
+
+			return err
+		}, tokenFamilyKey)
+
+		if err != redis.TxFailedErr {
+			return tokenFamily, err
+		}
+	}
+
+	return model.TokenFamily{}, err
+}
+
+// FetchTokenFamily returns the session familyID, ErrEntryNotFound once it
+// has ended.
+func (a *auth) FetchTokenFamily(ctx context.Context, familyID uuid.UUID) (tokenFamily model.TokenFamily, err error) {
+	fetchedData, err := a.redis.Get(ctx, a.generateTokenFamilyKey(familyID)).Result()
+	switch {
+	case err == redis.Nil:
+		return model.TokenFamily{}, ErrEntryNotFound
+	case err != nil:
+		return model.TokenFamily{}, err
+	}
+
+	if err = json.Unmarshal([]byte(fetchedData), &tokenFamily); err != nil {
+		return model.TokenFamily{}, err
+	}
+
+	return tokenFamily, nil
+}
+
+// fetchRefreshToken looks refreshToken up and returns the key it was found
+// under along with the stored entry.
+func (a *auth) fetchRefreshToken(ctx context.Context, refreshToken string) (refreshTokenKey string, fetchedRefreshToken model.RefreshToken, err error) {
+	if err = a.validateToken(refreshToken, TokenPrefixRefresh); err != nil {
+		return "", model.RefreshToken{}, err
+	}
+
+	refreshTokenKey, fetchedData, err := a.getWithLegacyFallback(ctx, a.generateRefreshTokenKey(refreshToken), a.generateLegacyRefreshTokenKey(refreshToken))
+	switch {
+	case err == redis.Nil:
+		return "", model.RefreshToken{}, ErrEntryNotFound
+	case err != nil:
+		log.WithError(err).Errorf(ctx, "error in fetch refresh token from redis")
+		return "", model.RefreshToken{}, err
+	}
+
+	if err = json.Unmarshal([]byte(fetchedData), &fetchedRefreshToken); err != nil {
+		log.WithError(err).Errorf(ctx, "error in unmarshal refresh token from redis")
+		return "", model.RefreshToken{}, err
+	}
+
+	return refreshTokenKey, fetchedRefreshToken, nil
+}
+
+// storeRefreshToken queues the writes for tokenFamily, its current refresh
+// token and its entry in the user's session index. Rotated refresh tokens are
+// kept until the family expires so that a replay of one of them can still be
+// detected.
+func (a *auth) storeRefreshToken(ctx context.Context, pipe redis.Pipeliner, tokenFamily model.TokenFamily) error {
+	ttl := time.Until(tokenFamily.ExpiredAt)
+	userSessionsKey := a.generateUserSessionsKey(tokenFamily.UserID)
+
+	familyData, err := json.Marshal(tokenFamily)
+	if err != nil {
+		return err
+	}
+
+	refreshData, err := json.Marshal(model.RefreshToken{
+		FamilyID:  tokenFamily.ID,
+		UserID:    tokenFamily.UserID,
+		IssuedAt:  time.Now(),
+		ExpiredAt: tokenFamily.ExpiredAt,
+	})
+	if err != nil {
+		return err
+	}
+
+	pipe.Set(ctx, a.generateTokenFamilyKey(tokenFamily.ID), familyData, ttl)
+	pipe.Set(ctx, tokenFamily.RefreshTokenKey, refreshData, ttl)
+	pipe.ZAdd(ctx, userSessionsKey, redis.Z{
+		Score:  float64(tokenFamily.ExpiredAt.Unix()),
+		Member: tokenFamily.ID.String(),
+	})
+	// The index has to outlive the longest session in it, not the latest.
+	extendTTLScript.Eval(ctx, pipe, []string{userSessionsKey}, ttl.Milliseconds())
+
+	return nil
+}
+
+// tokenID resolves the identifier a token is stored under: the opaque token
+// itself, or the jti claim of a verified JWT.
+func (a *auth) tokenID(ctx context.Context, token string) (string, error) {
+	if a.jwtIssuer == nil {
+		return token, a.validateToken(token, TokenPrefixAccess)
+	}
+
+	return a.jwtIssuer.Verify(ctx, token)
+}
+
+// validateToken accepts well formed opaque tokens of the kind given by prefix
+// and, while the legacy fallback is enabled, the UUID tokens handed out by
+// earlier releases.
+func (a *auth) validateToken(token string, prefix string) error {
+	err := validateOpaqueToken(token, prefix)
+	if err != nil && a.legacyKeyFallback {
+		if _, uuidErr := uuid.Parse(token); uuidErr == nil {
+			return nil
+		}
+	}
+
+	return err
+}
+
+// getWithLegacyFallback reads key and, while the legacy fallback is enabled,
+// legacyKey when key is missing. It returns the key the value was found under.
+func (a *auth) getWithLegacyFallback(ctx context.Context, key string, legacyKey string) (foundKey string, data string, err error) {
+	data, err = a.redis.Get(ctx, key).Result()
+	if err != redis.Nil || !a.legacyKeyFallback {
+		return key, data, err
+	}
+
+	data, err = a.redis.Get(ctx, legacyKey).Result()
+
+	return legacyKey, data, err
+}
+
+// hashToken keys token with HMAC-SHA256 so that nothing read from Redis can
+// be replayed as a bearer token.
+func (a *auth) hashToken(token string) string {
+	mac := hmac.New(sha256.New, a.tokenHashSecret)
+	mac.Write([]byte(token))
+
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// generateTokenID returns the identifier a new access token is stored under.
+// A JWT only needs a unique jti since the signed token is the bearer secret.
+func (a *auth) generateTokenID() (string, error) {
+	if a.jwtIssuer != nil {
+		return uuid.New().String(), nil
+	}
+
+	return generateOpaqueToken(TokenPrefixAccess)
 }
 
 func (a *auth) generateTokenKey(token string) string {
+	return fmt.Sprintf("token.%s", a.hashToken(token))
+}
+
+func (a *auth) generateLegacyTokenKey(token string) string {
 	return fmt.Sprintf("token.%s", token)
 }
+
+func (a *auth) generateRefreshTokenKey(refreshToken string) string {
+	return fmt.Sprintf("refresh_token.%s", a.hashToken(refreshToken))
+}
+
+func (a *auth) generateLegacyRefreshTokenKey(refreshToken string) string {
+	return fmt.Sprintf("refresh_token.%s", refreshToken)
+}
+
+func (a *auth) generateTokenFamilyKey(familyID uuid.UUID) string {
+	return fmt.Sprintf("token_family.%s", familyID)
+}
+
+func (a *auth) generateTokenFamilyTokensKey(familyID uuid.UUID) string {
+	return fmt.Sprintf("token_family.%s.tokens", familyID)
+}
+
+func (a *auth) generateUserSessionsKey(userID uuid.UUID) string {
+	return fmt.Sprintf("user_sessions.%s", userID)
+}
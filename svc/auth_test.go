@@ -0,0 +1,122 @@
+package svc
+
+import (
+	"context"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"testing"
+	"time"
+)
+
+func newTestAuth(t *testing.T) (*auth, *miniredis.Miniredis) {
+	t.Helper()
+
+	server := miniredis.RunT(t)
+
+	return &auth{
+		redis:           redis.NewClient(&redis.Options{Addr: server.Addr()}),
+		tokenHashSecret: []byte("token-hash-secret"),
+	}, server
+}
+
+// A token that slid past its first expiry must still be found, and removed,
+// when its family is revoked.
+func TestRevokeTokenFamilyAfterSlidingExpiry(t *testing.T) {
+	ctx := context.Background()
+	a, server := newTestAuth(t)
+
+	tokenFamily, _, err := a.StoreTokenFamily(ctx, model.TokenFamily{UserID: uuid.New()}, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := a.StoreToken(ctx, model.Token{
+		UserID:       tokenFamily.UserID,
+		FamilyID:     tokenFamily.ID,
+		MaxExpiredAt: time.Now().Add(time.Hour),
+	}, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenDetail, err := a.FetchToken(ctx, token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = a.TouchToken(ctx, token, tokenDetail, 0, 10*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	// Past the first expiry of the token, within the one it slid to.
+	server.FastForward(7 * time.Minute)
+
+	if _, err = a.FetchToken(ctx, token); err != nil {
+		t.Fatalf("token expired despite sliding: %v", err)
+	}
+
+	a.RevokeTokenFamily(ctx, tokenFamily.ID)
+
+	if _, err = a.FetchToken(ctx, token); err != ErrInvalidToken {
+		t.Fatalf("token of revoked family: err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// Issuing a shorter lived token must not cut short how long the family
+// tracks a token that slid further.
+func TestStoreTokenKeepsLongerFamilyTracking(t *testing.T) {
+	ctx := context.Background()
+	a, server := newTestAuth(t)
+
+	tokenFamily, _, err := a.StoreTokenFamily(ctx, model.TokenFamily{UserID: uuid.New()}, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	longToken, err := a.StoreToken(ctx, model.Token{UserID: tokenFamily.UserID, FamilyID: tokenFamily.ID}, 30)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = a.StoreToken(ctx, model.Token{UserID: tokenFamily.UserID, FamilyID: tokenFamily.ID}, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(10 * time.Minute)
+
+	a.RevokeTokenFamily(ctx, tokenFamily.ID)
+
+	if _, err = a.FetchToken(ctx, longToken); err != ErrInvalidToken {
+		t.Fatalf("token of revoked family: err = %v, want %v", err, ErrInvalidToken)
+	}
+}
+
+// Signing in again with a shorter session must not drop the longer one from
+// the user's session index.
+func TestStoreTokenFamilyKeepsLongerSessionsListed(t *testing.T) {
+	ctx := context.Background()
+	a, server := newTestAuth(t)
+	userID := uuid.New()
+
+	longFamily, _, err := a.StoreTokenFamily(ctx, model.TokenFamily{UserID: userID}, 60)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err = a.StoreTokenFamily(ctx, model.TokenFamily{UserID: userID}, 5); err != nil {
+		t.Fatal(err)
+	}
+
+	server.FastForward(10 * time.Minute)
+
+	tokenFamilies, err := a.FetchUserTokenFamilies(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tokenFamilies) != 1 || tokenFamilies[0].ID != longFamily.ID {
+		t.Fatalf("got %d sessions, want only %s", len(tokenFamilies), longFamily.ID)
+	}
+}
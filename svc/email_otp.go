@@ -0,0 +1,135 @@
+package svc
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"math/big"
+	"time"
+)
+
+const emailOTPLength = 6
+
+// verifyEmailOTPScript compares a code hash with the pending one and counts
+// wrong guesses atomically. It returns 1 for the right code, which is
+// consumed, 0 for a wrong one and -1 when no code is pending. The code is
+// dropped once maxAttempts guesses were wrong.
+var verifyEmailOTPScript = redis.NewScript(`
+local code_hash = redis.call("HGET", KEYS[1], "code_hash")
+if not code_hash then
+	return -1
+end
+
+if code_hash == ARGV[1] then
+	redis.call("DEL", KEYS[1])
+	return 1
+end
+
+local attempts = redis.call("HINCRBY", KEYS[1], "attempts", 1)
+if attempts >= tonumber(ARGV[2]) then
+	redis.call("DEL", KEYS[1])
+end
+
+return 0
+`)
+
+// MFAChallengeSubject is the subject of passcodes answering the login
+// challenge challengeToken.
+func MFAChallengeSubject(challengeToken string) string {
+	return "mfa_challenge." + challengeToken
+}
+
+// StepUpSubject is the subject of passcodes stepping up the session familyID.
+func StepUpSubject(familyID uuid.UUID) string {
+	return "step_up." + familyID.String()
+}
+
+// StoreEmailOTP replaces the pending one-time passcode of subject with a new
+// one and returns it. subject names what the code is for, such as a login
+// challenge or a session being stepped up.
+func (a *auth) StoreEmailOTP(ctx context.Context, subject string, expireDuration uint) (code string, err error) {
+	code, err = generateNumericCode(emailOTPLength)
+	if err != nil {
+		return "", err
+	}
+
+	emailOTPKey := a.generateEmailOTPKey(subject)
+	if _, err = a.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		pipe.Del(ctx, emailOTPKey)
+		pipe.HSet(ctx, emailOTPKey, "code_hash", a.hashToken(subject+"."+code), "attempts", 0)
+		pipe.Expire(ctx, emailOTPKey, time.Duration(expireDuration)*time.Minute)
+
+		return nil
+	}); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// VerifyEmailOTP consumes the pending passcode of subject when code matches
+// it. Wrong codes are rejected with ErrInvalidMFACode, and after maxAttempts
+// of them the passcode is dropped.
+func (a *auth) VerifyEmailOTP(ctx context.Context, subject string, code string, maxAttempts int64) error {
+	result, err := verifyEmailOTPScript.Run(ctx, a.redis, []string{a.generateEmailOTPKey(subject)}, a.hashToken(subject+"."+code), maxAttempts).Int64()
+	if err != nil {
+		return err
+	}
+
+	if result != 1 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// AcquireEmailOTPCooldown reports how long until another passcode may be
+// sent for subject. When it is zero further ones are blocked for cooldown.
+func (a *auth) AcquireEmailOTPCooldown(ctx context.Context, subject string, cooldown time.Duration) (retryAfter time.Duration, err error) {
+	if cooldown <= 0 {
+		return 0, nil
+	}
+
+	cooldownKey := a.generateEmailOTPCooldownKey(subject)
+
+	acquired, err := a.redis.SetNX(ctx, cooldownKey, 1, cooldown).Result()
+	if err != nil || acquired {
+		return 0, err
+	}
+
+	retryAfter, err = a.redis.PTTL(ctx, cooldownKey).Result()
+	if err != nil {
+		return 0, err
+	}
+
+	if retryAfter <= 0 {
+		retryAfter = cooldown
+	}
+
+	return retryAfter, nil
+}
+
+func (a *auth) generateEmailOTPKey(subject string) string {
+	return fmt.Sprintf("email_otp.%s", a.hashToken(subject))
+}
+
+func (a *auth) generateEmailOTPCooldownKey(subject string) string {
+	return fmt.Sprintf("email_otp_cooldown.%s", a.hashToken(subject))
+}
+
+// generateNumericCode returns length random decimal digits.
+func generateNumericCode(length int) (string, error) {
+	code := make([]byte, length)
+	for i := range code {
+		n, err := rand.Int(rand.Reader, big.NewInt(10))
+		if err != nil {
+			return "", err
+		}
+
+		code[i] = byte('0' + n.Int64())
+	}
+
+	return string(code), nil
+}
@@ -0,0 +1,98 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+func (a *auth) StoreEmailVerificationCode(ctx context.Context, user model.User, expireDuration uint) (code string, err error) {
+	code, err = generateOpaqueToken(TokenPrefixEmailVerification)
+	if err != nil {
+		return "", err
+	}
+
+	emailVerification := model.EmailVerification{
+		UserID:   user.ID,
+		Email:    user.Email,
+		IssuedAt: time.Now(),
+	}
+	emailVerification.ExpiredAt = emailVerification.IssuedAt.Add(time.Duration(expireDuration) * time.Minute)
+
+	data, err := json.Marshal(emailVerification)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.redis.Set(ctx, a.generateEmailVerificationKey(code), data, time.Duration(expireDuration)*time.Minute).Err(); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ConsumeEmailVerificationCode deletes code and returns the user it was
+// issued for. Codes issued for an email the user no longer has are rejected.
+func (a *auth) ConsumeEmailVerificationCode(ctx context.Context, code string) (fetchedUser model.User, err error) {
+	if err = validateOpaqueToken(code, TokenPrefixEmailVerification); err != nil {
+		return model.User{}, err
+	}
+
+	data, err := a.redis.GetDel(ctx, a.generateEmailVerificationKey(code)).Result()
+	switch {
+	case err == redis.Nil:
+		return model.User{}, ErrEntryNotFound
+	case err != nil:
+		return model.User{}, err
+	}
+
+	var emailVerification model.EmailVerification
+	if err = json.Unmarshal([]byte(data), &emailVerification); err != nil {
+		return model.User{}, err
+	}
+
+	fetchedUser, err = a.FetchUser(ctx, emailVerification.UserID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if fetchedUser.Email != emailVerification.Email {
+		return model.User{}, ErrInvalidToken
+	}
+
+	return fetchedUser, nil
+}
+
+// AcquireEmailVerificationCooldown reports whether a verification may be sent
+// to userID now, and if so blocks further ones for cooldown.
+func (a *auth) AcquireEmailVerificationCooldown(ctx context.Context, userID uuid.UUID, cooldown time.Duration) (acquired bool, err error) {
+	if cooldown <= 0 {
+		return true, nil
+	}
+
+	return a.redis.SetNX(ctx, a.generateEmailVerificationCooldownKey(userID), 1, cooldown).Result()
+}
+
+func (a *auth) MarkEmailVerified(ctx context.Context, userID uuid.UUID) error {
+	_, err := a.pgx.Exec(
+		ctx,
+		`UPDATE users
+			SET email_verified_at = NOW()
+			WHERE id = $1 AND email_verified_at IS NULL`,
+		userID,
+	)
+
+	return err
+}
+
+func (a *auth) generateEmailVerificationKey(code string) string {
+	return fmt.Sprintf("email_verification.%s", a.hashToken(code))
+}
+
+func (a *auth) generateEmailVerificationCooldownKey(userID uuid.UUID) string {
+	return fmt.Sprintf("email_verification_cooldown.%s", userID)
+}
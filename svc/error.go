@@ -1,9 +1,178 @@
 package svc
 
-import "errors"
+import (
+	"context"
+	"errors"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"strconv"
+	"time"
+)
+
+// ErrorDomain is the domain of the ErrorInfo attached to reported errors.
+const ErrorDomain = "lamia_auth"
 
 var (
-	ErrUserExists        = errors.New("user already exists")
-	ErrUserDoesNotExists = errors.New("user doesn't exists")
-	ErrEntryNotFound     = errors.New("the provided entry could not be found")
+	ErrUserExists         = errors.New("user already exists")
+	ErrUserDoesNotExists  = errors.New("user doesn't exists")
+	ErrEntryNotFound      = errors.New("the provided entry could not be found")
+	ErrRefreshTokenUsed   = errors.New("refresh token has already been used")
+	ErrInvalidToken       = errors.New("the provided token is invalid")
+	ErrWrongPassword      = errors.New("the provided password is wrong")
+	ErrInvalidCredentials = errors.New("the provided email or password is wrong")
+	ErrEmailNotVerified   = errors.New("email address has not been verified")
+	ErrInvalidUUID        = errors.New("the provided id is not a valid uuid")
+	ErrAccountLocked      = errors.New("too many failed login attempts, try again later")
+	ErrRateLimited        = errors.New("too many requests, try again later")
+	ErrMFAAlreadyEnabled  = errors.New("two-factor authentication is already enabled")
+	ErrMFANotEnabled      = errors.New("two-factor authentication is not enabled")
+	ErrInvalidMFACode     = errors.New("the provided two-factor code is invalid")
+	ErrMFAMethodInvalid   = errors.New("the provided two-factor method is not available")
+	ErrInvalidPasskey     = errors.New("the provided passkey could not be verified")
+	ErrPasskeyExists      = errors.New("passkey is already registered")
+	ErrPasskeyCloned      = errors.New("passkey may have been cloned and was disabled")
+	ErrInvalidClient      = errors.New("the provided oauth client credentials are invalid")
+	ErrStepUpRequired     = errors.New("this action requires a recently stepped up session")
+	ErrInsufficientScope  = errors.New("the provided token does not allow this action")
+
+	ErrSigningKeyNotConfigured = errors.New("no jwt signing key is configured")
+	ErrSigningKeyNotFound      = errors.New("signing key could not be found")
+	ErrUnsupportedSigningKey   = errors.New("unsupported signing key")
 )
+
+// AccountLockedError is ErrAccountLocked along with how long the lock lasts.
+// It is reported as ResourceExhausted with the ACCOUNT_LOCKED reason and a
+// RetryInfo, so that clients can say when to try again.
+type AccountLockedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *AccountLockedError) Error() string {
+	return ErrAccountLocked.Error()
+}
+
+func (e *AccountLockedError) Unwrap() error {
+	return ErrAccountLocked
+}
+
+func (e *AccountLockedError) GRPCStatus() *status.Status {
+	return retryStatus(ErrAccountLocked.Error(), "ACCOUNT_LOCKED", e.RetryAfter)
+}
+
+// RateLimitedError is ErrRateLimited along with how long until the caller
+// may call again. It is reported as ResourceExhausted with the RATE_LIMITED
+// reason and a RetryInfo.
+type RateLimitedError struct {
+	RetryAfter time.Duration
+}
+
+func (e *RateLimitedError) Error() string {
+	return ErrRateLimited.Error()
+}
+
+func (e *RateLimitedError) Unwrap() error {
+	return ErrRateLimited
+}
+
+func (e *RateLimitedError) GRPCStatus() *status.Status {
+	return retryStatus(ErrRateLimited.Error(), "RATE_LIMITED", e.RetryAfter)
+}
+
+// RetryAfterSeconds rounds retryAfter up to whole seconds, the unit clients
+// are told to wait in.
+func RetryAfterSeconds(retryAfter time.Duration) int64 {
+	return int64((retryAfter + time.Second - 1) / time.Second)
+}
+
+func retryStatus(message string, reason string, retryAfter time.Duration) *status.Status {
+	seconds := RetryAfterSeconds(retryAfter)
+
+	st, err := status.New(codes.ResourceExhausted, message).WithDetails(
+		&errdetails.ErrorInfo{
+			Reason: reason,
+			Domain: ErrorDomain,
+			Metadata: map[string]string{
+				"retry_after_seconds": strconv.FormatInt(seconds, 10),
+			},
+		},
+		&errdetails.RetryInfo{
+			RetryDelay: durationpb.New(time.Duration(seconds) * time.Second),
+		},
+	)
+	if err != nil {
+		return status.New(codes.ResourceExhausted, message)
+	}
+
+	return st
+}
+
+type catalogueEntry struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+// errorCatalogue lists the errors clients are allowed to see together with
+// the code and the stable reason they are reported with. Anything else is an
+// internal error.
+var errorCatalogue = []catalogueEntry{
+	{err: ErrUserExists, code: codes.AlreadyExists, reason: "USER_EXISTS"},
+	{err: ErrUserDoesNotExists, code: codes.NotFound, reason: "USER_NOT_FOUND"},
+	{err: ErrEntryNotFound, code: codes.NotFound, reason: "ENTRY_NOT_FOUND"},
+	{err: ErrRefreshTokenUsed, code: codes.Unauthenticated, reason: "REFRESH_TOKEN_REUSED"},
+	{err: ErrInvalidToken, code: codes.Unauthenticated, reason: "INVALID_TOKEN"},
+	{err: ErrWrongPassword, code: codes.Unauthenticated, reason: "WRONG_PASSWORD"},
+	{err: ErrInvalidCredentials, code: codes.Unauthenticated, reason: "INVALID_CREDENTIALS"},
+	{err: ErrEmailNotVerified, code: codes.FailedPrecondition, reason: "EMAIL_NOT_VERIFIED"},
+	{err: ErrInvalidUUID, code: codes.InvalidArgument, reason: "INVALID_ID"},
+	{err: ErrMFAAlreadyEnabled, code: codes.FailedPrecondition, reason: "MFA_ALREADY_ENABLED"},
+	{err: ErrMFANotEnabled, code: codes.FailedPrecondition, reason: "MFA_NOT_ENABLED"},
+	{err: ErrInvalidMFACode, code: codes.Unauthenticated, reason: "INVALID_MFA_CODE"},
+	{err: ErrMFAMethodInvalid, code: codes.InvalidArgument, reason: "MFA_METHOD_INVALID"},
+	{err: ErrInvalidPasskey, code: codes.InvalidArgument, reason: "PASSKEY_INVALID"},
+	{err: ErrPasskeyExists, code: codes.AlreadyExists, reason: "PASSKEY_EXISTS"},
+	{err: ErrPasskeyCloned, code: codes.PermissionDenied, reason: "PASSKEY_CLONED"},
+	{err: ErrInvalidClient, code: codes.Unauthenticated, reason: "INVALID_CLIENT"},
+	{err: ErrStepUpRequired, code: codes.PermissionDenied, reason: "STEP_UP_REQUIRED"},
+	{err: ErrInsufficientScope, code: codes.PermissionDenied, reason: "INSUFFICIENT_SCOPE"},
+}
+
+const internalErrorMessage = "internal error"
+
+// ErrorStatus returns the status err is reported to clients with. Errors
+// that already carry a status keep it, catalogued errors get their code and
+// an ErrorInfo, and everything else is hidden behind Internal. known is false
+// in the last case so that the caller can log the original error.
+func ErrorStatus(err error) (st *status.Status, known bool) {
+	if st, ok := status.FromError(err); ok {
+		return st, true
+	}
+
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return status.FromContextError(err), true
+	}
+
+	for _, entry := range errorCatalogue {
+		if errors.Is(err, entry.err) {
+			return errorStatus(entry.code, entry.err.Error(), entry.reason), true
+		}
+	}
+
+	return errorStatus(codes.Internal, internalErrorMessage, "INTERNAL"), false
+}
+
+func errorStatus(code codes.Code, message string, reason string) *status.Status {
+	st := status.New(code, message)
+
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: ErrorDomain,
+	})
+	if err != nil {
+		return st
+	}
+
+	return detailed
+}
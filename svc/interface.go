@@ -3,7 +3,9 @@ package svc
 import (
 	"context"
 	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/webauthn"
 	"github.com/google/uuid"
+	"time"
 )
 
 type AuthDALInterface interface {
@@ -11,8 +13,93 @@ type AuthDALInterface interface {
 
 	FetchUser(ctx context.Context, userID uuid.UUID) (fetchedUser model.User, err error)
 	FetchUserByEmail(ctx context.Context, email string) (fetchedUser model.User, err error)
+	UpdatePasswordHash(ctx context.Context, userID uuid.UUID, passwordHash string) (err error)
+	UpdatePassword(ctx context.Context, userID uuid.UUID, passwordHash string) (err error)
+	MarkEmailVerified(ctx context.Context, userID uuid.UUID) (err error)
 
 	StoreToken(ctx context.Context, tokenDetail model.Token, expireDuration uint) (tokenString string, err error)
 	FetchToken(ctx context.Context, token string) (fetchedToken model.Token, err error)
+	TouchToken(ctx context.Context, token string, tokenDetail model.Token, interval time.Duration, idleTimeout time.Duration) (touchedToken model.Token, err error)
 	DeleteToken(ctx context.Context, token string)
+
+	StoreTokenFamily(ctx context.Context, tokenFamily model.TokenFamily, expireDuration uint) (storedTokenFamily model.TokenFamily, refreshToken string, err error)
+	FetchRefreshToken(ctx context.Context, refreshToken string) (fetchedRefreshToken model.RefreshToken, err error)
+	RotateRefreshToken(ctx context.Context, refreshToken string, clientID string, clientInfo model.ClientInfo, expireDuration uint) (tokenFamily model.TokenFamily, newRefreshToken string, err error)
+	RevokeTokenFamily(ctx context.Context, familyID uuid.UUID)
+	FetchTokenFamily(ctx context.Context, familyID uuid.UUID) (tokenFamily model.TokenFamily, err error)
+	ElevateTokenFamily(ctx context.Context, familyID uuid.UUID, duration time.Duration) (tokenFamily model.TokenFamily, err error)
+
+	FetchUserTokenFamilies(ctx context.Context, userID uuid.UUID) (tokenFamilies []model.TokenFamily, err error)
+	RevokeUserTokenFamilies(ctx context.Context, userID uuid.UUID, exceptFamilyID uuid.UUID) (revokedCount int, err error)
+
+	StorePasswordResetToken(ctx context.Context, user model.User, expireDuration uint) (resetToken string, err error)
+	FetchPasswordResetToken(ctx context.Context, resetToken string) (fetchedUser model.User, err error)
+	DeletePasswordResetToken(ctx context.Context, resetToken string) (err error)
+
+	StoreMagicLink(ctx context.Context, magicLink model.MagicLink, nonce string, expireDuration uint) (magicLinkToken string, err error)
+	FetchMagicLink(ctx context.Context, magicLinkToken string, nonce string) (magicLink model.MagicLink, err error)
+	DeleteMagicLink(ctx context.Context, magicLinkToken string) (err error)
+
+	FetchLoginLock(ctx context.Context, email string, ip string) (retryAfter time.Duration, err error)
+	RecordLoginFailure(ctx context.Context, email string, ip string, accountPolicy LoginThrottlePolicy, ipPolicy LoginThrottlePolicy) (err error)
+	ClearLoginFailures(ctx context.Context, email string) (err error)
+
+	StoreEmailVerificationCode(ctx context.Context, user model.User, expireDuration uint) (code string, err error)
+	ConsumeEmailVerificationCode(ctx context.Context, code string) (fetchedUser model.User, err error)
+	AcquireEmailVerificationCooldown(ctx context.Context, userID uuid.UUID, cooldown time.Duration) (acquired bool, err error)
+
+	StoreTOTPEnrollment(ctx context.Context, userID uuid.UUID, encryptedSecret []byte) (err error)
+	FetchTOTP(ctx context.Context, userID uuid.UUID) (fetchedTOTP model.TOTP, err error)
+	ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodes []string) (err error)
+	UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) (err error)
+	DeleteTOTP(ctx context.Context, userID uuid.UUID) (err error)
+	ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, recoveryCodes []string) (err error)
+	UseRecoveryCode(ctx context.Context, userID uuid.UUID, recoveryCode string) (err error)
+
+	StoreMFAChallenge(ctx context.Context, challenge model.MFAChallenge, expireDuration uint) (challengeToken string, err error)
+	FetchMFAChallenge(ctx context.Context, challengeToken string) (challenge model.MFAChallenge, err error)
+	DeleteMFAChallenge(ctx context.Context, challengeToken string) (err error)
+	RecordMFAChallengeFailure(ctx context.Context, challengeToken string, maxAttempts int64) (err error)
+
+	StoreEmailOTP(ctx context.Context, subject string, expireDuration uint) (code string, err error)
+	VerifyEmailOTP(ctx context.Context, subject string, code string, maxAttempts int64) (err error)
+	AcquireEmailOTPCooldown(ctx context.Context, subject string, cooldown time.Duration) (retryAfter time.Duration, err error)
+
+	StoreWebAuthnCredential(ctx context.Context, credential model.WebAuthnCredential) (storedCredential model.WebAuthnCredential, err error)
+	FetchWebAuthnCredential(ctx context.Context, credentialID []byte) (fetchedCredential model.WebAuthnCredential, err error)
+	FetchUserWebAuthnCredentials(ctx context.Context, userID uuid.UUID) (credentials []model.WebAuthnCredential, err error)
+	UseWebAuthnCredential(ctx context.Context, id uuid.UUID, signCount int64) (err error)
+	MarkWebAuthnCredentialCloned(ctx context.Context, id uuid.UUID) (err error)
+
+	StoreWebAuthnSession(ctx context.Context, subject string, session webauthn.Session, expireDuration time.Duration) (err error)
+	StorePasskeyLoginSession(ctx context.Context, session webauthn.Session, expireDuration time.Duration) (sessionToken string, err error)
+	ConsumeWebAuthnSession(ctx context.Context, subject string) (session webauthn.Session, err error)
+
+	StoreOAuthClient(ctx context.Context, client model.OAuthClient, confidential bool) (storedClient model.OAuthClient, clientSecret string, err error)
+	FetchOAuthClient(ctx context.Context, clientID uuid.UUID) (fetchedClient model.OAuthClient, err error)
+	AuthenticateOAuthClient(ctx context.Context, clientID uuid.UUID, clientSecret string) (client model.OAuthClient, err error)
+	StoreOAuthAuthorizationCode(ctx context.Context, authorizationCode model.OAuthAuthorizationCode, expireDuration time.Duration) (code string, err error)
+	ConsumeOAuthAuthorizationCode(ctx context.Context, code string) (authorizationCode model.OAuthAuthorizationCode, err error)
+	StoreOAuthConsent(ctx context.Context, consent model.OAuthConsent) (err error)
+	FetchOAuthConsent(ctx context.Context, userID uuid.UUID, clientID uuid.UUID) (consent model.OAuthConsent, err error)
+	StoreOAuthConsentChallenge(ctx context.Context, challenge model.OAuthConsentChallenge, expireDuration time.Duration) (challengeToken string, err error)
+	FetchOAuthConsentChallenge(ctx context.Context, challengeToken string) (challenge model.OAuthConsentChallenge, err error)
+	DeleteOAuthConsentChallenge(ctx context.Context, challengeToken string) (err error)
+}
+
+type JWTIssuerInterface interface {
+	Issue(ctx context.Context, tokenDetail model.Token, tokenID string) (tokenString string, err error)
+	Verify(ctx context.Context, tokenString string) (tokenID string, err error)
+	JWKS(ctx context.Context) (keys []model.JSONWebKey, err error)
+}
+
+type SigningKeyProviderInterface interface {
+	SigningKey(ctx context.Context) (key model.SigningKey, err error)
+	VerificationKeys(ctx context.Context) (keys []model.SigningKey, err error)
+}
+
+type SigningKeyStoreInterface interface {
+	SigningKeyProviderInterface
+
+	RotateSigningKeys(ctx context.Context, algorithm string, gracePeriod time.Duration) (err error)
 }
@@ -0,0 +1,87 @@
+package svc
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	JWTAlgorithmRS256 = "RS256"
+	JWTAlgorithmEdDSA = "EdDSA"
+)
+
+type jwtIssuer struct {
+	keys   SigningKeyProviderInterface
+	issuer string
+}
+
+func NewJWTIssuer(keys SigningKeyProviderInterface, issuer string) JWTIssuerInterface {
+	return &jwtIssuer{
+		keys:   keys,
+		issuer: issuer,
+	}
+}
+
+func (j *jwtIssuer) Issue(ctx context.Context, tokenDetail model.Token, tokenID string) (tokenString string, err error) {
+	key, err := j.keys.SigningKey(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Algorithm), jwt.RegisteredClaims{
+		Issuer:    j.issuer,
+		Subject:   tokenDetail.UserID.String(),
+		IssuedAt:  jwt.NewNumericDate(tokenDetail.IssuedAt),
+		ExpiresAt: jwt.NewNumericDate(tokenDetail.MaxExpiredAt),
+		ID:        tokenID,
+	})
+	token.Header["kid"] = key.KID
+
+	return token.SignedString(key.PrivateKey)
+}
+
+func (j *jwtIssuer) Verify(ctx context.Context, tokenString string) (tokenID string, err error) {
+	keys, err := j.keys.VerificationKeys(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods([]string{JWTAlgorithmRS256, JWTAlgorithmEdDSA}),
+	}
+	if j.issuer != "" {
+		options = append(options, jwt.WithIssuer(j.issuer))
+	}
+
+	claims := jwt.RegisteredClaims{}
+	if _, err = jwt.ParseWithClaims(tokenString, &claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+
+		for _, key := range keys {
+			if key.KID == kid && key.Algorithm == token.Method.Alg() {
+				return key.PrivateKey.Public(), nil
+			}
+		}
+
+		return nil, ErrSigningKeyNotFound
+	}, options...); err != nil {
+		return "", ErrInvalidToken
+	}
+
+	return claims.ID, nil
+}
+
+func (j *jwtIssuer) JWKS(ctx context.Context) ([]model.JSONWebKey, error) {
+	keys, err := j.keys.VerificationKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	jwks := make([]model.JSONWebKey, 0, len(keys))
+	for _, key := range keys {
+		jwks = append(jwks, PublicJSONWebKey(key))
+	}
+
+	return jwks, nil
+}
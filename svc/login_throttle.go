@@ -0,0 +1,115 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"github.com/redis/go-redis/v9"
+	"strings"
+	"time"
+)
+
+// LoginThrottlePolicy decides how long a login subject, an account or a
+// client IP, is locked after failed attempts. Failures are counted until
+// Window passes without one. From BackoffThreshold failures on, every failure
+// locks the subject for BackoffBase doubled per failure beyond the threshold.
+// From LockoutThreshold failures on it is locked for LockoutDuration.
+type LoginThrottlePolicy struct {
+	Window           time.Duration
+	BackoffBase      time.Duration
+	BackoffThreshold int64
+	LockoutThreshold int64
+	LockoutDuration  time.Duration
+}
+
+func (p LoginThrottlePolicy) lockDuration(failures int64) time.Duration {
+	switch {
+	case p.LockoutThreshold > 0 && failures >= p.LockoutThreshold:
+		return p.LockoutDuration
+	case p.BackoffThreshold > 0 && failures >= p.BackoffThreshold:
+		delay := p.BackoffBase << (failures - p.BackoffThreshold)
+		if delay <= 0 || delay > p.LockoutDuration {
+			return p.LockoutDuration
+		}
+
+		return delay
+	default:
+		return 0
+	}
+}
+
+// FetchLoginLock returns how long logins for email from ip stay locked, zero
+// when neither the account nor the IP is locked.
+func (a *auth) FetchLoginLock(ctx context.Context, email string, ip string) (retryAfter time.Duration, err error) {
+	for _, lockKey := range []string{a.generateAccountLoginLockKey(email), a.generateIPLoginLockKey(ip)} {
+		ttl, err := a.redis.PTTL(ctx, lockKey).Result()
+		if err != nil {
+			return 0, err
+		}
+
+		if ttl > retryAfter {
+			retryAfter = ttl
+		}
+	}
+
+	return retryAfter, nil
+}
+
+// RecordLoginFailure counts a failed login for email and for ip, locking
+// either when its policy says so.
+func (a *auth) RecordLoginFailure(ctx context.Context, email string, ip string, accountPolicy LoginThrottlePolicy, ipPolicy LoginThrottlePolicy) error {
+	if err := a.recordLoginFailure(ctx, a.generateAccountLoginFailuresKey(email), a.generateAccountLoginLockKey(email), accountPolicy); err != nil {
+		return err
+	}
+
+	if ip == "" {
+		return nil
+	}
+
+	return a.recordLoginFailure(ctx, a.generateIPLoginFailuresKey(ip), a.generateIPLoginLockKey(ip), ipPolicy)
+}
+
+// ClearLoginFailures unlocks the account of email and resets its failures.
+// The failures of client IPs are left alone, so that a single working
+// account cannot be used to reset them.
+func (a *auth) ClearLoginFailures(ctx context.Context, email string) error {
+	return a.redis.Del(ctx, a.generateAccountLoginFailuresKey(email), a.generateAccountLoginLockKey(email)).Err()
+}
+
+func (a *auth) recordLoginFailure(ctx context.Context, failuresKey string, lockKey string, policy LoginThrottlePolicy) error {
+	var incr *redis.IntCmd
+	if _, err := a.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, failuresKey)
+		pipe.Expire(ctx, failuresKey, policy.Window)
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	lockDuration := policy.lockDuration(incr.Val())
+	if lockDuration <= 0 {
+		return nil
+	}
+
+	return a.redis.Set(ctx, lockKey, incr.Val(), lockDuration).Err()
+}
+
+func (a *auth) generateAccountLoginFailuresKey(email string) string {
+	return fmt.Sprintf("login_failures.account.%s", a.hashToken(normalizeEmail(email)))
+}
+
+func (a *auth) generateAccountLoginLockKey(email string) string {
+	return fmt.Sprintf("login_lock.account.%s", a.hashToken(normalizeEmail(email)))
+}
+
+func (a *auth) generateIPLoginFailuresKey(ip string) string {
+	return fmt.Sprintf("login_failures.ip.%s", ip)
+}
+
+func (a *auth) generateIPLoginLockKey(ip string) string {
+	return fmt.Sprintf("login_lock.ip.%s", ip)
+}
+
+func normalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
@@ -0,0 +1,84 @@
+package svc
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// StoreMagicLink stores magicLink and returns the token of the link. A
+// non-empty nonce binds the link to the device that requested it.
+func (a *auth) StoreMagicLink(ctx context.Context, magicLink model.MagicLink, nonce string, expireDuration uint) (magicLinkToken string, err error) {
+	magicLinkToken, err = generateOpaqueToken(TokenPrefixMagicLink)
+	if err != nil {
+		return "", err
+	}
+
+	if nonce != "" {
+		magicLink.NonceHash = a.hashToken(nonce)
+	}
+
+	magicLink.IssuedAt = time.Now()
+	magicLink.ExpiredAt = magicLink.IssuedAt.Add(time.Duration(expireDuration) * time.Minute)
+
+	data, err := json.Marshal(magicLink)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.redis.Set(ctx, a.generateMagicLinkKey(magicLinkToken), data, time.Duration(expireDuration)*time.Minute).Err(); err != nil {
+		return "", err
+	}
+
+	return magicLinkToken, nil
+}
+
+// FetchMagicLink returns the link of magicLinkToken. Links bound to a device
+// are rejected unless nonce is the one it was requested with; they stay
+// usable from that device.
+func (a *auth) FetchMagicLink(ctx context.Context, magicLinkToken string, nonce string) (magicLink model.MagicLink, err error) {
+	if err = validateOpaqueToken(magicLinkToken, TokenPrefixMagicLink); err != nil {
+		return model.MagicLink{}, err
+	}
+
+	data, err := a.redis.Get(ctx, a.generateMagicLinkKey(magicLinkToken)).Result()
+	switch {
+	case err == redis.Nil:
+		return model.MagicLink{}, ErrEntryNotFound
+	case err != nil:
+		return model.MagicLink{}, err
+	}
+
+	if err = json.Unmarshal([]byte(data), &magicLink); err != nil {
+		return model.MagicLink{}, err
+	}
+
+	if magicLink.NonceHash != "" && !hmac.Equal([]byte(magicLink.NonceHash), []byte(a.hashToken(nonce))) {
+		return model.MagicLink{}, ErrInvalidToken
+	}
+
+	return magicLink, nil
+}
+
+// DeleteMagicLink consumes magicLinkToken. Only one of several concurrent
+// callers succeeds, the others get ErrEntryNotFound.
+func (a *auth) DeleteMagicLink(ctx context.Context, magicLinkToken string) error {
+	deleted, err := a.redis.Del(ctx, a.generateMagicLinkKey(magicLinkToken)).Result()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrEntryNotFound
+	}
+
+	return nil
+}
+
+func (a *auth) generateMagicLinkKey(magicLinkToken string) string {
+	return fmt.Sprintf("magic_link.%s", a.hashToken(magicLinkToken))
+}
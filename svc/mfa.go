@@ -0,0 +1,312 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/mfa"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// StoreTOTPEnrollment starts enrolling an authenticator app with
+// encryptedSecret, replacing an enrollment that was never confirmed.
+func (a *auth) StoreTOTPEnrollment(ctx context.Context, userID uuid.UUID, encryptedSecret []byte) error {
+	commandTag, err := a.pgx.Exec(
+		ctx,
+		`INSERT INTO user_totp (
+					user_id,
+					encrypted_secret
+			) VALUES (
+					$1, $2
+			) ON CONFLICT (user_id) DO UPDATE
+				SET encrypted_secret = EXCLUDED.encrypted_secret,
+					last_used_step = 0,
+					created_at = NOW()
+				WHERE user_totp.confirmed_at IS NULL`,
+		userID,
+		encryptedSecret,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return ErrMFAAlreadyEnabled
+	}
+
+	return nil
+}
+
+func (a *auth) FetchTOTP(ctx context.Context, userID uuid.UUID) (fetchedTOTP model.TOTP, err error) {
+	err = a.pgx.QueryRow(
+		ctx,
+		`SELECT user_id,
+					encrypted_secret,
+					last_used_step,
+					confirmed_at,
+					created_at
+			FROM user_totp
+			WHERE user_id = $1`,
+		userID,
+	).Scan(&fetchedTOTP.UserID, &fetchedTOTP.EncryptedSecret, &fetchedTOTP.LastUsedStep, &fetchedTOTP.ConfirmedAt, &fetchedTOTP.CreatedAt)
+	switch {
+	case err == pgx.ErrNoRows:
+		return model.TOTP{}, ErrMFANotEnabled
+	case err != nil:
+		return model.TOTP{}, err
+	}
+
+	return fetchedTOTP, nil
+}
+
+// ConfirmTOTP enables the enrolled authenticator app of userID after a first
+// code of the given step was accepted, and gives the user recoveryCodes.
+func (a *auth) ConfirmTOTP(ctx context.Context, userID uuid.UUID, step int64, recoveryCodes []string) (err error) {
+	tx, err := a.pgx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	commandTag, err := tx.Exec(
+		ctx,
+		`UPDATE user_totp
+			SET confirmed_at = NOW(),
+				last_used_step = $2
+			WHERE user_id = $1 AND confirmed_at IS NULL`,
+		userID,
+		step,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		err = ErrMFAAlreadyEnabled
+		return err
+	}
+
+	if err = a.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// UseTOTPStep accepts a code of step for userID once. Codes of the last
+// accepted step or an earlier one are rejected with ErrInvalidMFACode.
+func (a *auth) UseTOTPStep(ctx context.Context, userID uuid.UUID, step int64) error {
+	commandTag, err := a.pgx.Exec(
+		ctx,
+		`UPDATE user_totp
+			SET last_used_step = $2
+			WHERE user_id = $1 AND last_used_step < $2`,
+		userID,
+		step,
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+// DeleteTOTP disables the authenticator app of userID along with its
+// recovery codes.
+func (a *auth) DeleteTOTP(ctx context.Context, userID uuid.UUID) (err error) {
+	tx, err := a.pgx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(ctx, `DELETE FROM user_totp WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+// ReplaceRecoveryCodes invalidates the recovery codes of userID and stores
+// recoveryCodes instead.
+func (a *auth) ReplaceRecoveryCodes(ctx context.Context, userID uuid.UUID, recoveryCodes []string) (err error) {
+	tx, err := a.pgx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if err = a.replaceRecoveryCodes(ctx, tx, userID, recoveryCodes); err != nil {
+		return err
+	}
+
+	return tx.Commit(ctx)
+}
+
+func (a *auth) replaceRecoveryCodes(ctx context.Context, tx pgx.Tx, userID uuid.UUID, recoveryCodes []string) error {
+	if _, err := tx.Exec(ctx, `DELETE FROM mfa_recovery_codes WHERE user_id = $1`, userID); err != nil {
+		return err
+	}
+
+	for _, recoveryCode := range recoveryCodes {
+		if _, err := tx.Exec(
+			ctx,
+			`INSERT INTO mfa_recovery_codes (
+						user_id,
+						code_hash
+				) VALUES (
+						$1, $2
+				)`,
+			userID,
+			a.hashToken(mfa.NormalizeRecoveryCode(recoveryCode)),
+		); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode spends recoveryCode of userID, ignoring how it was typed.
+// Unknown and spent codes are rejected with ErrInvalidMFACode.
+func (a *auth) UseRecoveryCode(ctx context.Context, userID uuid.UUID, recoveryCode string) error {
+	commandTag, err := a.pgx.Exec(
+		ctx,
+		`UPDATE mfa_recovery_codes
+			SET used_at = NOW()
+			WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`,
+		userID,
+		a.hashToken(mfa.NormalizeRecoveryCode(recoveryCode)),
+	)
+	if err != nil {
+		return err
+	}
+
+	if commandTag.RowsAffected() == 0 {
+		return ErrInvalidMFACode
+	}
+
+	return nil
+}
+
+func (a *auth) StoreMFAChallenge(ctx context.Context, challenge model.MFAChallenge, expireDuration uint) (challengeToken string, err error) {
+	challengeToken, err = generateOpaqueToken(TokenPrefixMFAChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	challenge.IssuedAt = time.Now()
+	challenge.ExpiredAt = challenge.IssuedAt.Add(time.Duration(expireDuration) * time.Minute)
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.redis.Set(ctx, a.generateMFAChallengeKey(challengeToken), data, time.Duration(expireDuration)*time.Minute).Err(); err != nil {
+		return "", err
+	}
+
+	return challengeToken, nil
+}
+
+func (a *auth) FetchMFAChallenge(ctx context.Context, challengeToken string) (challenge model.MFAChallenge, err error) {
+	if err = validateOpaqueToken(challengeToken, TokenPrefixMFAChallenge); err != nil {
+		return model.MFAChallenge{}, err
+	}
+
+	data, err := a.redis.Get(ctx, a.generateMFAChallengeKey(challengeToken)).Result()
+	switch {
+	case err == redis.Nil:
+		return model.MFAChallenge{}, ErrEntryNotFound
+	case err != nil:
+		return model.MFAChallenge{}, err
+	}
+
+	if err = json.Unmarshal([]byte(data), &challenge); err != nil {
+		return model.MFAChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+// DeleteMFAChallenge consumes challengeToken. Only one of several concurrent
+// callers succeeds, the others get ErrEntryNotFound.
+func (a *auth) DeleteMFAChallenge(ctx context.Context, challengeToken string) error {
+	deleted, err := a.redis.Del(ctx, a.generateMFAChallengeKey(challengeToken)).Result()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrEntryNotFound
+	}
+
+	return a.redis.Del(ctx, a.generateMFAChallengeAttemptsKey(challengeToken)).Err()
+}
+
+// RecordMFAChallengeFailure counts a wrong code for challengeToken and
+// drops the challenge once maxAttempts codes were wrong.
+func (a *auth) RecordMFAChallengeFailure(ctx context.Context, challengeToken string, maxAttempts int64) error {
+	attemptsKey := a.generateMFAChallengeAttemptsKey(challengeToken)
+
+	ttl, err := a.redis.PTTL(ctx, a.generateMFAChallengeKey(challengeToken)).Result()
+	if err != nil {
+		return err
+	}
+
+	if ttl <= 0 {
+		return nil
+	}
+
+	var incr *redis.IntCmd
+	if _, err = a.redis.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+		incr = pipe.Incr(ctx, attemptsKey)
+		pipe.PExpire(ctx, attemptsKey, ttl)
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	if maxAttempts > 0 && incr.Val() >= maxAttempts {
+		return a.redis.Del(ctx, a.generateMFAChallengeKey(challengeToken), attemptsKey).Err()
+	}
+
+	return nil
+}
+
+func (a *auth) generateMFAChallengeKey(challengeToken string) string {
+	return fmt.Sprintf("mfa_challenge.%s", a.hashToken(challengeToken))
+}
+
+func (a *auth) generateMFAChallengeAttemptsKey(challengeToken string) string {
+	return fmt.Sprintf("mfa_challenge_attempts.%s", a.hashToken(challengeToken))
+}
@@ -0,0 +1,251 @@
+package svc
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// StoreOAuthClient registers client. Confidential clients get a secret,
+// which is returned once and only stored hashed.
+func (a *auth) StoreOAuthClient(ctx context.Context, client model.OAuthClient, confidential bool) (storedClient model.OAuthClient, clientSecret string, err error) {
+	client.SecretHash = ""
+	if confidential {
+		clientSecret, err = generateOpaqueToken(TokenPrefixOAuthClientSecret)
+		if err != nil {
+			return model.OAuthClient{}, "", err
+		}
+
+		client.SecretHash = a.hashToken(clientSecret)
+	}
+
+	err = a.pgx.QueryRow(
+		ctx,
+		`INSERT INTO oauth_clients (
+					name,
+					secret_hash,
+					redirect_uris,
+					grant_types,
+					scopes,
+					first_party
+			) VALUES (
+					$1, NULLIF($2, ''), $3, $4, $5, $6
+			) RETURNING id, created_at`,
+		client.Name,
+		client.SecretHash,
+		client.RedirectURIs,
+		client.GrantTypes,
+		client.Scopes,
+		client.FirstParty,
+	).Scan(&client.ID, &client.CreatedAt)
+	if err != nil {
+		return model.OAuthClient{}, "", err
+	}
+
+	return client, clientSecret, nil
+}
+
+func (a *auth) FetchOAuthClient(ctx context.Context, clientID uuid.UUID) (fetchedClient model.OAuthClient, err error) {
+	err = a.pgx.QueryRow(
+		ctx,
+		`SELECT id,
+					name,
+					COALESCE(secret_hash, ''),
+					redirect_uris,
+					grant_types,
+					scopes,
+					first_party,
+					created_at
+			FROM oauth_clients
+			WHERE id = $1`,
+		clientID,
+	).Scan(&fetchedClient.ID, &fetchedClient.Name, &fetchedClient.SecretHash, &fetchedClient.RedirectURIs, &fetchedClient.GrantTypes, &fetchedClient.Scopes, &fetchedClient.FirstParty, &fetchedClient.CreatedAt)
+	switch {
+	case err == pgx.ErrNoRows:
+		return model.OAuthClient{}, ErrEntryNotFound
+	case err != nil:
+		return model.OAuthClient{}, err
+	}
+
+	return fetchedClient, nil
+}
+
+// AuthenticateOAuthClient returns the confidential client clientID when
+// clientSecret is its secret, ErrInvalidClient otherwise.
+func (a *auth) AuthenticateOAuthClient(ctx context.Context, clientID uuid.UUID, clientSecret string) (client model.OAuthClient, err error) {
+	if err = validateOpaqueToken(clientSecret, TokenPrefixOAuthClientSecret); err != nil {
+		return model.OAuthClient{}, ErrInvalidClient
+	}
+
+	client, err = a.FetchOAuthClient(ctx, clientID)
+	switch {
+	case err == ErrEntryNotFound:
+		return model.OAuthClient{}, ErrInvalidClient
+	case err != nil:
+		return model.OAuthClient{}, err
+	}
+
+	if !client.Confidential() || !hmac.Equal([]byte(client.SecretHash), []byte(a.hashToken(clientSecret))) {
+		return model.OAuthClient{}, ErrInvalidClient
+	}
+
+	return client, nil
+}
+
+func (a *auth) StoreOAuthAuthorizationCode(ctx context.Context, authorizationCode model.OAuthAuthorizationCode, expireDuration time.Duration) (code string, err error) {
+	code, err = generateOpaqueToken(TokenPrefixAuthorizationCode)
+	if err != nil {
+		return "", err
+	}
+
+	authorizationCode.IssuedAt = time.Now()
+	authorizationCode.ExpiredAt = authorizationCode.IssuedAt.Add(expireDuration)
+
+	data, err := json.Marshal(authorizationCode)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.redis.Set(ctx, a.generateOAuthAuthorizationCodeKey(code), data, expireDuration).Err(); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// ConsumeOAuthAuthorizationCode returns the grant of code and drops it, so
+// that a code is exchanged at most once.
+func (a *auth) ConsumeOAuthAuthorizationCode(ctx context.Context, code string) (authorizationCode model.OAuthAuthorizationCode, err error) {
+	if err = validateOpaqueToken(code, TokenPrefixAuthorizationCode); err != nil {
+		return model.OAuthAuthorizationCode{}, err
+	}
+
+	data, err := a.redis.GetDel(ctx, a.generateOAuthAuthorizationCodeKey(code)).Result()
+	switch {
+	case err == redis.Nil:
+		return model.OAuthAuthorizationCode{}, ErrEntryNotFound
+	case err != nil:
+		return model.OAuthAuthorizationCode{}, err
+	}
+
+	if err = json.Unmarshal([]byte(data), &authorizationCode); err != nil {
+		return model.OAuthAuthorizationCode{}, err
+	}
+
+	return authorizationCode, nil
+}
+
+// StoreOAuthConsent records that the user of consent allowed its client
+// access to its scopes, on top of what they allowed before.
+func (a *auth) StoreOAuthConsent(ctx context.Context, consent model.OAuthConsent) error {
+	_, err := a.pgx.Exec(
+		ctx,
+		`INSERT INTO oauth_consents (
+					user_id,
+					client_id,
+					scopes
+			) VALUES (
+					$1, $2, $3
+			) ON CONFLICT (user_id, client_id) DO UPDATE
+				SET scopes = ARRAY(SELECT DISTINCT UNNEST(oauth_consents.scopes || EXCLUDED.scopes)),
+					updated_at = NOW()`,
+		consent.UserID,
+		consent.ClientID,
+		consent.Scopes,
+	)
+
+	return err
+}
+
+func (a *auth) FetchOAuthConsent(ctx context.Context, userID uuid.UUID, clientID uuid.UUID) (consent model.OAuthConsent, err error) {
+	err = a.pgx.QueryRow(
+		ctx,
+		`SELECT user_id,
+					client_id,
+					scopes,
+					created_at,
+					updated_at
+			FROM oauth_consents
+			WHERE user_id = $1 AND client_id = $2`,
+		userID,
+		clientID,
+	).Scan(&consent.UserID, &consent.ClientID, &consent.Scopes, &consent.CreatedAt, &consent.UpdatedAt)
+	switch {
+	case err == pgx.ErrNoRows:
+		return model.OAuthConsent{}, ErrEntryNotFound
+	case err != nil:
+		return model.OAuthConsent{}, err
+	}
+
+	return consent, nil
+}
+
+func (a *auth) StoreOAuthConsentChallenge(ctx context.Context, challenge model.OAuthConsentChallenge, expireDuration time.Duration) (challengeToken string, err error) {
+	challengeToken, err = generateOpaqueToken(TokenPrefixConsentChallenge)
+	if err != nil {
+		return "", err
+	}
+
+	challenge.IssuedAt = time.Now()
+	challenge.ExpiredAt = challenge.IssuedAt.Add(expireDuration)
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.redis.Set(ctx, a.generateOAuthConsentChallengeKey(challengeToken), data, expireDuration).Err(); err != nil {
+		return "", err
+	}
+
+	return challengeToken, nil
+}
+
+func (a *auth) FetchOAuthConsentChallenge(ctx context.Context, challengeToken string) (challenge model.OAuthConsentChallenge, err error) {
+	if err = validateOpaqueToken(challengeToken, TokenPrefixConsentChallenge); err != nil {
+		return model.OAuthConsentChallenge{}, err
+	}
+
+	data, err := a.redis.Get(ctx, a.generateOAuthConsentChallengeKey(challengeToken)).Result()
+	switch {
+	case err == redis.Nil:
+		return model.OAuthConsentChallenge{}, ErrEntryNotFound
+	case err != nil:
+		return model.OAuthConsentChallenge{}, err
+	}
+
+	if err = json.Unmarshal([]byte(data), &challenge); err != nil {
+		return model.OAuthConsentChallenge{}, err
+	}
+
+	return challenge, nil
+}
+
+// DeleteOAuthConsentChallenge consumes challengeToken. Only one of several
+// concurrent callers succeeds, the others get ErrEntryNotFound.
+func (a *auth) DeleteOAuthConsentChallenge(ctx context.Context, challengeToken string) error {
+	deleted, err := a.redis.Del(ctx, a.generateOAuthConsentChallengeKey(challengeToken)).Result()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrEntryNotFound
+	}
+
+	return nil
+}
+
+func (a *auth) generateOAuthAuthorizationCodeKey(code string) string {
+	return fmt.Sprintf("oauth_authorization_code.%s", a.hashToken(code))
+}
+
+func (a *auth) generateOAuthConsentChallengeKey(challengeToken string) string {
+	return fmt.Sprintf("oauth_consent_challenge.%s", a.hashToken(challengeToken))
+}
@@ -0,0 +1,91 @@
+package svc
+
+import (
+	"context"
+	"crypto/hmac"
+	"encoding/json"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+func (a *auth) StorePasswordResetToken(ctx context.Context, user model.User, expireDuration uint) (resetToken string, err error) {
+	resetToken, err = generateOpaqueToken(TokenPrefixPasswordReset)
+	if err != nil {
+		return "", err
+	}
+
+	passwordReset := model.PasswordReset{
+		UserID:              user.ID,
+		PasswordFingerprint: a.passwordFingerprint(user.Password),
+		IssuedAt:            time.Now(),
+	}
+	passwordReset.ExpiredAt = passwordReset.IssuedAt.Add(time.Duration(expireDuration) * time.Minute)
+
+	data, err := json.Marshal(passwordReset)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.redis.Set(ctx, a.generatePasswordResetKey(resetToken), data, time.Duration(expireDuration)*time.Minute).Err(); err != nil {
+		return "", err
+	}
+
+	return resetToken, nil
+}
+
+// FetchPasswordResetToken returns the user resetToken was issued for. Tokens
+// issued before the last password change are rejected.
+func (a *auth) FetchPasswordResetToken(ctx context.Context, resetToken string) (fetchedUser model.User, err error) {
+	if err = validateOpaqueToken(resetToken, TokenPrefixPasswordReset); err != nil {
+		return model.User{}, err
+	}
+
+	data, err := a.redis.Get(ctx, a.generatePasswordResetKey(resetToken)).Result()
+	switch {
+	case err == redis.Nil:
+		return model.User{}, ErrEntryNotFound
+	case err != nil:
+		return model.User{}, err
+	}
+
+	var passwordReset model.PasswordReset
+	if err = json.Unmarshal([]byte(data), &passwordReset); err != nil {
+		return model.User{}, err
+	}
+
+	fetchedUser, err = a.FetchUser(ctx, passwordReset.UserID)
+	if err != nil {
+		return model.User{}, err
+	}
+
+	if !hmac.Equal([]byte(passwordReset.PasswordFingerprint), []byte(a.passwordFingerprint(fetchedUser.Password))) {
+		return model.User{}, ErrInvalidToken
+	}
+
+	return fetchedUser, nil
+}
+
+// DeletePasswordResetToken consumes resetToken. Only one of several
+// concurrent callers succeeds, the others get ErrEntryNotFound.
+func (a *auth) DeletePasswordResetToken(ctx context.Context, resetToken string) error {
+	deleted, err := a.redis.Del(ctx, a.generatePasswordResetKey(resetToken)).Result()
+	if err != nil {
+		return err
+	}
+
+	if deleted == 0 {
+		return ErrEntryNotFound
+	}
+
+	return nil
+}
+
+func (a *auth) passwordFingerprint(passwordHash string) string {
+	return a.hashToken(passwordHash)
+}
+
+func (a *auth) generatePasswordResetKey(resetToken string) string {
+	return fmt.Sprintf("password_reset.%s", a.hashToken(resetToken))
+}
@@ -0,0 +1,173 @@
+package svc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/model"
+	"math/big"
+	"os"
+)
+
+type staticSigningKeyProvider struct {
+	key model.SigningKey
+}
+
+// NewStaticSigningKeyProvider serves a single signing key loaded from
+// privateKeyPath. Keys are never derived from a shared secret: anyone who
+// could guess it could mint tokens that verify against the published keys.
+func NewStaticSigningKeyProvider(algorithm, privateKeyPath string) (SigningKeyProviderInterface, error) {
+	if privateKeyPath == "" {
+		return nil, ErrSigningKeyNotConfigured
+	}
+
+	privateKey, err := loadPrivateKey(privateKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := NewSigningKey(algorithm, privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Status = model.SigningKeyStatusActive
+
+	return &staticSigningKeyProvider{
+		key: key,
+	}, nil
+}
+
+func (s *staticSigningKeyProvider) SigningKey(ctx context.Context) (model.SigningKey, error) {
+	return s.key, nil
+}
+
+func (s *staticSigningKeyProvider) VerificationKeys(ctx context.Context) ([]model.SigningKey, error) {
+	return []model.SigningKey{s.key}, nil
+}
+
+// NewSigningKey checks that privateKey can be used with algorithm and names it
+// after its RFC 7638 thumbprint.
+func NewSigningKey(algorithm string, privateKey crypto.Signer) (model.SigningKey, error) {
+	switch privateKey.(type) {
+	case *rsa.PrivateKey:
+		if algorithm != JWTAlgorithmRS256 {
+			return model.SigningKey{}, fmt.Errorf("%w: rsa key can't be used with %s", ErrUnsupportedSigningKey, algorithm)
+		}
+	case ed25519.PrivateKey:
+		if algorithm != JWTAlgorithmEdDSA {
+			return model.SigningKey{}, fmt.Errorf("%w: ed25519 key can't be used with %s", ErrUnsupportedSigningKey, algorithm)
+		}
+	default:
+		return model.SigningKey{}, fmt.Errorf("%w: %T", ErrUnsupportedSigningKey, privateKey)
+	}
+
+	key := model.SigningKey{
+		Algorithm:  algorithm,
+		PrivateKey: privateKey,
+	}
+
+	thumbprint, err := json.Marshal(jwkThumbprintMembers(PublicJSONWebKey(key)))
+	if err != nil {
+		return model.SigningKey{}, err
+	}
+
+	digest := sha256.Sum256(thumbprint)
+	key.KID = base64.RawURLEncoding.EncodeToString(digest[:])
+
+	return key, nil
+}
+
+// PublicJSONWebKey describes the public half of key as a JWK.
+func PublicJSONWebKey(key model.SigningKey) model.JSONWebKey {
+	jwk := model.JSONWebKey{
+		KeyID:     key.KID,
+		Use:       "sig",
+		Algorithm: key.Algorithm,
+	}
+
+	switch publicKey := key.PrivateKey.Public().(type) {
+	case *rsa.PublicKey:
+		jwk.KeyType = "RSA"
+		jwk.N = base64.RawURLEncoding.EncodeToString(publicKey.N.Bytes())
+		jwk.E = base64.RawURLEncoding.EncodeToString(big.NewInt(int64(publicKey.E)).Bytes())
+	case ed25519.PublicKey:
+		jwk.KeyType = "OKP"
+		jwk.Curve = "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+
+	return jwk
+}
+
+// jwkThumbprintMembers keeps the required members of jwk; encoding/json sorts
+// map keys, which gives the canonical form RFC 7638 hashes.
+func jwkThumbprintMembers(jwk model.JSONWebKey) map[string]string {
+	switch jwk.KeyType {
+	case "RSA":
+		return map[string]string{"e": jwk.E, "kty": jwk.KeyType, "n": jwk.N}
+	default:
+		return map[string]string{"crv": jwk.Curve, "kty": jwk.KeyType, "x": jwk.X}
+	}
+}
+
+func loadPrivateKey(path string) (crypto.Signer, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	return parsePrivateKey(data)
+}
+
+func parsePrivateKey(data []byte) (crypto.Signer, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%w: key is not pem encoded", ErrUnsupportedSigningKey)
+	}
+
+	if privateKey, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return privateKey, nil
+	}
+
+	privateKey, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, ok := privateKey.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("%w: %T", ErrUnsupportedSigningKey, privateKey)
+	}
+
+	return signer, nil
+}
+
+func encodePrivateKey(privateKey crypto.Signer) (string, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})), nil
+}
+
+func generatePrivateKey(algorithm string) (crypto.Signer, error) {
+	switch algorithm {
+	case JWTAlgorithmRS256:
+		return rsa.GenerateKey(rand.Reader, 2048)
+	case JWTAlgorithmEdDSA:
+		_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		return privateKey, err
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedSigningKey, algorithm)
+	}
+}
@@ -0,0 +1,262 @@
+package svc
+
+import (
+	"context"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_shared/go/log"
+	"sync"
+	"time"
+)
+
+type signingKeyStore struct {
+	pgx           PgxConn
+	cacheDuration time.Duration
+
+	mu        sync.RWMutex
+	keys      []model.SigningKey
+	fetchedAt time.Time
+}
+
+// NewSigningKeyStore keeps signing keys in Postgres. Keys are cached for
+// cacheDuration so that rotations made by another process are picked up
+// without a query per token.
+func NewSigningKeyStore(pgx PgxConn, cacheDuration time.Duration) SigningKeyStoreInterface {
+	return &signingKeyStore{
+		pgx:           pgx,
+		cacheDuration: cacheDuration,
+	}
+}
+
+func (s *signingKeyStore) SigningKey(ctx context.Context) (model.SigningKey, error) {
+	keys, err := s.cachedKeys(ctx)
+	if err != nil {
+		return model.SigningKey{}, err
+	}
+
+	for _, key := range keys {
+		if key.Status == model.SigningKeyStatusActive {
+			return key, nil
+		}
+	}
+
+	return model.SigningKey{}, ErrSigningKeyNotFound
+}
+
+func (s *signingKeyStore) VerificationKeys(ctx context.Context) ([]model.SigningKey, error) {
+	keys, err := s.cachedKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	verificationKeys := make([]model.SigningKey, 0, len(keys))
+	for _, key := range keys {
+		if key.RetireAt != nil && !key.RetireAt.After(now) {
+			continue
+		}
+
+		verificationKeys = append(verificationKeys, key)
+	}
+
+	return verificationKeys, nil
+}
+
+// RotateSigningKeys promotes the next key to active and keeps the previously
+// active key around for verification until gracePeriod has passed. On an
+// empty store it bootstraps an active and a next key.
+func (s *signingKeyStore) RotateSigningKeys(ctx context.Context, algorithm string, gracePeriod time.Duration) (err error) {
+	tx, err := s.pgx.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		if err != nil {
+			tx.Rollback(ctx)
+		}
+	}()
+
+	if _, err = tx.Exec(ctx, `LOCK TABLE signing_keys IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(
+		ctx,
+		`UPDATE signing_keys
+			SET status = $1
+			WHERE status = $2
+			  AND retire_at <= NOW()`,
+		model.SigningKeyStatusRetired,
+		model.SigningKeyStatusPrevious,
+	); err != nil {
+		return err
+	}
+
+	var hasActive, hasNext bool
+	if err = tx.QueryRow(
+		ctx,
+		`SELECT EXISTS(SELECT 1 FROM signing_keys WHERE status = $1),
+				EXISTS(SELECT 1 FROM signing_keys WHERE status = $2)`,
+		model.SigningKeyStatusActive,
+		model.SigningKeyStatusNext,
+	).Scan(&hasActive, &hasNext); err != nil {
+		return err
+	}
+
+	if hasActive && hasNext {
+		if _, err = tx.Exec(
+			ctx,
+			`UPDATE signing_keys
+				SET status = $1,
+					retire_at = $2
+				WHERE status = $3`,
+			model.SigningKeyStatusPrevious,
+			time.Now().Add(gracePeriod),
+			model.SigningKeyStatusActive,
+		); err != nil {
+			return err
+		}
+
+		hasActive = false
+	}
+
+	if !hasActive {
+		if hasNext {
+			_, err = tx.Exec(
+				ctx,
+				`UPDATE signing_keys
+					SET status = $1,
+						activated_at = NOW()
+					WHERE status = $2`,
+				model.SigningKeyStatusActive,
+				model.SigningKeyStatusNext,
+			)
+		} else {
+			err = s.insertSigningKey(ctx, tx, algorithm, model.SigningKeyStatusActive)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	if err = s.insertSigningKey(ctx, tx, algorithm, model.SigningKeyStatusNext); err != nil {
+		return err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.keys = nil
+	s.mu.Unlock()
+
+	return nil
+}
+
+func (s *signingKeyStore) insertSigningKey(ctx context.Context, tx PgxConn, algorithm string, status string) error {
+	privateKey, err := generatePrivateKey(algorithm)
+	if err != nil {
+		return err
+	}
+
+	key, err := NewSigningKey(algorithm, privateKey)
+	if err != nil {
+		return err
+	}
+
+	encodedKey, err := encodePrivateKey(privateKey)
+	if err != nil {
+		return err
+	}
+
+	var activatedAt *time.Time
+	if status == model.SigningKeyStatusActive {
+		now := time.Now()
+		activatedAt = &now
+	}
+
+	_, err = tx.Exec(
+		ctx,
+		`INSERT INTO signing_keys (
+					kid,
+					algorithm,
+					private_key,
+					status,
+					activated_at
+			) VALUES (
+					$1, $2, $3, $4, $5
+			)`,
+		key.KID,
+		key.Algorithm,
+		encodedKey,
+		status,
+		activatedAt,
+	)
+
+	return err
+}
+
+func (s *signingKeyStore) cachedKeys(ctx context.Context) ([]model.SigningKey, error) {
+	s.mu.RLock()
+	if s.keys != nil && time.Since(s.fetchedAt) < s.cacheDuration {
+		defer s.mu.RUnlock()
+		return s.keys, nil
+	}
+	s.mu.RUnlock()
+
+	keys, err := s.fetchSigningKeys(ctx)
+	if err != nil {
+		log.WithError(err).Errorf(ctx, "error in fetch signing keys")
+		return nil, err
+	}
+
+	s.mu.Lock()
+	s.keys = keys
+	s.fetchedAt = time.Now()
+	s.mu.Unlock()
+
+	return keys, nil
+}
+
+func (s *signingKeyStore) fetchSigningKeys(ctx context.Context) ([]model.SigningKey, error) {
+	rows, err := s.pgx.Query(
+		ctx,
+		`SELECT kid,
+					algorithm,
+					private_key,
+					status,
+					created_at,
+					activated_at,
+					retire_at
+			FROM signing_keys
+			WHERE status <> $1`,
+		model.SigningKeyStatusRetired,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := make([]model.SigningKey, 0)
+	for rows.Next() {
+		var (
+			key        model.SigningKey
+			encodedKey string
+		)
+
+		if err = rows.Scan(&key.KID, &key.Algorithm, &encodedKey, &key.Status, &key.CreatedAt, &key.ActivatedAt, &key.RetireAt); err != nil {
+			return nil, err
+		}
+
+		if key.PrivateKey, err = parsePrivateKey([]byte(encodedKey)); err != nil {
+			return nil, err
+		}
+
+		keys = append(keys, key)
+	}
+
+	return keys, rows.Err()
+}
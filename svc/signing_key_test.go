@@ -0,0 +1,49 @@
+package svc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStaticSigningKeyProviderRequiresKeyFile(t *testing.T) {
+	for _, algorithm := range []string{JWTAlgorithmEdDSA, JWTAlgorithmRS256} {
+		if _, err := NewStaticSigningKeyProvider(algorithm, ""); err != ErrSigningKeyNotConfigured {
+			t.Fatalf("%s without a key file: err = %v, want %v", algorithm, err, ErrSigningKeyNotConfigured)
+		}
+	}
+}
+
+func TestStaticSigningKeyProviderLoadsKeyFile(t *testing.T) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encodedKey, err := encodePrivateKey(privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	privateKeyPath := filepath.Join(t.TempDir(), "jwt.pem")
+	if err = os.WriteFile(privateKeyPath, []byte(encodedKey), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := NewStaticSigningKeyProvider(JWTAlgorithmEdDSA, privateKeyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := keys.SigningKey(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !privateKey.Equal(key.PrivateKey) {
+		t.Fatal("signing key differs from the key file")
+	}
+}
@@ -0,0 +1,82 @@
+package svc
+
+import (
+	"crypto/rand"
+	"hash/crc32"
+	"math/big"
+	"strings"
+)
+
+// Opaque tokens look like lamia_at_<random>_<checksum>. The prefix tells what
+// kind of token leaked when one shows up in logs or a secret scanner, and the
+// CRC32 checksum lets malformed tokens be rejected without a Redis lookup.
+const (
+	TokenPrefixAccess  = "lamia_at_"
+	TokenPrefixRefresh = "lamia_rt_"
+	TokenPrefixAPI     = "lamia_api_"
+
+	TokenPrefixPasswordReset     = "lamia_pr_"
+	TokenPrefixEmailVerification = "lamia_ev_"
+	TokenPrefixMFAChallenge      = "lamia_mfa_"
+	TokenPrefixMagicLink         = "lamia_ml_"
+	TokenPrefixPasskeyLogin      = "lamia_pk_"
+	TokenPrefixAuthorizationCode = "lamia_ac_"
+	TokenPrefixOAuthClientSecret = "lamia_cs_"
+	TokenPrefixConsentChallenge  = "lamia_cc_"
+)
+
+const (
+	base62Alphabet      = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	tokenRandomLength   = 43
+	tokenChecksumLength = 6
+)
+
+// generateOpaqueToken returns a new token of the kind given by prefix with
+// about 256 bits of randomness.
+func generateOpaqueToken(prefix string) (string, error) {
+	random := make([]byte, tokenRandomLength)
+	alphabetSize := big.NewInt(int64(len(base62Alphabet)))
+
+	for i := range random {
+		n, err := rand.Int(rand.Reader, alphabetSize)
+		if err != nil {
+			return "", err
+		}
+
+		random[i] = base62Alphabet[n.Int64()]
+	}
+
+	return prefix + string(random) + "_" + tokenChecksum(prefix, string(random)), nil
+}
+
+// validateOpaqueToken checks that token is well formed and of the kind given
+// by prefix.
+func validateOpaqueToken(token string, prefix string) error {
+	body, ok := strings.CutPrefix(token, prefix)
+	if !ok || len(body) != tokenRandomLength+1+tokenChecksumLength {
+		return ErrInvalidToken
+	}
+
+	random, checksum, ok := strings.Cut(body, "_")
+	if !ok || len(random) != tokenRandomLength || strings.Trim(random, base62Alphabet) != "" {
+		return ErrInvalidToken
+	}
+
+	if checksum != tokenChecksum(prefix, random) {
+		return ErrInvalidToken
+	}
+
+	return nil
+}
+
+func tokenChecksum(prefix string, random string) string {
+	checksum := uint64(crc32.ChecksumIEEE([]byte(prefix + random)))
+
+	encoded := make([]byte, tokenChecksumLength)
+	for i := tokenChecksumLength - 1; i >= 0; i-- {
+		encoded[i] = base62Alphabet[checksum%62]
+		checksum /= 62
+	}
+
+	return string(encoded)
+}
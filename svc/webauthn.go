@@ -0,0 +1,222 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/erfansahebi/lamia_auth/model"
+	"github.com/erfansahebi/lamia_auth/webauthn"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v4"
+	"github.com/redis/go-redis/v9"
+	"time"
+)
+
+// PasskeyRegistrationSubject is the subject of the passkey registration of
+// the session familyID.
+func PasskeyRegistrationSubject(familyID uuid.UUID) string {
+	return "passkey_registration." + familyID.String()
+}
+
+// PasskeyLoginSubject is the subject of the passwordless passkey login
+// sessionToken.
+func PasskeyLoginSubject(sessionToken string) string {
+	return "passkey_login." + sessionToken
+}
+
+// StoreWebAuthnCredential stores a newly registered passkey. A credential ID
+// that is already registered is rejected with ErrPasskeyExists.
+func (a *auth) StoreWebAuthnCredential(ctx context.Context, credential model.WebAuthnCredential) (storedCredential model.WebAuthnCredential, err error) {
+	err = a.pgx.QueryRow(
+		ctx,
+		`INSERT INTO webauthn_credentials (
+					user_id,
+					credential_id,
+					public_key,
+					sign_count,
+					aaguid,
+					transports,
+					name
+			) VALUES (
+					$1, $2, $3, $4, $5, $6, $7
+			) ON CONFLICT (credential_id) DO NOTHING
+			RETURNING id, created_at`,
+		credential.UserID,
+		credential.CredentialID,
+		credential.PublicKey,
+		credential.SignCount,
+		credential.AAGUID,
+		credential.Transports,
+		credential.Name,
+	).Scan(&credential.ID, &credential.CreatedAt)
+	switch {
+	case err == pgx.ErrNoRows:
+		return model.WebAuthnCredential{}, ErrPasskeyExists
+	case err != nil:
+		return model.WebAuthnCredential{}, err
+	}
+
+	return credential, nil
+}
+
+func (a *auth) FetchWebAuthnCredential(ctx context.Context, credentialID []byte) (fetchedCredential model.WebAuthnCredential, err error) {
+	row := a.pgx.QueryRow(
+		ctx,
+		`SELECT id,
+					user_id,
+					credential_id,
+					public_key,
+					sign_count,
+					aaguid,
+					transports,
+					name,
+					clone_detected_at,
+					last_used_at,
+					created_at
+			FROM webauthn_credentials
+			WHERE credential_id = $1`,
+		credentialID,
+	)
+
+	fetchedCredential, err = scanWebAuthnCredential(row)
+	switch {
+	case err == pgx.ErrNoRows:
+		return model.WebAuthnCredential{}, ErrEntryNotFound
+	case err != nil:
+		return model.WebAuthnCredential{}, err
+	}
+
+	return fetchedCredential, nil
+}
+
+func (a *auth) FetchUserWebAuthnCredentials(ctx context.Context, userID uuid.UUID) (credentials []model.WebAuthnCredential, err error) {
+	rows, err := a.pgx.Query(
+		ctx,
+		`SELECT id,
+					user_id,
+					credential_id,
+					public_key,
+					sign_count,
+					aaguid,
+					transports,
+					name,
+					clone_detected_at,
+					last_used_at,
+					created_at
+			FROM webauthn_credentials
+			WHERE user_id = $1
+			ORDER BY created_at`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		credential, err := scanWebAuthnCredential(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		credentials = append(credentials, credential)
+	}
+
+	return credentials, rows.Err()
+}
+
+func scanWebAuthnCredential(row pgx.Row) (credential model.WebAuthnCredential, err error) {
+	err = row.Scan(
+		&credential.ID,
+		&credential.UserID,
+		&credential.CredentialID,
+		&credential.PublicKey,
+		&credential.SignCount,
+		&credential.AAGUID,
+		&credential.Transports,
+		&credential.Name,
+		&credential.CloneDetectedAt,
+		&credential.LastUsedAt,
+		&credential.CreatedAt,
+	)
+
+	return credential, err
+}
+
+// UseWebAuthnCredential records a login with the passkey id that reported
+// signCount.
+func (a *auth) UseWebAuthnCredential(ctx context.Context, id uuid.UUID, signCount int64) error {
+	_, err := a.pgx.Exec(
+		ctx,
+		`UPDATE webauthn_credentials
+			SET sign_count = $2,
+				last_used_at = NOW()
+			WHERE id = $1`,
+		id,
+		signCount,
+	)
+
+	return err
+}
+
+// MarkWebAuthnCredentialCloned disables the passkey id after its sign count
+// went backwards.
+func (a *auth) MarkWebAuthnCredentialCloned(ctx context.Context, id uuid.UUID) error {
+	_, err := a.pgx.Exec(
+		ctx,
+		`UPDATE webauthn_credentials
+			SET clone_detected_at = NOW()
+			WHERE id = $1 AND clone_detected_at IS NULL`,
+		id,
+	)
+
+	return err
+}
+
+// StoreWebAuthnSession keeps the state of a ceremony for subject until its
+// response arrives, replacing one that is pending.
+func (a *auth) StoreWebAuthnSession(ctx context.Context, subject string, session webauthn.Session, expireDuration time.Duration) error {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return err
+	}
+
+	return a.redis.Set(ctx, a.generateWebAuthnSessionKey(subject), data, expireDuration).Err()
+}
+
+// StorePasskeyLoginSession keeps the state of a passwordless login and
+// returns the token it is answered with.
+func (a *auth) StorePasskeyLoginSession(ctx context.Context, session webauthn.Session, expireDuration time.Duration) (sessionToken string, err error) {
+	sessionToken, err = generateOpaqueToken(TokenPrefixPasskeyLogin)
+	if err != nil {
+		return "", err
+	}
+
+	if err = a.StoreWebAuthnSession(ctx, PasskeyLoginSubject(sessionToken), session, expireDuration); err != nil {
+		return "", err
+	}
+
+	return sessionToken, nil
+}
+
+// ConsumeWebAuthnSession returns the pending ceremony of subject and drops
+// it, so that every challenge is answered at most once.
+func (a *auth) ConsumeWebAuthnSession(ctx context.Context, subject string) (session webauthn.Session, err error) {
+	data, err := a.redis.GetDel(ctx, a.generateWebAuthnSessionKey(subject)).Result()
+	switch {
+	case err == redis.Nil:
+		return webauthn.Session{}, ErrEntryNotFound
+	case err != nil:
+		return webauthn.Session{}, err
+	}
+
+	if err = json.Unmarshal([]byte(data), &session); err != nil {
+		return webauthn.Session{}, err
+	}
+
+	return session, nil
+}
+
+func (a *auth) generateWebAuthnSessionKey(subject string) string {
+	return fmt.Sprintf("webauthn_session.%s", a.hashToken(subject))
+}
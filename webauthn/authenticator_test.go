@@ -0,0 +1,246 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"testing"
+)
+
+// softwareAuthenticator is a passkey kept in memory. It answers ceremonies
+// the way a platform authenticator behind a browser at origin would, so
+// that the relying party can be tested without either of them.
+type softwareAuthenticator struct {
+	rpID         string
+	origin       string
+	algorithm    int64
+	privateKey   crypto.Signer
+	credentialID []byte
+	userHandle   []byte
+	signCount    uint32
+	flags        byte
+}
+
+func newSoftwareAuthenticator(t *testing.T, algorithm int64, rpID string, origin string) *softwareAuthenticator {
+	t.Helper()
+
+	var (
+		privateKey crypto.Signer
+		err        error
+	)
+
+	switch algorithm {
+	case AlgorithmEdDSA:
+		_, privateKey, err = ed25519.GenerateKey(rand.Reader)
+	case AlgorithmES256:
+		privateKey, err = ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	default:
+		t.Fatalf("unsupported algorithm %d", algorithm)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credentialID := make([]byte, 16)
+	if _, err = rand.Read(credentialID); err != nil {
+		t.Fatal(err)
+	}
+
+	return &softwareAuthenticator{
+		rpID:         rpID,
+		origin:       origin,
+		algorithm:    algorithm,
+		privateKey:   privateKey,
+		credentialID: credentialID,
+		flags:        flagUserPresent | flagUserVerified,
+	}
+}
+
+// clone returns an authenticator holding the same key and sign count, as an
+// attacker who copied the key would have.
+func (a *softwareAuthenticator) clone() *softwareAuthenticator {
+	cloned := *a
+	return &cloned
+}
+
+// create answers navigator.credentials.create with options.
+func (a *softwareAuthenticator) create(t *testing.T, options CreationOptions) []byte {
+	t.Helper()
+
+	a.userHandle = options.User.ID
+
+	authenticatorData := a.authenticatorData(flagAttestedData)
+	authenticatorData = append(authenticatorData, make([]byte, 16)...)
+	authenticatorData = binary.BigEndian.AppendUint16(authenticatorData, uint16(len(a.credentialID)))
+	authenticatorData = append(authenticatorData, a.credentialID...)
+	authenticatorData = append(authenticatorData, a.publicKey()...)
+
+	return a.response(t, map[string]interface{}{
+		"clientDataJSON": a.clientData(t, clientDataTypeCreate, options.Challenge),
+		"attestationObject": Base64URL(encodeCBOR(cborMap{
+			{"fmt", "none"},
+			{"attStmt", cborMap{}},
+			{"authData", authenticatorData},
+		})),
+		"transports": []string{"internal"},
+	})
+}
+
+// get answers navigator.credentials.get with options, counting the use.
+func (a *softwareAuthenticator) get(t *testing.T, options RequestOptions) []byte {
+	t.Helper()
+
+	a.signCount++
+
+	clientDataJSON := a.clientData(t, clientDataTypeGet, options.Challenge)
+	authenticatorData := a.authenticatorData(0)
+
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signedData := append(append([]byte{}, authenticatorData...), clientDataHash[:]...)
+
+	return a.response(t, map[string]interface{}{
+		"clientDataJSON":    clientDataJSON,
+		"authenticatorData": Base64URL(authenticatorData),
+		"signature":         Base64URL(a.sign(t, signedData)),
+		"userHandle":        Base64URL(a.userHandle),
+	})
+}
+
+func (a *softwareAuthenticator) authenticatorData(flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(a.rpID))
+
+	data := append([]byte{}, rpIDHash[:]...)
+	data = append(data, a.flags|flags)
+
+	return binary.BigEndian.AppendUint32(data, a.signCount)
+}
+
+func (a *softwareAuthenticator) clientData(t *testing.T, clientDataType string, challenge []byte) Base64URL {
+	t.Helper()
+
+	clientDataJSON, err := json.Marshal(map[string]interface{}{
+		"type":        clientDataType,
+		"challenge":   Base64URL(challenge),
+		"origin":      a.origin,
+		"crossOrigin": false,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return clientDataJSON
+}
+
+func (a *softwareAuthenticator) response(t *testing.T, response map[string]interface{}) []byte {
+	t.Helper()
+
+	encoded, err := json.Marshal(map[string]interface{}{
+		"id":       Base64URL(a.credentialID),
+		"rawId":    Base64URL(a.credentialID),
+		"type":     credentialType,
+		"response": response,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return encoded
+}
+
+// publicKey returns the COSE_Key of the authenticator.
+func (a *softwareAuthenticator) publicKey() []byte {
+	switch key := a.privateKey.Public().(type) {
+	case ed25519.PublicKey:
+		return encodeCBOR(cborMap{
+			{int64(1), int64(coseKeyTypeOKP)},
+			{int64(3), a.algorithm},
+			{int64(-1), int64(coseCurveEd25519)},
+			{int64(-2), []byte(key)},
+		})
+	case *ecdsa.PublicKey:
+		return encodeCBOR(cborMap{
+			{int64(1), int64(coseKeyTypeEC2)},
+			{int64(3), a.algorithm},
+			{int64(-1), int64(coseCurveP256)},
+			{int64(-2), key.X.FillBytes(make([]byte, 32))},
+			{int64(-3), key.Y.FillBytes(make([]byte, 32))},
+		})
+	default:
+		return nil
+	}
+}
+
+func (a *softwareAuthenticator) sign(t *testing.T, data []byte) []byte {
+	t.Helper()
+
+	var (
+		signature []byte
+		err       error
+	)
+
+	switch key := a.privateKey.(type) {
+	case ed25519.PrivateKey:
+		signature = ed25519.Sign(key, data)
+	case *ecdsa.PrivateKey:
+		digest := sha256.Sum256(data)
+		signature, err = ecdsa.SignASN1(rand.Reader, key, digest[:])
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return signature
+}
+
+// cborMap is a CBOR map whose entries are encoded in the given order.
+type cborMap []cborPair
+
+type cborPair struct {
+	key   interface{}
+	value interface{}
+}
+
+// encodeCBOR encodes the items authenticators produce: integers, byte and
+// text strings, and maps of them.
+func encodeCBOR(item interface{}) []byte {
+	switch value := item.(type) {
+	case int64:
+		if value < 0 {
+			return cborHeader(1, uint64(-1-value))
+		}
+
+		return cborHeader(0, uint64(value))
+	case []byte:
+		return append(cborHeader(2, uint64(len(value))), value...)
+	case string:
+		return append(cborHeader(3, uint64(len(value))), value...)
+	case cborMap:
+		encoded := cborHeader(5, uint64(len(value)))
+		for _, pair := range value {
+			encoded = append(encoded, encodeCBOR(pair.key)...)
+			encoded = append(encoded, encodeCBOR(pair.value)...)
+		}
+
+		return encoded
+	default:
+		panic("unsupported cbor item")
+	}
+}
+
+func cborHeader(majorType byte, argument uint64) []byte {
+	switch {
+	case argument < 24:
+		return []byte{majorType<<5 | byte(argument)}
+	case argument <= 0xff:
+		return []byte{majorType<<5 | 24, byte(argument)}
+	case argument <= 0xffff:
+		return binary.BigEndian.AppendUint16([]byte{majorType<<5 | 25}, uint16(argument))
+	default:
+		return binary.BigEndian.AppendUint32([]byte{majorType<<5 | 26}, uint32(argument))
+	}
+}
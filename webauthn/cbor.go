@@ -0,0 +1,186 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// maxCBORDepth bounds the nesting of decoded items. Authenticator responses
+// never go deeper than a few levels.
+const maxCBORDepth = 16
+
+var errCBOR = errors.New("malformed cbor")
+
+// cborDecoder decodes the subset of CBOR authenticators emit: definite
+// length integers, byte and text strings, arrays, maps and simple values.
+// Integers decode to int64, maps to map[interface{}]interface{} keyed by
+// int64 or string.
+type cborDecoder struct {
+	data []byte
+	pos  int
+}
+
+// decodeCBOR decodes the first item of data and returns the bytes after it.
+func decodeCBOR(data []byte) (item interface{}, rest []byte, err error) {
+	d := &cborDecoder{data: data}
+
+	item, err = d.decode(0)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return item, data[d.pos:], nil
+}
+
+func (d *cborDecoder) decode(depth int) (interface{}, error) {
+	if depth > maxCBORDepth || d.pos >= len(d.data) {
+		return nil, errCBOR
+	}
+
+	initial := d.data[d.pos]
+	d.pos++
+
+	majorType, info := initial>>5, initial&0x1f
+
+	if majorType == 7 {
+		switch info {
+		case 20:
+			return false, nil
+		case 21:
+			return true, nil
+		case 22, 23:
+			return nil, nil
+		default:
+			return nil, errCBOR
+		}
+	}
+
+	argument, err := d.argument(info)
+	if err != nil {
+		return nil, err
+	}
+
+	switch majorType {
+	case 0:
+		if argument > math.MaxInt64 {
+			return nil, errCBOR
+		}
+
+		return int64(argument), nil
+	case 1:
+		if argument > math.MaxInt64 {
+			return nil, errCBOR
+		}
+
+		return -1 - int64(argument), nil
+	case 2, 3:
+		value, err := d.bytes(argument)
+		if err != nil {
+			return nil, err
+		}
+
+		if majorType == 3 {
+			return string(value), nil
+		}
+
+		return value, nil
+	case 4:
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+
+		items := make([]interface{}, 0, argument)
+		for i := uint64(0); i < argument; i++ {
+			item, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			items = append(items, item)
+		}
+
+		return items, nil
+	case 5:
+		if argument > uint64(len(d.data)-d.pos) {
+			return nil, errCBOR
+		}
+
+		items := make(map[interface{}]interface{}, argument)
+		for i := uint64(0); i < argument; i++ {
+			key, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, errCBOR
+			}
+
+			value, err := d.decode(depth + 1)
+			if err != nil {
+				return nil, err
+			}
+
+			items[key] = value
+		}
+
+		return items, nil
+	case 6:
+		// Tags carry no meaning for the items read here.
+		return d.decode(depth + 1)
+	default:
+		return nil, errCBOR
+	}
+}
+
+func (d *cborDecoder) argument(info byte) (uint64, error) {
+	switch {
+	case info < 24:
+		return uint64(info), nil
+	case info == 24:
+		value, err := d.bytes(1)
+		if err != nil {
+			return 0, err
+		}
+
+		return uint64(value[0]), nil
+	case info == 25:
+		value, err := d.bytes(2)
+		if err != nil {
+			return 0, err
+		}
+
+		return uint64(binary.BigEndian.Uint16(value)), nil
+	case info == 26:
+		value, err := d.bytes(4)
+		if err != nil {
+			return 0, err
+		}
+
+		return uint64(binary.BigEndian.Uint32(value)), nil
+	case info == 27:
+		value, err := d.bytes(8)
+		if err != nil {
+			return 0, err
+		}
+
+		return binary.BigEndian.Uint64(value), nil
+	default:
+		// Indefinite lengths are not used by authenticators.
+		return 0, errCBOR
+	}
+}
+
+func (d *cborDecoder) bytes(length uint64) ([]byte, error) {
+	if length > uint64(len(d.data)-d.pos) {
+		return nil, errCBOR
+	}
+
+	value := d.data[d.pos : d.pos+int(length)]
+	d.pos += int(length)
+
+	return value, nil
+}
@@ -0,0 +1,113 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"math/big"
+)
+
+// COSE algorithms offered to authenticators, in order of preference.
+const (
+	AlgorithmEdDSA = -8
+	AlgorithmES256 = -7
+	AlgorithmRS256 = -257
+)
+
+const (
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+
+	minRSAKeyBits = 2048
+)
+
+// publicKey is a credential public key that checks assertion signatures.
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parsePublicKey parses a COSE_Key as found in attested credential data.
+func parsePublicKey(encoded []byte) (publicKey, error) {
+	item, rest, err := decodeCBOR(encoded)
+	if err != nil || len(rest) != 0 {
+		return publicKey{}, ErrInvalidResponse
+	}
+
+	coseKey, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return publicKey{}, ErrInvalidResponse
+	}
+
+	keyType, _ := coseKey[int64(1)].(int64)
+	algorithm, _ := coseKey[int64(3)].(int64)
+
+	switch {
+	case keyType == coseKeyTypeOKP && algorithm == AlgorithmEdDSA:
+		curve, _ := coseKey[int64(-1)].(int64)
+		x, _ := coseKey[int64(-2)].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return publicKey{}, ErrInvalidResponse
+		}
+
+		return publicKey{algorithm: algorithm, key: ed25519.PublicKey(x)}, nil
+	case keyType == coseKeyTypeEC2 && algorithm == AlgorithmES256:
+		curve, _ := coseKey[int64(-1)].(int64)
+		x, _ := coseKey[int64(-2)].([]byte)
+		y, _ := coseKey[int64(-3)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return publicKey{}, ErrInvalidResponse
+		}
+
+		key := &ecdsa.PublicKey{
+			Curve: elliptic.P256(),
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return publicKey{}, ErrInvalidResponse
+		}
+
+		return publicKey{algorithm: algorithm, key: key}, nil
+	case keyType == coseKeyTypeRSA && algorithm == AlgorithmRS256:
+		n, _ := coseKey[int64(-1)].([]byte)
+		e, _ := coseKey[int64(-2)].([]byte)
+		if len(e) == 0 || len(e) > 4 {
+			return publicKey{}, ErrInvalidResponse
+		}
+
+		key := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		if key.N.BitLen() < minRSAKeyBits || key.E < 3 {
+			return publicKey{}, ErrInvalidResponse
+		}
+
+		return publicKey{algorithm: algorithm, key: key}, nil
+	default:
+		return publicKey{}, ErrUnsupportedAlgorithm
+	}
+}
+
+func (k publicKey) verify(data []byte, signature []byte) bool {
+	switch key := k.key.(type) {
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, data, signature)
+	case *ecdsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return ecdsa.VerifyASN1(key, digest[:], signature)
+	case *rsa.PublicKey:
+		digest := sha256.Sum256(data)
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return false
+	}
+}
@@ -0,0 +1,493 @@
+package webauthn
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+var (
+	ErrInvalidConfig        = errors.New("webauthn relying party id and origins must be set")
+	ErrInvalidResponse      = errors.New("webauthn response is malformed")
+	ErrVerificationFailed   = errors.New("webauthn response could not be verified")
+	ErrUnsupportedAlgorithm = errors.New("webauthn credential algorithm is not supported")
+	ErrCloneDetected        = errors.New("webauthn sign count did not increase, the authenticator may be cloned")
+)
+
+const (
+	UserVerificationRequired  = "required"
+	UserVerificationPreferred = "preferred"
+)
+
+const (
+	credentialType = "public-key"
+
+	clientDataTypeCreate = "webauthn.create"
+	clientDataTypeGet    = "webauthn.get"
+
+	challengeSize       = 32
+	maxCredentialIDSize = 1023
+	maxTransports       = 8
+
+	flagUserPresent       = 0x01
+	flagUserVerified      = 0x04
+	flagAttestedData      = 0x40
+	flagExtensionDataIncl = 0x80
+)
+
+// Config describes the relying party. Origins are the exact web origins,
+// such as https://example.com, that ceremonies are accepted from.
+type Config struct {
+	RPID    string
+	RPName  string
+	Origins []string
+	Timeout time.Duration
+}
+
+// User is the account a credential is registered for. ID becomes the user
+// handle that authenticators return on discoverable logins.
+type User struct {
+	ID          []byte
+	Name        string
+	DisplayName string
+}
+
+// Session is the server side state of a ceremony, kept until its response
+// arrives. UserID is empty for logins that let the authenticator pick the
+// account.
+type Session struct {
+	Challenge            Base64URL   `json:"challenge"`
+	UserID               Base64URL   `json:"user_id,omitempty"`
+	AllowedCredentialIDs []Base64URL `json:"allowed_credential_ids,omitempty"`
+	UserVerification     string      `json:"user_verification"`
+}
+
+// Credential is a registered public key credential. PublicKey is the COSE
+// encoded key and UserHandle the user ID it was registered for.
+type Credential struct {
+	ID         []byte
+	UserHandle []byte
+	PublicKey  []byte
+	SignCount  uint32
+	AAGUID     []byte
+	Transports []string
+}
+
+// Assertion is a parsed login response. The credential it names has to be
+// looked up before it can be verified with FinishAssertion.
+type Assertion struct {
+	CredentialID []byte
+	UserHandle   []byte
+
+	clientDataJSON    []byte
+	authenticatorData []byte
+	signature         []byte
+}
+
+// RelyingPartyInterface runs registration and assertion ceremonies. Begin
+// methods return options for navigator.credentials in their JSON form and
+// the Session to verify the response against. Attestation statements are
+// not verified: options ask for none and the key is trusted because the
+// signed in user registered it.
+type RelyingPartyInterface interface {
+	BeginRegistration(user User, excludeCredentialIDs [][]byte) (options CreationOptions, session Session, err error)
+	FinishRegistration(session Session, response []byte) (credential Credential, err error)
+
+	BeginAssertion(userID []byte, allowedCredentialIDs [][]byte, userVerification string) (options RequestOptions, session Session, err error)
+	ParseAssertion(response []byte) (assertion Assertion, err error)
+	FinishAssertion(session Session, assertion Assertion, credential Credential) (signCount uint32, err error)
+}
+
+type relyingParty struct {
+	config   Config
+	rpIDHash [32]byte
+}
+
+func NewRelyingParty(config Config) (RelyingPartyInterface, error) {
+	if config.RPID == "" || len(config.Origins) == 0 {
+		return nil, ErrInvalidConfig
+	}
+
+	if config.RPName == "" {
+		config.RPName = config.RPID
+	}
+
+	return &relyingParty{
+		config:   config,
+		rpIDHash: sha256.Sum256([]byte(config.RPID)),
+	}, nil
+}
+
+func (rp *relyingParty) BeginRegistration(user User, excludeCredentialIDs [][]byte) (CreationOptions, Session, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return CreationOptions{}, Session{}, err
+	}
+
+	options := CreationOptions{
+		RP: RelyingPartyEntity{
+			ID:   rp.config.RPID,
+			Name: rp.config.RPName,
+		},
+		User: UserEntity{
+			ID:          user.ID,
+			Name:        user.Name,
+			DisplayName: user.DisplayName,
+		},
+		Challenge: challenge,
+		PubKeyCredParams: []CredentialParameter{
+			{Type: credentialType, Alg: AlgorithmEdDSA},
+			{Type: credentialType, Alg: AlgorithmES256},
+			{Type: credentialType, Alg: AlgorithmRS256},
+		},
+		Timeout:            rp.config.Timeout.Milliseconds(),
+		ExcludeCredentials: credentialDescriptors(excludeCredentialIDs),
+		AuthenticatorSelection: AuthenticatorSelection{
+			ResidentKey:        "required",
+			RequireResidentKey: true,
+			UserVerification:   UserVerificationRequired,
+		},
+		Attestation: "none",
+	}
+
+	return options, Session{
+		Challenge:        challenge,
+		UserID:           user.ID,
+		UserVerification: UserVerificationRequired,
+	}, nil
+}
+
+func (rp *relyingParty) FinishRegistration(session Session, response []byte) (Credential, error) {
+	var parsed struct {
+		RawID    Base64URL `json:"rawId"`
+		Type     string    `json:"type"`
+		Response struct {
+			ClientDataJSON    Base64URL `json:"clientDataJSON"`
+			AttestationObject Base64URL `json:"attestationObject"`
+			Transports        []string  `json:"transports"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(response, &parsed); err != nil || parsed.Type != credentialType {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	if err := rp.verifyClientData(parsed.Response.ClientDataJSON, clientDataTypeCreate, session.Challenge); err != nil {
+		return Credential{}, err
+	}
+
+	item, rest, err := decodeCBOR(parsed.Response.AttestationObject)
+	if err != nil || len(rest) != 0 {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	attestationObject, ok := item.(map[interface{}]interface{})
+	if !ok {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	rawAuthenticatorData, ok := attestationObject["authData"].([]byte)
+	if !ok {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	authenticatorData, err := parseAuthenticatorData(rawAuthenticatorData)
+	if err != nil {
+		return Credential{}, err
+	}
+
+	if err = rp.verifyAuthenticatorData(authenticatorData, session.UserVerification); err != nil {
+		return Credential{}, err
+	}
+
+	if authenticatorData.flags&flagAttestedData == 0 {
+		return Credential{}, ErrInvalidResponse
+	}
+
+	if !bytes.Equal(parsed.RawID, authenticatorData.credentialID) {
+		return Credential{}, ErrVerificationFailed
+	}
+
+	if _, err = parsePublicKey(authenticatorData.credentialPublicKey); err != nil {
+		return Credential{}, err
+	}
+
+	transports := parsed.Response.Transports
+	if len(transports) > maxTransports {
+		transports = transports[:maxTransports]
+	}
+
+	return Credential{
+		ID:         authenticatorData.credentialID,
+		UserHandle: session.UserID,
+		PublicKey:  authenticatorData.credentialPublicKey,
+		SignCount:  authenticatorData.signCount,
+		AAGUID:     authenticatorData.aaguid,
+		Transports: transports,
+	}, nil
+}
+
+func (rp *relyingParty) BeginAssertion(userID []byte, allowedCredentialIDs [][]byte, userVerification string) (RequestOptions, Session, error) {
+	challenge, err := newChallenge()
+	if err != nil {
+		return RequestOptions{}, Session{}, err
+	}
+
+	if userVerification == "" {
+		userVerification = UserVerificationPreferred
+	}
+
+	allowed := make([]Base64URL, 0, len(allowedCredentialIDs))
+	for _, credentialID := range allowedCredentialIDs {
+		allowed = append(allowed, credentialID)
+	}
+
+	options := RequestOptions{
+		Challenge:        challenge,
+		Timeout:          rp.config.Timeout.Milliseconds(),
+		RPID:             rp.config.RPID,
+		AllowCredentials: credentialDescriptors(allowedCredentialIDs),
+		UserVerification: userVerification,
+	}
+
+	return options, Session{
+		Challenge:            challenge,
+		UserID:               userID,
+		AllowedCredentialIDs: allowed,
+		UserVerification:     userVerification,
+	}, nil
+}
+
+func (rp *relyingParty) ParseAssertion(response []byte) (Assertion, error) {
+	var parsed struct {
+		RawID    Base64URL `json:"rawId"`
+		Type     string    `json:"type"`
+		Response struct {
+			ClientDataJSON    Base64URL `json:"clientDataJSON"`
+			AuthenticatorData Base64URL `json:"authenticatorData"`
+			Signature         Base64URL `json:"signature"`
+			UserHandle        Base64URL `json:"userHandle"`
+		} `json:"response"`
+	}
+	if err := json.Unmarshal(response, &parsed); err != nil || parsed.Type != credentialType {
+		return Assertion{}, ErrInvalidResponse
+	}
+
+	if len(parsed.RawID) == 0 || len(parsed.RawID) > maxCredentialIDSize {
+		return Assertion{}, ErrInvalidResponse
+	}
+
+	return Assertion{
+		CredentialID:      parsed.RawID,
+		UserHandle:        parsed.Response.UserHandle,
+		clientDataJSON:    parsed.Response.ClientDataJSON,
+		authenticatorData: parsed.Response.AuthenticatorData,
+		signature:         parsed.Response.Signature,
+	}, nil
+}
+
+// FinishAssertion verifies assertion against session and the stored
+// credential, and returns the sign count to store. A sign count that did
+// not increase fails with ErrCloneDetected, unless the authenticator does
+// not keep one.
+func (rp *relyingParty) FinishAssertion(session Session, assertion Assertion, credential Credential) (uint32, error) {
+	if !bytes.Equal(assertion.CredentialID, credential.ID) {
+		return 0, ErrVerificationFailed
+	}
+
+	if len(session.AllowedCredentialIDs) > 0 && !containsCredentialID(session.AllowedCredentialIDs, credential.ID) {
+		return 0, ErrVerificationFailed
+	}
+
+	switch {
+	case len(session.UserID) > 0 && !bytes.Equal(session.UserID, credential.UserHandle):
+		return 0, ErrVerificationFailed
+	case len(session.UserID) == 0 && len(assertion.UserHandle) == 0:
+		return 0, ErrVerificationFailed
+	case len(assertion.UserHandle) > 0 && !bytes.Equal(assertion.UserHandle, credential.UserHandle):
+		return 0, ErrVerificationFailed
+	}
+
+	if err := rp.verifyClientData(assertion.clientDataJSON, clientDataTypeGet, session.Challenge); err != nil {
+		return 0, err
+	}
+
+	authenticatorData, err := parseAuthenticatorData(assertion.authenticatorData)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = rp.verifyAuthenticatorData(authenticatorData, session.UserVerification); err != nil {
+		return 0, err
+	}
+
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return 0, err
+	}
+
+	clientDataHash := sha256.Sum256(assertion.clientDataJSON)
+	signedData := append(append([]byte{}, assertion.authenticatorData...), clientDataHash[:]...)
+	if !key.verify(signedData, assertion.signature) {
+		return 0, ErrVerificationFailed
+	}
+
+	if (authenticatorData.signCount != 0 || credential.SignCount != 0) && authenticatorData.signCount <= credential.SignCount {
+		return 0, ErrCloneDetected
+	}
+
+	return authenticatorData.signCount, nil
+}
+
+func (rp *relyingParty) verifyClientData(clientDataJSON []byte, expectedType string, challenge []byte) error {
+	var clientData struct {
+		Type        string `json:"type"`
+		Challenge   string `json:"challenge"`
+		Origin      string `json:"origin"`
+		CrossOrigin bool   `json:"crossOrigin"`
+	}
+	if err := json.Unmarshal(clientDataJSON, &clientData); err != nil {
+		return ErrInvalidResponse
+	}
+
+	receivedChallenge, err := decodeBase64URL(clientData.Challenge)
+	if err != nil {
+		return ErrInvalidResponse
+	}
+
+	switch {
+	case clientData.Type != expectedType,
+		len(challenge) == 0 || !bytes.Equal(receivedChallenge, challenge),
+		clientData.CrossOrigin,
+		!containsOrigin(rp.config.Origins, clientData.Origin):
+		return ErrVerificationFailed
+	}
+
+	return nil
+}
+
+func (rp *relyingParty) verifyAuthenticatorData(data authenticatorData, userVerification string) error {
+	switch {
+	case !bytes.Equal(data.rpIDHash, rp.rpIDHash[:]),
+		data.flags&flagUserPresent == 0,
+		userVerification == UserVerificationRequired && data.flags&flagUserVerified == 0:
+		return ErrVerificationFailed
+	}
+
+	return nil
+}
+
+type authenticatorData struct {
+	rpIDHash            []byte
+	flags               byte
+	signCount           uint32
+	aaguid              []byte
+	credentialID        []byte
+	credentialPublicKey []byte
+}
+
+// parseAuthenticatorData parses the fixed header and, when present, the
+// attested credential data. Extension outputs are skipped.
+func parseAuthenticatorData(raw []byte) (authenticatorData, error) {
+	if len(raw) < 37 {
+		return authenticatorData{}, ErrInvalidResponse
+	}
+
+	data := authenticatorData{
+		rpIDHash:  raw[:32],
+		flags:     raw[32],
+		signCount: binary.BigEndian.Uint32(raw[33:37]),
+	}
+
+	rest := raw[37:]
+
+	if data.flags&flagAttestedData != 0 {
+		if len(rest) < 18 {
+			return authenticatorData{}, ErrInvalidResponse
+		}
+
+		data.aaguid = rest[:16]
+		credentialIDLength := int(binary.BigEndian.Uint16(rest[16:18]))
+		rest = rest[18:]
+
+		if credentialIDLength == 0 || credentialIDLength > maxCredentialIDSize || credentialIDLength > len(rest) {
+			return authenticatorData{}, ErrInvalidResponse
+		}
+
+		data.credentialID = rest[:credentialIDLength]
+		rest = rest[credentialIDLength:]
+
+		_, afterKey, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, ErrInvalidResponse
+		}
+
+		data.credentialPublicKey = rest[:len(rest)-len(afterKey)]
+		rest = afterKey
+	}
+
+	if data.flags&flagExtensionDataIncl != 0 {
+		_, afterExtensions, err := decodeCBOR(rest)
+		if err != nil {
+			return authenticatorData{}, ErrInvalidResponse
+		}
+
+		rest = afterExtensions
+	}
+
+	if len(rest) != 0 {
+		return authenticatorData{}, ErrInvalidResponse
+	}
+
+	return data, nil
+}
+
+func newChallenge() ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	if _, err := rand.Read(challenge); err != nil {
+		return nil, err
+	}
+
+	return challenge, nil
+}
+
+func credentialDescriptors(credentialIDs [][]byte) []CredentialDescriptor {
+	descriptors := make([]CredentialDescriptor, 0, len(credentialIDs))
+	for _, credentialID := range credentialIDs {
+		descriptors = append(descriptors, CredentialDescriptor{
+			Type: credentialType,
+			ID:   credentialID,
+		})
+	}
+
+	return descriptors
+}
+
+func containsCredentialID(credentialIDs []Base64URL, credentialID []byte) bool {
+	for _, id := range credentialIDs {
+		if bytes.Equal(id, credentialID) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsOrigin(origins []string, origin string) bool {
+	for _, allowed := range origins {
+		if strings.TrimSuffix(allowed, "/") == origin {
+			return true
+		}
+	}
+
+	return false
+}
+
+func decodeBase64URL(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}
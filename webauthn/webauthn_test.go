@@ -0,0 +1,196 @@
+package webauthn
+
+import (
+	"bytes"
+	"testing"
+)
+
+const (
+	testRPID   = "example.com"
+	testOrigin = "https://example.com"
+)
+
+func newTestRelyingParty(t *testing.T) RelyingPartyInterface {
+	t.Helper()
+
+	rp, err := NewRelyingParty(Config{RPID: testRPID, Origins: []string{testOrigin}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rp
+}
+
+// register runs a registration ceremony of authenticator for a new user.
+func register(t *testing.T, rp RelyingPartyInterface, authenticator *softwareAuthenticator) Credential {
+	t.Helper()
+
+	options, session, err := rp.BeginRegistration(User{ID: []byte("user-id"), Name: "user@example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential, err := rp.FinishRegistration(session, authenticator.create(t, options))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return credential
+}
+
+// assert runs a passwordless login ceremony of authenticator against the
+// stored credential.
+func assert(t *testing.T, rp RelyingPartyInterface, authenticator *softwareAuthenticator, credential Credential) (uint32, error) {
+	t.Helper()
+
+	options, session, err := rp.BeginAssertion(nil, nil, UserVerificationRequired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err := rp.ParseAssertion(authenticator.get(t, options))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rp.FinishAssertion(session, assertion, credential)
+}
+
+func TestRegistrationAndAssertion(t *testing.T) {
+	for name, algorithm := range map[string]int64{"EdDSA": AlgorithmEdDSA, "ES256": AlgorithmES256} {
+		t.Run(name, func(t *testing.T) {
+			rp := newTestRelyingParty(t)
+			authenticator := newSoftwareAuthenticator(t, algorithm, testRPID, testOrigin)
+
+			credential := register(t, rp, authenticator)
+			if !bytes.Equal(credential.ID, authenticator.credentialID) {
+				t.Fatalf("registered credential %x, want %x", credential.ID, authenticator.credentialID)
+			}
+
+			if !bytes.Equal(credential.UserHandle, []byte("user-id")) {
+				t.Fatalf("registered user handle %q, want %q", credential.UserHandle, "user-id")
+			}
+
+			for want := uint32(1); want <= 2; want++ {
+				signCount, err := assert(t, rp, authenticator, credential)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				if signCount != want {
+					t.Fatalf("got sign count %d, want %d", signCount, want)
+				}
+
+				credential.SignCount = signCount
+			}
+		})
+	}
+}
+
+func TestFinishRegistrationRejectsForeignResponses(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		modify func(authenticator *softwareAuthenticator)
+	}{
+		{name: "other origin", modify: func(a *softwareAuthenticator) { a.origin = "https://example.org" }},
+		{name: "other relying party", modify: func(a *softwareAuthenticator) { a.rpID = "example.org" }},
+		{name: "user not verified", modify: func(a *softwareAuthenticator) { a.flags = flagUserPresent }},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			rp := newTestRelyingParty(t)
+			authenticator := newSoftwareAuthenticator(t, AlgorithmEdDSA, testRPID, testOrigin)
+			test.modify(authenticator)
+
+			options, session, err := rp.BeginRegistration(User{ID: []byte("user-id"), Name: "user@example.com"}, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if _, err = rp.FinishRegistration(session, authenticator.create(t, options)); err != ErrVerificationFailed {
+				t.Fatalf("got %v, want %v", err, ErrVerificationFailed)
+			}
+		})
+	}
+}
+
+func TestFinishRegistrationRejectsOtherChallenge(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftwareAuthenticator(t, AlgorithmEdDSA, testRPID, testOrigin)
+
+	options, _, err := rp.BeginRegistration(User{ID: []byte("user-id"), Name: "user@example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, session, err := rp.BeginRegistration(User{ID: []byte("user-id"), Name: "user@example.com"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = rp.FinishRegistration(session, authenticator.create(t, options)); err != ErrVerificationFailed {
+		t.Fatalf("got %v, want %v", err, ErrVerificationFailed)
+	}
+}
+
+// A response to one login ceremony must not be accepted for another one.
+func TestFinishAssertionRejectsReplay(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftwareAuthenticator(t, AlgorithmEdDSA, testRPID, testOrigin)
+	credential := register(t, rp, authenticator)
+
+	options, _, err := rp.BeginAssertion(nil, nil, UserVerificationRequired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response := authenticator.get(t, options)
+
+	_, session, err := rp.BeginAssertion(nil, nil, UserVerificationRequired)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assertion, err := rp.ParseAssertion(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = rp.FinishAssertion(session, assertion, credential); err != ErrVerificationFailed {
+		t.Fatalf("got %v, want %v", err, ErrVerificationFailed)
+	}
+}
+
+func TestFinishAssertionRejectsOtherKey(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftwareAuthenticator(t, AlgorithmES256, testRPID, testOrigin)
+	credential := register(t, rp, authenticator)
+
+	impostor := newSoftwareAuthenticator(t, AlgorithmES256, testRPID, testOrigin)
+	impostor.credentialID = authenticator.credentialID
+	impostor.userHandle = authenticator.userHandle
+
+	if _, err := assert(t, rp, impostor, credential); err != ErrVerificationFailed {
+		t.Fatalf("got %v, want %v", err, ErrVerificationFailed)
+	}
+}
+
+// Once the original and a copy of a key have both been used, one of them
+// reports a sign count that did not increase.
+func TestFinishAssertionDetectsClones(t *testing.T) {
+	rp := newTestRelyingParty(t)
+	authenticator := newSoftwareAuthenticator(t, AlgorithmEdDSA, testRPID, testOrigin)
+	credential := register(t, rp, authenticator)
+
+	cloned := authenticator.clone()
+
+	signCount, err := assert(t, rp, authenticator, credential)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	credential.SignCount = signCount
+
+	if _, err = assert(t, rp, cloned, credential); err != ErrCloneDetected {
+		t.Fatalf("got %v, want %v", err, ErrCloneDetected)
+	}
+}